@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ichigo7diabol/go-test-wallet/internal/app"
+	"github.com/labstack/echo/v4"
+)
+
+var (
+	ErrInsufficientScope = errors.New("insufficient scope")
+)
+
+// ScopeError reports the scope an operation required but the token lacked.
+type ScopeError struct {
+	Operation string
+	Scope     string
+}
+
+func (e *ScopeError) Error() string {
+	return fmt.Sprintf("%s: %s requires %s", ErrInsufficientScope, e.Operation, e.Scope)
+}
+
+func (e *ScopeError) Is(target error) bool {
+	return target == ErrInsufficientScope
+}
+
+// operationScopes is the scope each operation requires, keyed by openapi
+// operationId. changeWallet depends on the operation type, see
+// changeWalletScopes.
+var operationScopes = map[string]string{
+	"listWallets":            app.ReadScope,
+	"getWallet":              app.ReadScope,
+	"listWalletTransactions": app.ReadScope,
+	"getHold":                app.ReadScope,
+	"createWallet":           app.CreateScope,
+	"createTransfer":         app.TransferScope,
+	"authorizeHold":          app.HoldScope,
+	"captureHold":            app.HoldScope,
+	"voidHold":               app.HoldScope,
+	"deleteWallet":           app.AdminScope,
+}
+
+var changeWalletScopes = map[app.WalletOperation]string{
+	app.DepositOperation:  app.DepositScope,
+	app.WithdrawOperation: app.WithdrawScope,
+}
+
+// authorize checks the caller's token against the scope operationId requires.
+func authorize(ctx echo.Context, operationId string) error {
+	return requireScope(ctx, operationId, operationScopes[operationId])
+}
+
+// authorizeChangeWallet checks the scope for a DEPOSIT or WITHDRAW. Unknown
+// operations pass here and are rejected by the service.
+func authorizeChangeWallet(ctx echo.Context, op app.WalletOperation) error {
+	scope, ok := changeWalletScopes[op]
+	if !ok {
+		return nil
+	}
+	return requireScope(ctx, "changeWallet", scope)
+}
+
+func requireScope(ctx echo.Context, operationId string, scope string) error {
+	if principal(ctx).Allows(scope) {
+		return nil
+	}
+	ctx.Response().Header().Set(echo.HeaderWWWAuthenticate,
+		fmt.Sprintf(`Bearer realm="wallet", error="insufficient_scope", scope=%q`, scope))
+	return NewHttpError(&ScopeError{Operation: operationId, Scope: scope})
+}
@@ -0,0 +1,78 @@
+//go:build unit
+
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/ichigo7diabol/go-test-wallet/api/handlers"
+	"github.com/ichigo7diabol/go-test-wallet/api/middleware"
+	"github.com/ichigo7diabol/go-test-wallet/api/openapi"
+	"github.com/ichigo7diabol/go-test-wallet/internal/app"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newServer routes requests to a handler whose service is never reached:
+// every request below is rejected by the scope check first.
+func newServer(scopes ...string) *echo.Echo {
+	e := echo.New()
+	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			middleware.SetPrincipal(c, &app.Principal{Subject: "user-1", Scopes: scopes})
+			return next(c)
+		}
+	})
+	h := handlers.NewWalletHandler(app.NewWalletService(nil, app.WalletServiceOptions{}))
+	openapi.RegisterHandlers(e, h)
+	return e
+}
+
+func call(e *echo.Echo, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAuthorize_MissingScope(t *testing.T) {
+	walletID := uuid.New().String()
+	deposit := `{"walletId":"` + walletID + `","operationType":"DEPOSIT","amount":"1","currency":"USD"}`
+	withdraw := `{"walletId":"` + walletID + `","operationType":"WITHDRAW","amount":"1","currency":"USD"}`
+
+	cases := []struct {
+		name      string
+		scopes    []string
+		method    string
+		path      string
+		body      string
+		operation string
+		scope     string
+	}{
+		{"read-only deposit", []string{app.ReadScope}, http.MethodPost, "/wallet", deposit, "changeWallet", app.DepositScope},
+		{"deposit-only withdraw", []string{app.DepositScope}, http.MethodPost, "/wallet", withdraw, "changeWallet", app.WithdrawScope},
+		{"deposit-only read", []string{app.DepositScope}, http.MethodGet, "/wallet/" + walletID, "", "getWallet", app.ReadScope},
+		{"read-only create", []string{app.ReadScope}, http.MethodPost, "/wallets", `{"initialBalance":"1"}`, "createWallet", app.CreateScope},
+		{"owner delete", []string{app.ReadScope, app.CreateScope}, http.MethodDelete, "/wallet/" + walletID, "", "deleteWallet", app.AdminScope},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := call(newServer(tc.scopes...), tc.method, tc.path, tc.body)
+
+			require.Equal(t, http.StatusForbidden, rec.Code)
+			var body openapi.Error
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+			assert.Equal(t, handlers.ErrInsufficientScope.Error(), *body.Error)
+			assert.Equal(t, tc.operation, *body.Operation)
+			assert.Equal(t, tc.scope, *body.RequiredScope)
+			assert.Contains(t, rec.Header().Get(echo.HeaderWWWAuthenticate), `error="insufficient_scope"`)
+		})
+	}
+}
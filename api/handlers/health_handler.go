@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+
+	// CheckTimeout bounds each readiness check.
+	CheckTimeout = 2 * time.Second
+)
+
+var (
+	ErrDraining = errors.New("server is shutting down")
+)
+
+// HealthCheck is a named dependency probed by /readyz.
+type HealthCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+type ComponentStatus struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type HealthResponse struct {
+	Status     string                     `json:"status"`
+	Components map[string]ComponentStatus `json:"components,omitempty"`
+}
+
+type HealthHandler struct {
+	checks   []HealthCheck
+	draining atomic.Bool
+}
+
+func NewHealthHandler(checks ...HealthCheck) *HealthHandler {
+	return &HealthHandler{checks: checks}
+}
+
+// SetDraining marks the server as shutting down, failing readiness so load
+// balancers stop sending new requests.
+func (h *HealthHandler) SetDraining() {
+	h.draining.Store(true)
+}
+
+func (h *HealthHandler) Register(e *echo.Echo) {
+	e.GET("/healthz", h.Liveness)
+	e.GET("/readyz", h.Readiness)
+}
+
+// Liveness reports that the process is up and serving HTTP.
+func (h *HealthHandler) Liveness(ctx echo.Context) error {
+	return ctx.JSON(http.StatusOK, HealthResponse{Status: StatusOK})
+}
+
+// Readiness runs every check and reports each component. Any failure, or a
+// draining server, answers 503.
+func (h *HealthHandler) Readiness(ctx echo.Context) error {
+	res := HealthResponse{Status: StatusOK, Components: map[string]ComponentStatus{}}
+	report := func(name string, err error) {
+		if err != nil {
+			res.Status = StatusFail
+			res.Components[name] = ComponentStatus{Status: StatusFail, Error: err.Error()}
+			return
+		}
+		res.Components[name] = ComponentStatus{Status: StatusOK}
+	}
+
+	var draining error
+	if h.draining.Load() {
+		draining = ErrDraining
+	}
+	report("server", draining)
+	for _, c := range h.checks {
+		checkCtx, cancel := context.WithTimeout(ctx.Request().Context(), CheckTimeout)
+		report(c.Name, c.Check(checkCtx))
+		cancel()
+	}
+
+	code := http.StatusOK
+	if res.Status != StatusOK {
+		code = http.StatusServiceUnavailable
+	}
+	return ctx.JSON(code, res)
+}
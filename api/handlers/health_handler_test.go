@@ -0,0 +1,56 @@
+//go:build unit
+
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ichigo7diabol/go-test-wallet/api/handlers"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func probe(e *echo.Echo, path string) (int, handlers.HealthResponse) {
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	var res handlers.HealthResponse
+	_ = json.Unmarshal(rec.Body.Bytes(), &res)
+	return rec.Code, res
+}
+
+func TestHealth(t *testing.T) {
+	var dbErr error
+	h := handlers.NewHealthHandler(handlers.HealthCheck{
+		Name:  "database",
+		Check: func(ctx context.Context) error { return dbErr },
+	})
+	e := echo.New()
+	h.Register(e)
+
+	code, res := probe(e, "/readyz")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, handlers.StatusOK, res.Components["database"].Status)
+	assert.Equal(t, handlers.StatusOK, res.Components["server"].Status)
+
+	dbErr = errors.New("connection refused")
+	code, res = probe(e, "/readyz")
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, handlers.StatusFail, res.Status)
+	assert.Equal(t, "connection refused", res.Components["database"].Error)
+
+	dbErr = nil
+	h.SetDraining()
+	code, res = probe(e, "/readyz")
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, handlers.ErrDraining.Error(), res.Components["server"].Error)
+
+	code, res = probe(e, "/healthz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, handlers.StatusOK, res.Status)
+}
@@ -1,13 +1,20 @@
 package handlers
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"net/http"
-	"time"
+	"strconv"
+	"strings"
 
 	"github.com/google/uuid"
+	"github.com/ichigo7diabol/go-test-wallet/api/middleware"
 	"github.com/ichigo7diabol/go-test-wallet/api/openapi"
 	"github.com/ichigo7diabol/go-test-wallet/internal/app"
+	"github.com/ichigo7diabol/go-test-wallet/internal/models"
 	"github.com/labstack/echo/v4"
 	openapi_types "github.com/oapi-codegen/runtime/types"
 )
@@ -15,8 +22,14 @@ import (
 var (
 	ErrIncorrectData  = errors.New("incorrect data")
 	ErrInternalServer = errors.New("internal server error")
+	ErrTimeout        = errors.New("operation timed out")
+	ErrClientClosed   = errors.New("client closed request")
 )
 
+// StatusClientClosedRequest answers requests whose client went away before
+// the operation finished, so they are not counted as server errors.
+const StatusClientClosedRequest = 499
+
 type WalletHandler struct {
 	WalletService *app.WalletService
 }
@@ -27,107 +40,530 @@ func NewWalletHandler(walletService *app.WalletService) *WalletHandler {
 	}
 }
 
-func (h *WalletHandler) CreateWallet(ctx echo.Context) error {
+func (h *WalletHandler) CreateWallet(ctx echo.Context, params openapi.CreateWalletParams) error {
+	if err := authorize(ctx, "createWallet"); err != nil {
+		return err
+	}
 	var req openapi.CreateWalletRequest
 	if err := ctx.Bind(&req); err != nil {
 		return NewHttpError(err)
 	}
-	model, err := h.WalletService.CreateWallet(req.InitialBalance)
+	initialBalance, err := models.ParseMoney(req.InitialBalance)
+	if err != nil {
+		return NewHttpError(ErrIncorrectData)
+	}
+	var currency string
+	if req.Currency != nil {
+		currency = *req.Currency
+	}
+	p := principal(ctx)
+	model, err := h.WalletService.CreateWallet(
+		ctx.Request().Context(),
+		p,
+		initialBalance,
+		currency,
+		newIdempotency(p, params.IdempotencyKey, "createWallet", req),
+	)
 	if err != nil {
 		return NewHttpError(err)
 	}
-	wallet := openapi.Wallet{
-		WalletId:  (*openapi_types.UUID)(&model.ID),
-		Balance:   &model.Balance,
-		CreatedAt: &model.CreatedAt,
-		UpdatedAt: &model.UpdatedAt,
+	resp, err := newWallet(model)
+	if err != nil {
+		return NewHttpError(err)
 	}
-	return ctx.JSON(http.StatusCreated, wallet)
+	ctx.Response().Header().Set("ETag", etag(model.Version))
+	return ctx.JSON(http.StatusCreated, resp)
 }
 
 // Операции с кошельком (DEPOSIT/WITHDRAW)
-func (h *WalletHandler) ChangeWallet(ctx echo.Context) error {
+func (h *WalletHandler) ChangeWallet(ctx echo.Context, params openapi.ChangeWalletParams) error {
 	var req openapi.WalletOperationRequest
 	if err := ctx.Bind(&req); err != nil {
 		return NewHttpError(err)
 	}
+	if err := authorizeChangeWallet(ctx, app.WalletOperation(req.OperationType)); err != nil {
+		return err
+	}
+	amount, err := models.ParseMoney(req.Amount)
+	if err != nil {
+		return NewHttpError(ErrIncorrectData)
+	}
+	var metadata map[string]string
+	if req.Metadata != nil {
+		metadata = *req.Metadata
+	}
+	version, err := expectedVersion(params.IfMatch, req.ExpectedVersion)
+	if err != nil {
+		return NewHttpError(err)
+	}
+	p := principal(ctx)
 	oldBalance, newBalance, model, err := h.WalletService.ChangeBalance(
+		ctx.Request().Context(),
+		p,
 		uuid.UUID(req.WalletId),
 		app.WalletOperation(req.OperationType),
-		req.Amount,
+		amount,
+		req.Currency,
+		metadata,
+		version,
+		newIdempotency(p, params.IdempotencyKey, "changeWallet", req),
 	)
 	if err != nil {
 		return NewHttpError(err)
 	}
-	now := time.Now()
+	oldValue, newValue := oldBalance.String(), newBalance.String()
 	resp := openapi.WalletOperationResponse{
 		WalletId:      &model.ID,
 		OperationType: (*openapi.WalletOperationResponseOperationType)(&req.OperationType),
-		OldBalance:    &oldBalance,
-		NewBalance:    &newBalance,
+		OldBalance:    &oldValue,
+		NewBalance:    &newValue,
 		Amount:        &req.Amount,
-		Timestamp:     &now,
+		Currency:      &model.Currency,
+		Timestamp:     &model.UpdatedAt,
+		Version:       &model.Version,
+	}
+
+	ctx.Response().Header().Set("ETag", etag(model.Version))
+	return ctx.JSON(http.StatusOK, resp)
+}
+
+// Перевод между кошельками
+func (h *WalletHandler) CreateTransfer(ctx echo.Context, params openapi.CreateTransferParams) error {
+	if err := authorize(ctx, "createTransfer"); err != nil {
+		return err
+	}
+	var req openapi.TransferRequest
+	if err := ctx.Bind(&req); err != nil {
+		return NewHttpError(err)
+	}
+	amount, err := models.ParseMoney(req.Amount)
+	if err != nil {
+		return NewHttpError(ErrIncorrectData)
+	}
+	var metadata map[string]string
+	if req.Metadata != nil {
+		metadata = *req.Metadata
+	}
+	version, err := expectedVersion(params.IfMatch, req.ExpectedVersion)
+	if err != nil {
+		return NewHttpError(err)
+	}
+	p := principal(ctx)
+	res, err := h.WalletService.Transfer(
+		ctx.Request().Context(),
+		p,
+		uuid.UUID(req.FromWalletId),
+		uuid.UUID(req.ToWalletId),
+		amount,
+		req.Currency,
+		metadata,
+		version,
+		newIdempotency(p, params.IdempotencyKey, "createTransfer", req),
+	)
+	if err != nil {
+		return NewHttpError(err)
+	}
+	fromOld, fromNew := res.FromOldBalance.String(), res.FromNewBalance.String()
+	toOld, toNew := res.ToOldBalance.String(), res.ToNewBalance.String()
+	resp := openapi.TransferResponse{
+		TransferId:     &res.ID,
+		FromWalletId:   &res.From.ID,
+		ToWalletId:     &res.To.ID,
+		Amount:         &req.Amount,
+		Currency:       &res.From.Currency,
+		FromOldBalance: &fromOld,
+		FromNewBalance: &fromNew,
+		ToOldBalance:   &toOld,
+		ToNewBalance:   &toNew,
+		Timestamp:      &res.From.UpdatedAt,
 	}
 
+	ctx.Response().Header().Set("ETag", etag(res.From.Version))
 	return ctx.JSON(http.StatusOK, resp)
 }
 
+// Блокировка средств на кошельке
+func (h *WalletHandler) AuthorizeHold(ctx echo.Context, walletId openapi_types.UUID, params openapi.AuthorizeHoldParams) error {
+	if err := authorize(ctx, "authorizeHold"); err != nil {
+		return err
+	}
+	var req openapi.AuthorizeHoldRequest
+	if err := ctx.Bind(&req); err != nil {
+		return NewHttpError(err)
+	}
+	amount, err := models.ParseMoney(req.Amount)
+	if err != nil {
+		return NewHttpError(ErrIncorrectData)
+	}
+	var metadata map[string]string
+	if req.Metadata != nil {
+		metadata = *req.Metadata
+	}
+	version, err := expectedVersion(params.IfMatch, req.ExpectedVersion)
+	if err != nil {
+		return NewHttpError(err)
+	}
+	hold, err := h.WalletService.AuthorizeHold(
+		ctx.Request().Context(),
+		principal(ctx),
+		walletId,
+		amount,
+		req.Currency,
+		req.ExpiresAt,
+		metadata,
+		version,
+	)
+	if err != nil {
+		return NewHttpError(err)
+	}
+	ctx.Response().Header().Set("ETag", etag(hold.WalletVersion))
+	return ctx.JSON(http.StatusCreated, newHold(hold))
+}
+
+func (h *WalletHandler) GetHold(ctx echo.Context, holdId openapi_types.UUID) error {
+	if err := authorize(ctx, "getHold"); err != nil {
+		return err
+	}
+	hold, err := h.WalletService.GetHold(ctx.Request().Context(), principal(ctx), holdId)
+	if err != nil {
+		return NewHttpError(err)
+	}
+	return ctx.JSON(http.StatusOK, newHold(hold))
+}
+
+func (h *WalletHandler) CaptureHold(ctx echo.Context, holdId openapi_types.UUID, params openapi.CaptureHoldParams) error {
+	if err := authorize(ctx, "captureHold"); err != nil {
+		return err
+	}
+	var req openapi.CaptureHoldRequest
+	if err := ctx.Bind(&req); err != nil {
+		return NewHttpError(err)
+	}
+	var amount *models.Money
+	if req.Amount != nil {
+		a, err := models.ParseMoney(*req.Amount)
+		if err != nil {
+			return NewHttpError(ErrIncorrectData)
+		}
+		amount = &a
+	}
+	version, err := expectedVersion(params.IfMatch, req.ExpectedVersion)
+	if err != nil {
+		return NewHttpError(err)
+	}
+	hold, oldBalance, newBalance, err := h.WalletService.CaptureHold(ctx.Request().Context(), principal(ctx), holdId, amount, version)
+	if err != nil {
+		return NewHttpError(err)
+	}
+	oldValue, newValue := oldBalance.String(), newBalance.String()
+	resp := newHold(hold)
+	ctx.Response().Header().Set("ETag", etag(hold.WalletVersion))
+	return ctx.JSON(http.StatusOK, openapi.HoldCaptureResponse{
+		Hold:       &resp,
+		OldBalance: &oldValue,
+		NewBalance: &newValue,
+	})
+}
+
+func (h *WalletHandler) VoidHold(ctx echo.Context, holdId openapi_types.UUID, params openapi.VoidHoldParams) error {
+	if err := authorize(ctx, "voidHold"); err != nil {
+		return err
+	}
+	version, err := expectedVersion(params.IfMatch, nil)
+	if err != nil {
+		return NewHttpError(err)
+	}
+	hold, err := h.WalletService.VoidHold(ctx.Request().Context(), principal(ctx), holdId, version)
+	if err != nil {
+		return NewHttpError(err)
+	}
+	ctx.Response().Header().Set("ETag", etag(hold.WalletVersion))
+	return ctx.JSON(http.StatusOK, newHold(hold))
+}
+
 func (h *WalletHandler) ListWallets(ctx echo.Context) error {
-	models, err := h.WalletService.ListWallets()
+	if err := authorize(ctx, "listWallets"); err != nil {
+		return err
+	}
+	models, err := h.WalletService.ListWallets(ctx.Request().Context(), principal(ctx))
 	if err != nil {
 		return NewHttpError(err)
 	}
 	wallets := make([]openapi.Wallet, len(models))
-	for i, model := range models {
-		wallets[i] = openapi.Wallet{
-			WalletId:  (*openapi_types.UUID)(&model.ID),
-			Balance:   &model.Balance,
-			CreatedAt: &model.CreatedAt,
-			UpdatedAt: &model.UpdatedAt,
+	for i := range models {
+		if wallets[i], err = newWallet(&models[i]); err != nil {
+			return NewHttpError(err)
 		}
 	}
 	return ctx.JSON(http.StatusOK, wallets)
 }
 
 func (h *WalletHandler) GetWallet(ctx echo.Context, walletId openapi_types.UUID) error {
-	model, err := h.WalletService.GetWallet(walletId)
+	if err := authorize(ctx, "getWallet"); err != nil {
+		return err
+	}
+	model, err := h.WalletService.GetWallet(ctx.Request().Context(), principal(ctx), walletId)
 	if err != nil {
 		return NewHttpError(err)
 	}
-	return ctx.JSON(http.StatusOK, openapi.Wallet{
-		WalletId:  &walletId,
-		Balance:   &model.Balance,
-		CreatedAt: &model.CreatedAt,
-		UpdatedAt: &model.UpdatedAt,
-	})
+	resp, err := newWallet(model)
+	if err != nil {
+		return NewHttpError(err)
+	}
+	ctx.Response().Header().Set("ETag", etag(model.Version))
+	return ctx.JSON(http.StatusOK, resp)
 }
 
-func (h *WalletHandler) DeleteWallet(ctx echo.Context, walletId openapi_types.UUID) error {
-	err := h.WalletService.DeleteWallet(walletId)
+func (h *WalletHandler) DeleteWallet(ctx echo.Context, walletId openapi_types.UUID, params openapi.DeleteWalletParams) error {
+	if err := authorize(ctx, "deleteWallet"); err != nil {
+		return err
+	}
+	version, err := expectedVersion(params.IfMatch, nil)
+	if err != nil {
+		return NewHttpError(err)
+	}
+	err = h.WalletService.DeleteWallet(ctx.Request().Context(), principal(ctx), walletId, version)
 	if err != nil {
 		return NewHttpError(err)
 	}
 	return ctx.NoContent(http.StatusNoContent)
 }
 
+func (h *WalletHandler) ListWalletTransactions(
+	ctx echo.Context,
+	walletId openapi_types.UUID,
+	params openapi.ListWalletTransactionsParams,
+) error {
+	if err := authorize(ctx, "listWalletTransactions"); err != nil {
+		return err
+	}
+	filter, err := newTransactionFilter(params)
+	if err != nil {
+		return NewHttpError(err)
+	}
+	entries, next, err := h.WalletService.ListTransactions(ctx.Request().Context(), principal(ctx), walletId, filter)
+	if err != nil {
+		return NewHttpError(err)
+	}
+	page := openapi.TransactionPage{
+		Items: make([]openapi.Transaction, len(entries)),
+	}
+	for i := range entries {
+		page.Items[i] = newTransaction(&entries[i])
+	}
+	if next != nil {
+		cursor := next.Encode()
+		page.NextCursor = &cursor
+	}
+	return ctx.JSON(http.StatusOK, page)
+}
+
+func newTransactionFilter(params openapi.ListWalletTransactionsParams) (app.TransactionFilter, error) {
+	filter := app.TransactionFilter{
+		From: params.From,
+		To:   params.To,
+	}
+	if params.Limit != nil {
+		filter.Limit = *params.Limit
+	}
+	if params.OperationType != nil {
+		for _, op := range *params.OperationType {
+			filter.OperationTypes = append(filter.OperationTypes, app.WalletOperation(op))
+		}
+	}
+	if params.MinAmount != nil {
+		amount, err := models.ParseMoney(*params.MinAmount)
+		if err != nil {
+			return app.TransactionFilter{}, ErrIncorrectData
+		}
+		filter.MinAmount = &amount
+	}
+	if params.MaxAmount != nil {
+		amount, err := models.ParseMoney(*params.MaxAmount)
+		if err != nil {
+			return app.TransactionFilter{}, ErrIncorrectData
+		}
+		filter.MaxAmount = &amount
+	}
+	if params.Cursor != nil {
+		cursor, err := app.DecodeTransactionCursor(*params.Cursor)
+		if err != nil {
+			return app.TransactionFilter{}, err
+		}
+		filter.After = &cursor
+	}
+	return filter, nil
+}
+
+func newTransaction(entry *models.TransactionModel) openapi.Transaction {
+	amount := entry.Amount.String()
+	before := entry.BalanceBefore.String()
+	after := entry.BalanceAfter.String()
+	op := openapi.TransactionOperationType(entry.OperationType)
+	t := openapi.Transaction{
+		Id:            &entry.ID,
+		WalletId:      &entry.WalletID,
+		OperationType: &op,
+		Amount:        &amount,
+		BalanceBefore: &before,
+		BalanceAfter:  &after,
+		Currency:      &entry.Currency,
+		CreatedAt:     &entry.CreatedAt,
+	}
+	if entry.Metadata != nil {
+		t.Metadata = &entry.Metadata
+	}
+	return t
+}
+
+// principal is the authenticated caller, or nil when authentication is
+// disabled.
+func principal(ctx echo.Context) *app.Principal {
+	p, _ := middleware.PrincipalFrom(ctx)
+	return p
+}
+
+// newIdempotency fingerprints the decoded request body together with the
+// operation and caller, so a key reused for another endpoint or by another
+// principal is also rejected.
+func newIdempotency(p *app.Principal, key *string, operationId string, req any) *app.Idempotency {
+	if key == nil {
+		return nil
+	}
+	var subject string
+	if p != nil {
+		subject = p.Subject
+	}
+	body, _ := json.Marshal(req)
+	sum := sha256.Sum256(append([]byte(operationId+"\n"+subject+"\n"), body...))
+	return &app.Idempotency{
+		Key:         *key,
+		Fingerprint: hex.EncodeToString(sum[:]),
+	}
+}
+
+// expectedVersion is the wallet version a change is conditional on, taken
+// from the If-Match header or the expectedVersion field; nil when neither is
+// given. If-Match holds one ETag as sent by GetWallet or by a change, or "*"
+// for any version. When both are given they must agree.
+func expectedVersion(ifMatch *string, field *int64) (*int64, error) {
+	if ifMatch == nil || strings.TrimSpace(*ifMatch) == "*" {
+		return field, nil
+	}
+	tag, quoted := strings.CutPrefix(strings.TrimSpace(*ifMatch), `"`)
+	tag, closed := strings.CutSuffix(tag, `"`)
+	if !quoted || !closed {
+		return nil, ErrIncorrectData
+	}
+	version, err := strconv.ParseInt(tag, 10, 64)
+	if err != nil || (field != nil && *field != version) {
+		return nil, ErrIncorrectData
+	}
+	return &version, nil
+}
+
+// etag is the ETag of a wallet at version.
+func etag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+func newWallet(model *models.WalletModel) (openapi.Wallet, error) {
+	available, err := model.Available()
+	if err != nil {
+		return openapi.Wallet{}, err
+	}
+	balance := model.Balance.String()
+	availableBalance := available.String()
+	held := model.Held.String()
+	return openapi.Wallet{
+		WalletId:         (*openapi_types.UUID)(&model.ID),
+		Balance:          &balance,
+		AvailableBalance: &availableBalance,
+		HeldBalance:      &held,
+		Currency:         &model.Currency,
+		OwnerId:          &model.OwnerID,
+		CreatedAt:        &model.CreatedAt,
+		UpdatedAt:        &model.UpdatedAt,
+		Version:          &model.Version,
+	}, nil
+}
+
+func newHold(model *models.HoldModel) openapi.Hold {
+	amount := model.Amount.String()
+	captured := model.CapturedAmount.String()
+	status := openapi.HoldStatus(model.Status)
+	h := openapi.Hold{
+		Id:             &model.ID,
+		WalletId:       &model.WalletID,
+		Amount:         &amount,
+		CapturedAmount: &captured,
+		Currency:       &model.Currency,
+		Status:         &status,
+		ExpiresAt:      &model.ExpiresAt,
+		CreatedAt:      &model.CreatedAt,
+		UpdatedAt:      &model.UpdatedAt,
+	}
+	if model.Metadata != nil {
+		h.Metadata = &model.Metadata
+	}
+	return h
+}
+
 func NewHttpError(err error) error {
 	var code = http.StatusInternalServerError
 	var msg = map[string]string{}
 
 	switch {
-	case errors.Is(err, app.ErrInvalidAmount):
-	case errors.Is(err, app.ErrUnknownOperation):
-	case errors.Is(err, ErrIncorrectData):
+	case errors.Is(err, app.ErrInvalidAmount),
+		errors.Is(err, app.ErrAmountScale),
+		errors.Is(err, app.ErrAmountTooLarge),
+		errors.Is(err, app.ErrUnknownCurrency),
+		errors.Is(err, app.ErrCurrencyMismatch),
+		errors.Is(err, app.ErrInvalidCursor),
+		errors.Is(err, app.ErrSameWallet),
+		errors.Is(err, app.ErrInvalidIdempotencyKey),
+		errors.Is(err, app.ErrUnknownOperation),
+		errors.Is(err, app.ErrHoldAmountExceeded),
+		errors.Is(err, app.ErrInvalidHoldExpiry),
+		errors.Is(err, app.ErrCurrencyNotAllowed),
+		errors.Is(err, app.ErrOperationLimitExceeded),
+		errors.Is(err, ErrIncorrectData):
 		code = http.StatusBadRequest
 	case errors.Is(err, app.ErrInsufficientFunds):
 		code = http.StatusPaymentRequired
-	case errors.Is(err, app.ErrWalletNotFound):
+	case errors.Is(err, ErrInsufficientScope),
+		errors.Is(err, app.ErrUnknownTenant):
+		code = http.StatusForbidden
+	case errors.Is(err, app.ErrWalletNotFound),
+		errors.Is(err, app.ErrHoldNotFound):
 		code = http.StatusNotFound
+	case errors.Is(err, app.ErrHoldNotActive),
+		errors.Is(err, app.ErrHoldExpired),
+		errors.Is(err, app.ErrVersionConflict),
+		errors.Is(err, app.ErrBalanceBelowHeld):
+		code = http.StatusConflict
+	case errors.Is(err, app.ErrIdempotencyKeyReused),
+		errors.Is(err, models.ErrMoneyOutOfRange):
+		code = http.StatusUnprocessableEntity
+	case errors.Is(err, context.DeadlineExceeded):
+		err = ErrTimeout
+		code = http.StatusGatewayTimeout
+	case errors.Is(err, context.Canceled):
+		err = ErrClientClosed
+		code = StatusClientClosedRequest
 	default:
 		err = ErrInternalServer
 		code = http.StatusInternalServerError
 	}
 	msg = map[string]string{"error": err.Error()}
+	var scopeErr *ScopeError
+	if errors.As(err, &scopeErr) {
+		msg = map[string]string{
+			"error":         ErrInsufficientScope.Error(),
+			"operation":     scopeErr.Operation,
+			"requiredScope": scopeErr.Scope,
+		}
+	}
 
 	return echo.NewHTTPError(code, msg)
 }
@@ -0,0 +1,205 @@
+//go:build unit
+
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ichigo7diabol/go-test-wallet/api/handlers"
+	"github.com/ichigo7diabol/go-test-wallet/api/middleware"
+	"github.com/ichigo7diabol/go-test-wallet/api/openapi"
+	"github.com/ichigo7diabol/go-test-wallet/internal/app"
+	"github.com/ichigo7diabol/go-test-wallet/internal/models"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewHttpError(t *testing.T) {
+	cases := map[error]int{
+		app.ErrInsufficientFunds:                           http.StatusPaymentRequired,
+		app.ErrWalletNotFound:                              http.StatusNotFound,
+		app.ErrUnknownTenant:                               http.StatusForbidden,
+		app.ErrVersionConflict:                             http.StatusConflict,
+		app.ErrBalanceBelowHeld:                            http.StatusConflict,
+		app.ErrAmountTooLarge:                              http.StatusBadRequest,
+		models.ErrMoneyOutOfRange:                          http.StatusUnprocessableEntity,
+		fmt.Errorf("select: %w", context.DeadlineExceeded): http.StatusGatewayTimeout,
+		fmt.Errorf("select: %w", context.Canceled):         handlers.StatusClientClosedRequest,
+		fmt.Errorf("boom"):                                 http.StatusInternalServerError,
+	}
+	for err, code := range cases {
+		t.Run(err.Error(), func(t *testing.T) {
+			he, ok := handlers.NewHttpError(err).(*echo.HTTPError)
+			assert.True(t, ok)
+			assert.Equal(t, code, he.Code)
+		})
+	}
+
+	he := handlers.NewHttpError(context.DeadlineExceeded).(*echo.HTTPError)
+	assert.Equal(t, map[string]string{"error": handlers.ErrTimeout.Error()}, he.Message)
+}
+
+// testServer serves the wallet API over a repository.
+type testServer struct {
+	e *echo.Echo
+}
+
+// newTestServer authenticates every request as the principal returned for it
+// and runs mw after that.
+func newTestServer(t *testing.T, repo app.WalletRepositoryService, opts app.WalletServiceOptions, principal func(*http.Request) *app.Principal, mw ...echo.MiddlewareFunc) *testServer {
+	t.Helper()
+	e := echo.New()
+	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			middleware.SetPrincipal(c, principal(c.Request()))
+			return next(c)
+		}
+	})
+	e.Use(mw...)
+	openapi.RegisterHandlers(e, handlers.NewWalletHandler(app.NewWalletService(repo, opts)))
+	return &testServer{e: e}
+}
+
+// admin is user-1 with the admin scope.
+func admin(*http.Request) *app.Principal {
+	return &app.Principal{Subject: "user-1", Scopes: []string{app.AdminScope}}
+}
+
+func (s *testServer) send(method, path, body string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	rec := httptest.NewRecorder()
+	s.e.ServeHTTP(rec, req)
+	return rec
+}
+
+func ifMatch(etag string) http.Header {
+	return http.Header{"If-Match": {etag}}
+}
+
+func TestWalletVersions(t *testing.T) {
+	repo := app.NewMemoryRepository()
+	w, err := repo.Create(models.MoneyFromInt(10), "USD", "user-1")
+	require.NoError(t, err)
+	srv := newTestServer(t, repo, app.WalletServiceOptions{}, admin)
+	deposit := func(extra string) string {
+		return `{"walletId":"` + w.ID.String() + `","operationType":"DEPOSIT","amount":"1","currency":"USD"` + extra + `}`
+	}
+
+	rec := srv.send(http.MethodPost, "/wallets", `{"initialBalance":"10","currency":"USD"}`, nil)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	assert.Equal(t, `"1"`, rec.Header().Get("ETag"))
+	assert.Contains(t, rec.Body.String(), `"version":1`)
+
+	rec = srv.send(http.MethodGet, "/wallet/"+w.ID.String(), "", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, `"1"`, rec.Header().Get("ETag"))
+	assert.Contains(t, rec.Body.String(), `"version":1`)
+
+	rec = srv.send(http.MethodPost, "/wallet", deposit(""), ifMatch(`"1"`))
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, `"2"`, rec.Header().Get("ETag"))
+
+	// Both the stale ETag and the stale body field are rejected.
+	rec = srv.send(http.MethodPost, "/wallet", deposit(""), ifMatch(`"1"`))
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	rec = srv.send(http.MethodPost, "/wallet", deposit(`,"expectedVersion":1`), nil)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	rec = srv.send(http.MethodPost, "/wallet", deposit(`,"expectedVersion":2`), ifMatch(`"3"`))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = srv.send(http.MethodPost, "/wallet", deposit(""), ifMatch(`W/"2"`))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = srv.send(http.MethodDelete, "/wallet/"+w.ID.String(), "", ifMatch(`"1"`))
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	rec = srv.send(http.MethodDelete, "/wallet/"+w.ID.String(), "", ifMatch(`"2"`))
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+}
+
+func TestHoldAndTransferVersions(t *testing.T) {
+	repo := app.NewMemoryRepository()
+	w, err := repo.Create(models.MoneyFromInt(100), "USD", "user-1")
+	require.NoError(t, err)
+	to, err := repo.Create(models.MoneyFromInt(0), "USD", "user-1")
+	require.NoError(t, err)
+	srv := newTestServer(t, repo, app.WalletServiceOptions{HoldTTL: time.Hour}, admin)
+	authorizeHold := func(etag string) string {
+		rec := srv.send(http.MethodPost, "/wallet/"+w.ID.String()+"/holds", `{"amount":"10","currency":"USD"}`, nil)
+		require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+		assert.Equal(t, etag, rec.Header().Get("ETag"))
+		var hold openapi.Hold
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &hold))
+		return hold.Id.String()
+	}
+
+	// A transfer reports the version of the source wallet.
+	rec := srv.send(http.MethodPost, "/transfers",
+		`{"fromWalletId":"`+w.ID.String()+`","toWalletId":"`+to.ID.String()+`","amount":"1","currency":"USD"}`, ifMatch(`"1"`))
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, `"2"`, rec.Header().Get("ETag"))
+
+	hold := authorizeHold(`"3"`)
+	rec = srv.send(http.MethodPost, "/holds/"+hold+"/capture", `{}`, ifMatch(`"2"`))
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	rec = srv.send(http.MethodPost, "/holds/"+hold+"/capture", `{"expectedVersion":2}`, nil)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	rec = srv.send(http.MethodPost, "/holds/"+hold+"/capture", `{"expectedVersion":3}`, ifMatch(`"2"`))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = srv.send(http.MethodPost, "/holds/"+hold+"/capture", `{}`, ifMatch(`"3"`))
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, `"4"`, rec.Header().Get("ETag"))
+
+	hold = authorizeHold(`"5"`)
+	rec = srv.send(http.MethodPost, "/holds/"+hold+"/void", "", ifMatch(`"4"`))
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	rec = srv.send(http.MethodPost, "/holds/"+hold+"/void", "", ifMatch(`"5"`))
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, `"6"`, rec.Header().Get("ETag"))
+}
+
+func TestTenantIsolation(t *testing.T) {
+	repo := app.NewMemoryRepository()
+	w, err := repo.ForTenant("acme").Create(models.MoneyFromInt(10), "USD", "user-1")
+	require.NoError(t, err)
+	claim := func(req *http.Request) *app.Principal {
+		p := admin(req)
+		p.TenantID = req.Header.Get("X-Claim")
+		return p
+	}
+	srv := newTestServer(t, repo, app.WalletServiceOptions{}, claim, middleware.Tenant("X-Tenant-ID"))
+
+	cases := map[string]struct {
+		claim, header string
+		code          int
+	}{
+		// A token without the claim must not pick the tenant by header.
+		"no claim, header":     {header: "acme", code: http.StatusForbidden},
+		"no claim, no header":  {code: http.StatusForbidden},
+		"other claim, header":  {claim: "globex", header: "acme", code: http.StatusForbidden},
+		"other claim":          {claim: "globex", code: http.StatusNotFound},
+		"own claim":            {claim: "acme", code: http.StatusOK},
+		"own claim and header": {claim: "acme", header: "acme", code: http.StatusOK},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			rec := srv.send(http.MethodGet, "/wallet/"+w.ID.String(), "", http.Header{
+				"X-Claim":     {tc.claim},
+				"X-Tenant-Id": {tc.header},
+			})
+
+			assert.Equal(t, tc.code, rec.Code, rec.Body.String())
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/MicahParks/keyfunc/v3"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/ichigo7diabol/go-test-wallet/internal/app"
+	echojwt "github.com/labstack/echo-jwt/v4"
+	"github.com/labstack/echo/v4"
+)
+
+var (
+	ErrNoKeySource     = errors.New("either a JWKS URL or a JWKS file must be configured")
+	ErrUnauthenticated = errors.New("missing or invalid bearer token")
+)
+
+const (
+	// principalKey is the echo.Context key holding the authenticated
+	// *app.Principal.
+	principalKey = "auth.principal"
+
+	// Leeway tolerates clock skew between the issuer and this service.
+	Leeway = 30 * time.Second
+)
+
+// signingMethods are the asymmetric algorithms a JWKS can publish keys for.
+// HMAC and "none" are deliberately absent.
+var signingMethods = []string{
+	"RS256", "RS384", "RS512",
+	"PS256", "PS384", "PS512",
+	"ES256", "ES384", "ES512",
+	"EdDSA",
+}
+
+type AuthOptions struct {
+	// JWKSURL is fetched at startup and refreshed in the background.
+	JWKSURL string
+	// JWKSFile is a local JWKS document, used when JWKSURL is empty.
+	JWKSFile string
+	Issuer   string
+	Audience string
+}
+
+// Claims are the token claims the service relies on.
+type Claims struct {
+	jwt.RegisteredClaims
+	// Scope is the space-separated OAuth 2.0 scope list (RFC 8693).
+	Scope string `json:"scope,omitempty"`
+	// TenantID confines the token to one tenant's wallets.
+	TenantID string `json:"tenant_id,omitempty"`
+}
+
+// NewKeyfunc loads the verification keys from the configured JWKS source.
+// The context bounds the background refresh of a remote JWKS.
+func NewKeyfunc(ctx context.Context, opts AuthOptions) (keyfunc.Keyfunc, error) {
+	switch {
+	case opts.JWKSURL != "":
+		return keyfunc.NewDefaultCtx(ctx, []string{opts.JWKSURL})
+	case opts.JWKSFile != "":
+		raw, err := os.ReadFile(opts.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		return keyfunc.NewJWKSetJSON(raw)
+	default:
+		return nil, ErrNoKeySource
+	}
+}
+
+// Auth rejects requests without a valid bearer token and stores the
+// authenticated principal in the echo context.
+func Auth(keys jwt.Keyfunc, opts AuthOptions) (echo.MiddlewareFunc, error) {
+	parserOpts := []jwt.ParserOption{
+		jwt.WithValidMethods(signingMethods),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(Leeway),
+	}
+	if opts.Issuer != "" {
+		parserOpts = append(parserOpts, jwt.WithIssuer(opts.Issuer))
+	}
+	if opts.Audience != "" {
+		parserOpts = append(parserOpts, jwt.WithAudience(opts.Audience))
+	}
+	parser := jwt.NewParser(parserOpts...)
+
+	return echojwt.Config{
+		ParseTokenFunc: func(c echo.Context, token string) (any, error) {
+			var claims Claims
+			t, err := parser.ParseWithClaims(token, &claims, keys)
+			if err != nil {
+				return nil, err
+			}
+			if claims.Subject == "" {
+				return nil, jwt.ErrTokenInvalidSubject
+			}
+			return t, nil
+		},
+		SuccessHandler: func(c echo.Context) {
+			t := c.Get("user").(*jwt.Token)
+			claims := t.Claims.(*Claims)
+			SetPrincipal(c, &app.Principal{
+				Subject:  claims.Subject,
+				Scopes:   strings.Fields(claims.Scope),
+				TenantID: claims.TenantID,
+			})
+		},
+		ErrorHandler: func(c echo.Context, err error) error {
+			c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer realm="wallet"`)
+			return echo.NewHTTPError(http.StatusUnauthorized, map[string]string{
+				"error": ErrUnauthenticated.Error(),
+			}).SetInternal(err)
+		},
+	}.ToMiddleware()
+}
+
+// PrincipalFrom returns the caller authenticated by Auth, if any.
+func PrincipalFrom(c echo.Context) (*app.Principal, bool) {
+	p, ok := c.Get(principalKey).(*app.Principal)
+	return p, ok
+}
+
+// SetPrincipal records p as the caller of the request.
+func SetPrincipal(c echo.Context, p *app.Principal) {
+	c.Set(principalKey, p)
+}
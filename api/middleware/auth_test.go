@@ -0,0 +1,204 @@
+//go:build unit
+
+package middleware_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/ichigo7diabol/go-test-wallet/api/middleware"
+	"github.com/ichigo7diabol/go-test-wallet/internal/app"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testKID      = "test-key"
+	testIssuer   = "https://issuer.example"
+	testAudience = "wallet-api"
+)
+
+// newJWKSFile writes the public half of key as a single-key JWKS document.
+func newJWKSFile(t *testing.T, key *rsa.PrivateKey) string {
+	jwks := map[string]any{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": testKID,
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}},
+	}
+	raw, err := json.Marshal(jwks)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(path, raw, 0o600))
+	return path
+}
+
+func newServer(t *testing.T, key *rsa.PrivateKey) *echo.Echo {
+	opts := middleware.AuthOptions{
+		JWKSFile: newJWKSFile(t, key),
+		Issuer:   testIssuer,
+		Audience: testAudience,
+	}
+	keys, err := middleware.NewKeyfunc(context.Background(), opts)
+	require.NoError(t, err)
+	mw, err := middleware.Auth(keys.Keyfunc, opts)
+	require.NoError(t, err)
+
+	e := echo.New()
+	e.GET("/me", func(c echo.Context) error {
+		p, ok := middleware.PrincipalFrom(c)
+		if !ok {
+			return c.NoContent(http.StatusInternalServerError)
+		}
+		return c.String(http.StatusOK, p.Subject)
+	}, mw)
+	return e
+}
+
+func validClaims() jwt.RegisteredClaims {
+	return jwt.RegisteredClaims{
+		Subject:   "user-1",
+		Issuer:    testIssuer,
+		Audience:  jwt.ClaimStrings{testAudience},
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}
+}
+
+func sign(t *testing.T, key *rsa.PrivateKey, claims jwt.Claims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = testKID
+	s, err := token.SignedString(key)
+	require.NoError(t, err)
+	return s
+}
+
+func call(e *echo.Echo, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/me", nil)
+	if token != "" {
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAuth_ValidToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	e := newServer(t, key)
+
+	rec := call(e, sign(t, key, validClaims()))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "user-1", rec.Body.String())
+}
+
+func TestAuth_Rejected(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	e := newServer(t, key)
+
+	wrongIssuer := validClaims()
+	wrongIssuer.Issuer = "https://other.example"
+	wrongAudience := validClaims()
+	wrongAudience.Audience = jwt.ClaimStrings{"other-api"}
+	expired := validClaims()
+	expired.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Hour))
+	noExpiry := validClaims()
+	noExpiry.ExpiresAt = nil
+	noSubject := validClaims()
+	noSubject.Subject = ""
+	hmac, err := jwt.NewWithClaims(jwt.SigningMethodHS256, validClaims()).SignedString([]byte("secret"))
+	require.NoError(t, err)
+
+	cases := map[string]string{
+		"missing":        "",
+		"malformed":      "not-a-jwt",
+		"wrong key":      sign(t, other, validClaims()),
+		"wrong issuer":   sign(t, key, wrongIssuer),
+		"wrong audience": sign(t, key, wrongAudience),
+		"expired":        sign(t, key, expired),
+		"no expiry":      sign(t, key, noExpiry),
+		"no subject":     sign(t, key, noSubject),
+		"hmac":           hmac,
+	}
+	for name, token := range cases {
+		t.Run(name, func(t *testing.T) {
+			rec := call(e, token)
+
+			assert.Equal(t, http.StatusUnauthorized, rec.Code)
+			assert.Contains(t, rec.Header().Get(echo.HeaderWWWAuthenticate), "Bearer")
+		})
+	}
+}
+
+func TestNewKeyfunc_NoSource(t *testing.T) {
+	_, err := middleware.NewKeyfunc(context.Background(), middleware.AuthOptions{})
+
+	assert.ErrorIs(t, err, middleware.ErrNoKeySource)
+}
+
+func TestTenant(t *testing.T) {
+	e := echo.New()
+	e.GET("/tenant", func(c echo.Context) error {
+		p, ok := middleware.PrincipalFrom(c)
+		if !ok {
+			return c.NoContent(http.StatusNoContent)
+		}
+		return c.String(http.StatusOK, p.Subject+"@"+p.TenantID)
+	}, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if sub := c.Request().Header.Get("X-Sub"); sub != "" {
+				middleware.SetPrincipal(c, &app.Principal{Subject: sub, TenantID: c.Request().Header.Get("X-Claim")})
+			}
+			return next(c)
+		}
+	}, middleware.Tenant("X-Tenant-ID"))
+
+	cases := map[string]struct {
+		sub, claim, header string
+		code               int
+		body               string
+	}{
+		"claim only":        {sub: "user-1", claim: "acme", code: http.StatusOK, body: "user-1@acme"},
+		"header only":       {sub: "user-1", header: "acme", code: http.StatusForbidden},
+		"no claim":          {sub: "user-1", code: http.StatusForbidden},
+		"matching header":   {sub: "user-1", claim: "acme", header: "acme", code: http.StatusOK, body: "user-1@acme"},
+		"mismatched header": {sub: "user-1", claim: "acme", header: "globex", code: http.StatusForbidden},
+		"anonymous":         {header: "acme", code: http.StatusOK, body: "@acme"},
+		"nothing":           {code: http.StatusNoContent},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/tenant", nil)
+			req.Header.Set("X-Sub", tc.sub)
+			req.Header.Set("X-Claim", tc.claim)
+			req.Header.Set("X-Tenant-ID", tc.header)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			assert.Equal(t, tc.code, rec.Code)
+			if tc.body != "" {
+				assert.Equal(t, tc.body, rec.Body.String())
+			}
+		})
+	}
+}
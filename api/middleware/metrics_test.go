@@ -0,0 +1,45 @@
+//go:build unit
+
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ichigo7diabol/go-test-wallet/api/middleware"
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	e := echo.New()
+	e.Use(middleware.Metrics(reg))
+	e.GET("/wallet/:walletId", func(c echo.Context) error {
+		if c.Param("walletId") == "missing" {
+			return echo.NewHTTPError(http.StatusNotFound)
+		}
+		return c.NoContent(http.StatusOK)
+	})
+
+	for _, path := range []string{"/wallet/a", "/wallet/b", "/wallet/missing"} {
+		e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	require.Len(t, families, 1)
+	counts := map[string]uint64{}
+	for _, m := range families[0].GetMetric() {
+		labels := map[string]string{}
+		for _, l := range m.GetLabel() {
+			labels[l.GetName()] = l.GetValue()
+		}
+		assert.Equal(t, "/wallet/:walletId", labels["route"])
+		counts[labels["code"]] = m.GetHistogram().GetSampleCount()
+	}
+	assert.Equal(t, map[string]uint64{"200": 2, "404": 1}, counts)
+}
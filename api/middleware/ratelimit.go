@@ -0,0 +1,222 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/time/rate"
+)
+
+var (
+	ErrRateLimited = errors.New("rate limit exceeded")
+)
+
+const (
+	// minLimiterIdle is the shortest time a limiter is kept after its last
+	// use.
+	minLimiterIdle = time.Minute
+	// maxPeekBody bounds the body read to find the wallet a request changes.
+	maxPeekBody = 64 << 10
+)
+
+type RateLimitOptions struct {
+	// ClientRPS and ClientBurst limit every request of one client, identified
+	// by tenant and token subject, or by IP when unauthenticated. A zero rate
+	// disables the limit.
+	ClientRPS   float64
+	ClientBurst int
+	// WalletRPS and WalletBurst limit the changes to one wallet of a tenant,
+	// whichever client sends them. Requests the handler rejects with 403 or
+	// 404 give their token back, so nobody can use up the budget of a wallet
+	// they have no access to. A zero rate disables the limit.
+	WalletRPS   float64
+	WalletBurst int
+	// WalletRoutes maps the POST routes (as registered with echo) that change
+	// a wallet to the field of their JSON body naming it.
+	WalletRoutes map[string]string
+}
+
+// RateLimit rejects requests over the configured token-bucket rates with 429
+// and a Retry-After header. It must run after Auth and Tenant so clients are
+// told apart by subject and wallets by tenant.
+func RateLimit(opts RateLimitOptions) echo.MiddlewareFunc {
+	clients := newLimiters(opts.ClientRPS, opts.ClientBurst)
+	wallets := newLimiters(opts.WalletRPS, opts.WalletBurst)
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			now := time.Now()
+			if clients != nil {
+				if wait, ok := clients.allow(clientKey(c), now); !ok {
+					return tooManyRequests(c, wait)
+				}
+			}
+			field, ok := opts.WalletRoutes[c.Path()]
+			if wallets == nil || !ok || c.Request().Method != http.MethodPost {
+				return next(c)
+			}
+			walletID, err := peekWalletID(c, field)
+			if err != nil {
+				return err
+			}
+			if walletID == "" {
+				return next(c)
+			}
+			r, wait, ok := wallets.reserve(walletKey(c, walletID), now)
+			if !ok {
+				return tooManyRequests(c, wait)
+			}
+			err = next(c)
+			if accessDenied(c, err) {
+				// Cancelling at the reservation's own time is what lets the
+				// limiter restore its token.
+				r.CancelAt(now)
+			}
+			return err
+		}
+	}
+}
+
+// clientKey identifies the caller for the per-client limit.
+func clientKey(c echo.Context) string {
+	if p, ok := PrincipalFrom(c); ok && p.Subject != "" {
+		return "sub:" + p.TenantID + "/" + p.Subject
+	}
+	return "ip:" + c.RealIP()
+}
+
+// walletKey identifies a wallet for the per-wallet limit. Wallet IDs are
+// only unique within a tenant.
+func walletKey(c echo.Context, walletID string) string {
+	var tenantID string
+	if p, ok := PrincipalFrom(c); ok {
+		tenantID = p.TenantID
+	}
+	return tenantID + "/" + strings.ToLower(walletID)
+}
+
+// accessDenied reports whether the handler refused the caller access to the
+// wallet it named.
+func accessDenied(c echo.Context, err error) bool {
+	status := c.Response().Status
+	if err != nil {
+		var he *echo.HTTPError
+		if !errors.As(err, &he) {
+			return false
+		}
+		status = he.Code
+	}
+	return status == http.StatusForbidden || status == http.StatusNotFound
+}
+
+// peekWalletID reads field from the JSON body and rewinds the body for the
+// handler. Malformed bodies are left for the handler to reject; bodies over
+// maxPeekBody are rejected here.
+func peekWalletID(c echo.Context, field string) (string, error) {
+	req := c.Request()
+	if req.Body == nil {
+		return "", nil
+	}
+	raw, err := io.ReadAll(http.MaxBytesReader(c.Response(), req.Body, maxPeekBody))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return "", echo.NewHTTPError(http.StatusRequestEntityTooLarge).SetInternal(err)
+		}
+		return "", echo.NewHTTPError(http.StatusBadRequest).SetInternal(err)
+	}
+	req.Body = io.NopCloser(bytes.NewReader(raw))
+
+	var body map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &body); err != nil {
+		return "", nil
+	}
+	var walletID string
+	if err := json.Unmarshal(body[field], &walletID); err != nil {
+		return "", nil
+	}
+	return walletID, nil
+}
+
+func tooManyRequests(c echo.Context, wait time.Duration) error {
+	seconds := int(math.Ceil(wait.Seconds()))
+	c.Response().Header().Set("Retry-After", strconv.Itoa(max(seconds, 1)))
+	return echo.NewHTTPError(http.StatusTooManyRequests, map[string]string{
+		"error": ErrRateLimited.Error(),
+	})
+}
+
+// limiters holds one token bucket per key and drops buckets that have been
+// idle long enough to refill completely.
+type limiters struct {
+	limit rate.Limit
+	burst int
+	idle  time.Duration
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func newLimiters(rps float64, burst int) *limiters {
+	if rps <= 0 {
+		return nil
+	}
+	burst = max(burst, 1)
+	refill := time.Duration(float64(burst) / rps * float64(time.Second))
+	return &limiters{
+		limit:   rate.Limit(rps),
+		burst:   burst,
+		idle:    max(refill, minLimiterIdle),
+		buckets: map[string]*bucket{},
+	}
+}
+
+// allow takes a token for key. Otherwise it reports how long until one is
+// available.
+func (l *limiters) allow(key string, now time.Time) (time.Duration, bool) {
+	_, wait, ok := l.reserve(key, now)
+	return wait, ok
+}
+
+// reserve is allow returning the reservation, which can be cancelled to give
+// the token back.
+func (l *limiters) reserve(key string, now time.Time) (*rate.Reservation, time.Duration, bool) {
+	l.mu.Lock()
+	if now.Sub(l.lastSweep) > l.idle {
+		for k, b := range l.buckets {
+			if now.Sub(b.lastSeen) > l.idle {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.buckets[key] = b
+	}
+	b.lastSeen = now
+	l.mu.Unlock()
+
+	r := b.limiter.ReserveN(now, 1)
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		return nil, delay, false
+	}
+	return r, 0, true
+}
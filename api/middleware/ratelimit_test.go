@@ -0,0 +1,169 @@
+//go:build unit
+
+package middleware_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ichigo7diabol/go-test-wallet/api/middleware"
+	"github.com/ichigo7diabol/go-test-wallet/internal/app"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func newRateLimitedServer(opts middleware.RateLimitOptions) *echo.Echo {
+	e := echo.New()
+	api := e.Group("/api/v1", func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if sub := c.Request().Header.Get("X-Sub"); sub != "" {
+				middleware.SetPrincipal(c, &app.Principal{
+					Subject:  sub,
+					TenantID: c.Request().Header.Get("X-Tenant"),
+				})
+			}
+			return next(c)
+		}
+	}, middleware.RateLimit(opts))
+	change := func(c echo.Context) error {
+		if p, _ := middleware.PrincipalFrom(c); p != nil && p.Subject == "stranger" {
+			return echo.NewHTTPError(http.StatusNotFound)
+		}
+		body, _ := io.ReadAll(c.Request().Body)
+		return c.String(http.StatusOK, string(body))
+	}
+	api.POST("/wallet", change)
+	api.POST("/transfers", change)
+	api.GET("/wallets", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+	return e
+}
+
+var walletRoutes = map[string]string{
+	"/api/v1/wallet":    "walletId",
+	"/api/v1/transfers": "fromWalletId",
+}
+
+func post(e *echo.Echo, path, sub, tenantID, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set("X-Sub", sub)
+	req.Header.Set("X-Tenant", tenantID)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func postWallet(e *echo.Echo, sub, walletID string) *httptest.ResponseRecorder {
+	return post(e, "/api/v1/wallet", sub, "", `{"walletId":"`+walletID+`","operationType":"DEPOSIT"}`)
+}
+
+func TestRateLimit_Wallet(t *testing.T) {
+	e := newRateLimitedServer(middleware.RateLimitOptions{
+		WalletRPS:    1,
+		WalletBurst:  2,
+		WalletRoutes: walletRoutes,
+	})
+
+	for range 2 {
+		rec := postWallet(e, "user-1", "wallet-a")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"walletId":"wallet-a"`)
+	}
+
+	rec := postWallet(e, "user-1", "wallet-a")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+
+	assert.Equal(t, http.StatusOK, postWallet(e, "user-1", "wallet-b").Code)
+}
+
+func TestRateLimit_WalletSharedByClients(t *testing.T) {
+	e := newRateLimitedServer(middleware.RateLimitOptions{
+		WalletRPS:    1,
+		WalletBurst:  2,
+		WalletRoutes: walletRoutes,
+	})
+
+	assert.Equal(t, http.StatusOK, postWallet(e, "user-1", "wallet-a").Code)
+	assert.Equal(t, http.StatusOK, postWallet(e, "user-2", "wallet-a").Code)
+	// The wallet's budget is spent whichever client sends the next change.
+	assert.Equal(t, http.StatusTooManyRequests, postWallet(e, "user-1", "wallet-a").Code)
+	assert.Equal(t, http.StatusTooManyRequests, postWallet(e, "user-2", "WALLET-A").Code)
+
+	// The same wallet ID in another tenant is another wallet.
+	body := `{"walletId":"wallet-a","operationType":"DEPOSIT"}`
+	assert.Equal(t, http.StatusOK, post(e, "/api/v1/wallet", "user-3", "globex", body).Code)
+}
+
+func TestRateLimit_WalletRefundsDeniedRequests(t *testing.T) {
+	e := newRateLimitedServer(middleware.RateLimitOptions{
+		WalletRPS:    1,
+		WalletBurst:  1,
+		WalletRoutes: walletRoutes,
+	})
+
+	// A caller without access to the wallet cannot use up its budget.
+	for range 3 {
+		assert.Equal(t, http.StatusNotFound, postWallet(e, "stranger", "wallet-a").Code)
+	}
+	assert.Equal(t, http.StatusOK, postWallet(e, "user-1", "wallet-a").Code)
+	assert.Equal(t, http.StatusTooManyRequests, postWallet(e, "user-1", "wallet-a").Code)
+}
+
+func TestRateLimit_TransferSourceWallet(t *testing.T) {
+	e := newRateLimitedServer(middleware.RateLimitOptions{
+		WalletRPS:    1,
+		WalletBurst:  1,
+		WalletRoutes: walletRoutes,
+	})
+
+	transfer := `{"fromWalletId":"wallet-a","toWalletId":"wallet-b","amount":"1.00","currency":"USD"}`
+	assert.Equal(t, http.StatusOK, post(e, "/api/v1/transfers", "user-1", "", transfer).Code)
+	assert.Equal(t, http.StatusTooManyRequests, postWallet(e, "user-1", "wallet-a").Code)
+	// The destination wallet is not limited by transfers into it.
+	assert.Equal(t, http.StatusOK, postWallet(e, "user-1", "wallet-b").Code)
+}
+
+func TestRateLimit_WalletBodyTooLarge(t *testing.T) {
+	e := newRateLimitedServer(middleware.RateLimitOptions{
+		WalletRPS:    1,
+		WalletRoutes: walletRoutes,
+	})
+
+	body := `{"walletId":"wallet-a","metadata":{"note":"` + strings.Repeat("x", 128<<10) + `"}}`
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/wallet", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+}
+
+func TestRateLimit_Client(t *testing.T) {
+	e := newRateLimitedServer(middleware.RateLimitOptions{
+		ClientRPS:    0.5,
+		ClientBurst:  1,
+		WalletRoutes: walletRoutes,
+	})
+
+	assert.Equal(t, http.StatusOK, postWallet(e, "user-1", "wallet-a").Code)
+
+	rec := postWallet(e, "user-1", "wallet-b")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "2", rec.Header().Get("Retry-After"))
+
+	assert.Equal(t, http.StatusOK, postWallet(e, "user-2", "wallet-a").Code)
+}
+
+func TestRateLimit_Disabled(t *testing.T) {
+	e := newRateLimitedServer(middleware.RateLimitOptions{WalletRoutes: walletRoutes})
+
+	for range 10 {
+		assert.Equal(t, http.StatusOK, postWallet(e, "user-1", "wallet-a").Code)
+	}
+}
@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/ichigo7diabol/go-test-wallet/internal/app"
+	"github.com/labstack/echo/v4"
+)
+
+var (
+	ErrTenantMismatch = errors.New("tenant header does not match token tenant")
+	ErrTenantRequired = errors.New("token has no tenant")
+)
+
+// Tenant resolves the tenant of a request from header. With authentication
+// the tenant is the token's tenant_id claim: the header may only repeat it,
+// and tokens without the claim are rejected, since a header the caller sets
+// would let them pick any tenant. Without authentication the request runs as
+// an admin of the header's tenant, matching the unrestricted access it has
+// otherwise.
+func Tenant(header string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			tenantID := c.Request().Header.Get(header)
+			p, ok := PrincipalFrom(c)
+			if !ok {
+				p = &app.Principal{Scopes: []string{app.AdminScope}}
+			}
+			switch {
+			case ok && p.TenantID == "":
+				return echo.NewHTTPError(http.StatusForbidden, map[string]string{
+					"error": ErrTenantRequired.Error(),
+				})
+			case tenantID == "":
+			case !ok:
+				p.TenantID = tenantID
+			case p.TenantID != tenantID:
+				return echo.NewHTTPError(http.StatusForbidden, map[string]string{
+					"error": ErrTenantMismatch.Error(),
+				})
+			}
+			if ok || tenantID != "" {
+				SetPrincipal(c, p)
+			}
+			return next(c)
+		}
+	}
+}
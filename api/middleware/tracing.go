@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracing starts a server span for every request, continuing the W3C trace
+// context sent by the caller. Spans are named after the matched route.
+func Tracing(service string) echo.MiddlewareFunc {
+	traced := echo.WrapMiddleware(otelhttp.NewMiddleware(service))
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return traced(func(c echo.Context) error {
+			span := trace.SpanFromContext(c.Request().Context())
+			if route := c.Path(); route != "" {
+				span.SetName(c.Request().Method + " " + route)
+				span.SetAttributes(semconv.HTTPRoute(route))
+			}
+			if err := next(c); err != nil {
+				span.RecordError(err)
+				// Write the error response while the span is open so its
+				// status code is recorded.
+				c.Error(err)
+			}
+			return nil
+		})
+	}
+}
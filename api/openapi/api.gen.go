@@ -13,6 +13,28 @@ import (
 	openapi_types "github.com/oapi-codegen/runtime/types"
 )
 
+const (
+	BearerAuthScopes = "bearerAuth.Scopes"
+)
+
+// Defines values for HoldStatus.
+const (
+	ACTIVE   HoldStatus = "ACTIVE"
+	CAPTURED HoldStatus = "CAPTURED"
+	EXPIRED  HoldStatus = "EXPIRED"
+	VOIDED   HoldStatus = "VOIDED"
+)
+
+// Defines values for TransactionOperationType.
+const (
+	TransactionOperationTypeADJUST   TransactionOperationType = "ADJUST"
+	TransactionOperationTypeCAPTURE  TransactionOperationType = "CAPTURE"
+	TransactionOperationTypeDEPOSIT  TransactionOperationType = "DEPOSIT"
+	TransactionOperationTypeOPEN     TransactionOperationType = "OPEN"
+	TransactionOperationTypeTRANSFER TransactionOperationType = "TRANSFER"
+	TransactionOperationTypeWITHDRAW TransactionOperationType = "WITHDRAW"
+)
+
 // Defines values for WalletOperationRequestOperationType.
 const (
 	WalletOperationRequestOperationTypeDEPOSIT  WalletOperationRequestOperationType = "DEPOSIT"
@@ -25,22 +47,166 @@ const (
 	WalletOperationResponseOperationTypeWITHDRAW WalletOperationResponseOperationType = "WITHDRAW"
 )
 
+// AuthorizeHoldRequest defines model for AuthorizeHoldRequest.
+type AuthorizeHoldRequest struct {
+	Amount string `json:"amount"`
+
+	// Currency Код валюты ISO 4217, должен совпадать с валютой кошелька
+	Currency string `json:"currency"`
+
+	// ExpectedVersion Версия кошелька (для перевода — кошелька-отправителя, для блокировки — ее кошелька), которую клиент прочитал. Альтернатива заголовку If-Match; если переданы оба, они должны совпадать.
+	ExpectedVersion *ExpectedVersion `json:"expectedVersion,omitempty"`
+
+	// ExpiresAt Момент автоматического снятия блокировки
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+
+	// Metadata Произвольные атрибуты блокировки
+	Metadata *map[string]string `json:"metadata,omitempty"`
+}
+
+// CaptureHoldRequest defines model for CaptureHoldRequest.
+type CaptureHoldRequest struct {
+	// Amount Сумма списания, по умолчанию — вся сумма блокировки
+	Amount *string `json:"amount,omitempty"`
+
+	// ExpectedVersion Версия кошелька (для перевода — кошелька-отправителя, для блокировки — ее кошелька), которую клиент прочитал. Альтернатива заголовку If-Match; если переданы оба, они должны совпадать.
+	ExpectedVersion *ExpectedVersion `json:"expectedVersion,omitempty"`
+}
+
 // CreateWalletRequest defines model for CreateWalletRequest.
 type CreateWalletRequest struct {
-	InitialBalance float32 `json:"initialBalance"`
+	// Currency Код валюты ISO 4217, по умолчанию — валюта сервиса
+	Currency       *string `json:"currency,omitempty"`
+	InitialBalance string  `json:"initialBalance"`
+}
+
+// Error defines model for Error.
+type Error struct {
+	// Error Описание ошибки
+	Error *string `json:"error,omitempty"`
+
+	// Operation operationId, для которого не хватило области доступа
+	Operation *string `json:"operation,omitempty"`
+
+	// RequiredScope Недостающая область доступа
+	RequiredScope *string `json:"requiredScope,omitempty"`
+}
+
+// ExpectedVersion Версия кошелька (для перевода — кошелька-отправителя, для блокировки — ее кошелька), которую клиент прочитал. Альтернатива заголовку If-Match; если переданы оба, они должны совпадать.
+type ExpectedVersion = int64
+
+// Hold defines model for Hold.
+type Hold struct {
+	Amount         *string             `json:"amount,omitempty"`
+	CapturedAmount *string             `json:"capturedAmount,omitempty"`
+	CreatedAt      *time.Time          `json:"createdAt,omitempty"`
+	Currency       *string             `json:"currency,omitempty"`
+	ExpiresAt      *time.Time          `json:"expiresAt,omitempty"`
+	Id             *openapi_types.UUID `json:"id,omitempty"`
+	Metadata       *map[string]string  `json:"metadata,omitempty"`
+	Status         *HoldStatus         `json:"status,omitempty"`
+	UpdatedAt      *time.Time          `json:"updatedAt,omitempty"`
+	WalletId       *openapi_types.UUID `json:"walletId,omitempty"`
+}
+
+// HoldCaptureResponse defines model for HoldCaptureResponse.
+type HoldCaptureResponse struct {
+	Hold       *Hold   `json:"hold,omitempty"`
+	NewBalance *string `json:"newBalance,omitempty"`
+	OldBalance *string `json:"oldBalance,omitempty"`
+}
+
+// HoldStatus defines model for HoldStatus.
+type HoldStatus string
+
+// Transaction defines model for Transaction.
+type Transaction struct {
+	Amount        *string                   `json:"amount,omitempty"`
+	BalanceAfter  *string                   `json:"balanceAfter,omitempty"`
+	BalanceBefore *string                   `json:"balanceBefore,omitempty"`
+	CreatedAt     *time.Time                `json:"createdAt,omitempty"`
+	Currency      *string                   `json:"currency,omitempty"`
+	Id            *openapi_types.UUID       `json:"id,omitempty"`
+	Metadata      *map[string]string        `json:"metadata,omitempty"`
+	OperationType *TransactionOperationType `json:"operationType,omitempty"`
+	WalletId      *openapi_types.UUID       `json:"walletId,omitempty"`
+}
+
+// TransactionOperationType defines model for TransactionOperationType.
+type TransactionOperationType string
+
+// TransactionPage defines model for TransactionPage.
+type TransactionPage struct {
+	Items []Transaction `json:"items"`
+
+	// NextCursor Курсор следующей страницы, отсутствует на последней странице
+	NextCursor *string `json:"nextCursor,omitempty"`
+}
+
+// TransferRequest defines model for TransferRequest.
+type TransferRequest struct {
+	Amount string `json:"amount"`
+
+	// Currency Код валюты ISO 4217, должен совпадать с валютой обоих кошельков
+	Currency string `json:"currency"`
+
+	// ExpectedVersion Версия кошелька (для перевода — кошелька-отправителя, для блокировки — ее кошелька), которую клиент прочитал. Альтернатива заголовку If-Match; если переданы оба, они должны совпадать.
+	ExpectedVersion *ExpectedVersion   `json:"expectedVersion,omitempty"`
+	FromWalletId    openapi_types.UUID `json:"fromWalletId"`
+
+	// Metadata Произвольные атрибуты, сохраняемые в журнале операций
+	Metadata   *map[string]string `json:"metadata,omitempty"`
+	ToWalletId openapi_types.UUID `json:"toWalletId"`
+}
+
+// TransferResponse defines model for TransferResponse.
+type TransferResponse struct {
+	Amount         *string             `json:"amount,omitempty"`
+	Currency       *string             `json:"currency,omitempty"`
+	FromNewBalance *string             `json:"fromNewBalance,omitempty"`
+	FromOldBalance *string             `json:"fromOldBalance,omitempty"`
+	FromWalletId   *openapi_types.UUID `json:"fromWalletId,omitempty"`
+	Timestamp      *time.Time          `json:"timestamp,omitempty"`
+	ToNewBalance   *string             `json:"toNewBalance,omitempty"`
+	ToOldBalance   *string             `json:"toOldBalance,omitempty"`
+	ToWalletId     *openapi_types.UUID `json:"toWalletId,omitempty"`
+	TransferId     *openapi_types.UUID `json:"transferId,omitempty"`
 }
 
 // Wallet defines model for Wallet.
 type Wallet struct {
-	Balance   *float32            `json:"balance,omitempty"`
-	CreatedAt *time.Time          `json:"createdAt,omitempty"`
-	UpdatedAt *time.Time          `json:"updatedAt,omitempty"`
-	WalletId  *openapi_types.UUID `json:"walletId,omitempty"`
+	// AvailableBalance Баланс за вычетом активных блокировок
+	AvailableBalance *string    `json:"availableBalance,omitempty"`
+	Balance          *string    `json:"balance,omitempty"`
+	CreatedAt        *time.Time `json:"createdAt,omitempty"`
+
+	// Currency Код валюты ISO 4217
+	Currency *string `json:"currency,omitempty"`
+
+	// HeldBalance Сумма активных блокировок
+	HeldBalance *string `json:"heldBalance,omitempty"`
+
+	// OwnerId Идентификатор (sub) владельца кошелька
+	OwnerId   *string    `json:"ownerId,omitempty"`
+	UpdatedAt *time.Time `json:"updatedAt,omitempty"`
+
+	// Version Версия кошелька, растет при каждом его изменении
+	Version  *int64              `json:"version,omitempty"`
+	WalletId *openapi_types.UUID `json:"walletId,omitempty"`
 }
 
 // WalletOperationRequest defines model for WalletOperationRequest.
 type WalletOperationRequest struct {
-	Amount        float32                             `json:"amount"`
+	Amount string `json:"amount"`
+
+	// Currency Код валюты ISO 4217, должен совпадать с валютой кошелька
+	Currency string `json:"currency"`
+
+	// ExpectedVersion Версия кошелька (для перевода — кошелька-отправителя, для блокировки — ее кошелька), которую клиент прочитал. Альтернатива заголовку If-Match; если переданы оба, они должны совпадать.
+	ExpectedVersion *ExpectedVersion `json:"expectedVersion,omitempty"`
+
+	// Metadata Произвольные атрибуты, сохраняемые в журнале операций
+	Metadata      *map[string]string                  `json:"metadata,omitempty"`
 	OperationType WalletOperationRequestOperationType `json:"operationType"`
 	WalletId      openapi_types.UUID                  `json:"walletId"`
 }
@@ -50,40 +216,149 @@ type WalletOperationRequestOperationType string
 
 // WalletOperationResponse defines model for WalletOperationResponse.
 type WalletOperationResponse struct {
-	Amount        *float32                              `json:"amount,omitempty"`
-	NewBalance    *float32                              `json:"newBalance,omitempty"`
-	OldBalance    *float32                              `json:"oldBalance,omitempty"`
+	Amount        *string                               `json:"amount,omitempty"`
+	Currency      *string                               `json:"currency,omitempty"`
+	NewBalance    *string                               `json:"newBalance,omitempty"`
+	OldBalance    *string                               `json:"oldBalance,omitempty"`
 	OperationType *WalletOperationResponseOperationType `json:"operationType,omitempty"`
 	Timestamp     *time.Time                            `json:"timestamp,omitempty"`
-	WalletId      *openapi_types.UUID                   `json:"walletId,omitempty"`
+
+	// Version Версия кошелька после операции
+	Version  *int64              `json:"version,omitempty"`
+	WalletId *openapi_types.UUID `json:"walletId,omitempty"`
 }
 
 // WalletOperationResponseOperationType defines model for WalletOperationResponse.OperationType.
 type WalletOperationResponseOperationType string
 
+// IdempotencyKey defines model for IdempotencyKey.
+type IdempotencyKey = string
+
+// IfMatch defines model for IfMatch.
+type IfMatch = string
+
+// CreateTransferParams defines parameters for CreateTransfer.
+type CreateTransferParams struct {
+	// IdempotencyKey Уникальный ключ запроса. Повтор с тем же ключом и телом возвращает сохраненный ответ без повторного изменения баланса.
+	IdempotencyKey *IdempotencyKey `json:"Idempotency-Key,omitempty"`
+
+	// IfMatch ETag кошелька из ответа на чтение. Изменение выполняется, только если кошелек с тех пор не менялся; иначе возвращается 409. Для перевода — ETag кошелька-отправителя, для блокировки — ETag ее кошелька.
+	IfMatch *IfMatch `json:"If-Match,omitempty"`
+}
+
+// ChangeWalletParams defines parameters for ChangeWallet.
+type ChangeWalletParams struct {
+	// IdempotencyKey Уникальный ключ запроса. Повтор с тем же ключом и телом возвращает сохраненный ответ без повторного изменения баланса.
+	IdempotencyKey *IdempotencyKey `json:"Idempotency-Key,omitempty"`
+
+	// IfMatch ETag кошелька из ответа на чтение. Изменение выполняется, только если кошелек с тех пор не менялся; иначе возвращается 409. Для перевода — ETag кошелька-отправителя, для блокировки — ETag ее кошелька.
+	IfMatch *IfMatch `json:"If-Match,omitempty"`
+}
+
+// CreateWalletParams defines parameters for CreateWallet.
+type CreateWalletParams struct {
+	// IdempotencyKey Уникальный ключ запроса. Повтор с тем же ключом и телом возвращает сохраненный ответ без повторного изменения баланса.
+	IdempotencyKey *IdempotencyKey `json:"Idempotency-Key,omitempty"`
+}
+
+// DeleteWalletParams defines parameters for DeleteWallet.
+type DeleteWalletParams struct {
+	// IfMatch ETag кошелька из ответа на чтение. Изменение выполняется, только если кошелек с тех пор не менялся; иначе возвращается 409. Для перевода — ETag кошелька-отправителя, для блокировки — ETag ее кошелька.
+	IfMatch *IfMatch `json:"If-Match,omitempty"`
+}
+
+// ListWalletTransactionsParams defines parameters for ListWalletTransactions.
+type ListWalletTransactionsParams struct {
+	// Cursor Курсор следующей страницы из nextCursor
+	Cursor *string `form:"cursor,omitempty" json:"cursor,omitempty"`
+
+	// Limit Максимальное количество операций на странице
+	Limit *int `form:"limit,omitempty" json:"limit,omitempty"`
+
+	// OperationType Фильтр по типу операции
+	OperationType *[]TransactionOperationType `form:"operationType,omitempty" json:"operationType,omitempty"`
+
+	// MinAmount Минимальная сумма операции (включительно)
+	MinAmount *string `form:"minAmount,omitempty" json:"minAmount,omitempty"`
+
+	// MaxAmount Максимальная сумма операции (включительно)
+	MaxAmount *string `form:"maxAmount,omitempty" json:"maxAmount,omitempty"`
+
+	// From Начало интервала времени (включительно)
+	From *time.Time `form:"from,omitempty" json:"from,omitempty"`
+
+	// To Конец интервала времени (не включительно)
+	To *time.Time `form:"to,omitempty" json:"to,omitempty"`
+}
+
+// AuthorizeHoldParams defines parameters for AuthorizeHold.
+type AuthorizeHoldParams struct {
+	// IfMatch ETag кошелька из ответа на чтение. Изменение выполняется, только если кошелек с тех пор не менялся; иначе возвращается 409. Для перевода — ETag кошелька-отправителя, для блокировки — ETag ее кошелька.
+	IfMatch *IfMatch `json:"If-Match,omitempty"`
+}
+
+// CaptureHoldParams defines parameters for CaptureHold.
+type CaptureHoldParams struct {
+	// IfMatch ETag кошелька из ответа на чтение. Изменение выполняется, только если кошелек с тех пор не менялся; иначе возвращается 409. Для перевода — ETag кошелька-отправителя, для блокировки — ETag ее кошелька.
+	IfMatch *IfMatch `json:"If-Match,omitempty"`
+}
+
+// VoidHoldParams defines parameters for VoidHold.
+type VoidHoldParams struct {
+	// IfMatch ETag кошелька из ответа на чтение. Изменение выполняется, только если кошелек с тех пор не менялся; иначе возвращается 409. Для перевода — ETag кошелька-отправителя, для блокировки — ETag ее кошелька.
+	IfMatch *IfMatch `json:"If-Match,omitempty"`
+}
+
 // ChangeWalletJSONRequestBody defines body for ChangeWallet for application/json ContentType.
 type ChangeWalletJSONRequestBody = WalletOperationRequest
 
 // CreateWalletJSONRequestBody defines body for CreateWallet for application/json ContentType.
 type CreateWalletJSONRequestBody = CreateWalletRequest
 
+// CreateTransferJSONRequestBody defines body for CreateTransfer for application/json ContentType.
+type CreateTransferJSONRequestBody = TransferRequest
+
+// AuthorizeHoldJSONRequestBody defines body for AuthorizeHold for application/json ContentType.
+type AuthorizeHoldJSONRequestBody = AuthorizeHoldRequest
+
+// CaptureHoldJSONRequestBody defines body for CaptureHold for application/json ContentType.
+type CaptureHoldJSONRequestBody = CaptureHoldRequest
+
 // ServerInterface represents all server handlers.
 type ServerInterface interface {
 	// ╨í╨╛╨▓╨╡╤Ç╤ê╨╕╤é╤î ╨╛╨┐╨╡╤Ç╨░╤å╨╕╤Ä ╤ü ╨▒╨░╨╗╨░╨╜╤ü╨╛╨╝ (DEPOSIT ╨╕╨╗╨╕ WITHDRAW)
 	// (POST /wallet)
-	ChangeWallet(ctx echo.Context) error
+	ChangeWallet(ctx echo.Context, params ChangeWalletParams) error
 	// ╨ƒ╨╛╨╗╤â╤ç╨╕╤é╤î ╤ü╨┐╨╕╤ü╨╛╨║ ╨▓╤ü╨╡╤à ╨║╨╛╤ê╨╡╨╗╤î╨║╨╛╨▓
 	// (GET /wallets)
 	ListWallets(ctx echo.Context) error
 	// ╨í╨╛╨╖╨┤╨░╤é╤î ╨╜╨╛╨▓╤ï╨╣ ╨║╨╛╤ê╨╡╨╗╨╡╨║
 	// (POST /wallets)
-	CreateWallet(ctx echo.Context) error
+	CreateWallet(ctx echo.Context, params CreateWalletParams) error
 	// ╨ú╨┤╨░╨╗╨╕╤é╤î ╨║╨╛╤ê╨╡╨╗╨╡╨║
 	// (DELETE /wallets/{walletId})
-	DeleteWallet(ctx echo.Context, walletId openapi_types.UUID) error
+	DeleteWallet(ctx echo.Context, walletId openapi_types.UUID, params DeleteWalletParams) error
 	// ╨ƒ╨╛╨╗╤â╤ç╨╕╤é╤î ╨╕╨╜╤ä╨╛╤Ç╨╝╨░╤å╨╕╤Ä ╨╛ ╨║╨╛╤ê╨╡╨╗╤î╨║╨╡
 	// (GET /wallets/{walletId})
 	GetWallet(ctx echo.Context, walletId openapi_types.UUID) error
+	// Получить историю операций кошелька
+	// (GET /wallet/{walletId}/transactions)
+	ListWalletTransactions(ctx echo.Context, walletId openapi_types.UUID, params ListWalletTransactionsParams) error
+	// Перевести средства между кошельками
+	// (POST /transfers)
+	CreateTransfer(ctx echo.Context, params CreateTransferParams) error
+	// Заблокировать средства на кошельке
+	// (POST /wallet/{walletId}/holds)
+	AuthorizeHold(ctx echo.Context, walletId openapi_types.UUID, params AuthorizeHoldParams) error
+	// Получить информацию о блокировке
+	// (GET /holds/{holdId})
+	GetHold(ctx echo.Context, holdId openapi_types.UUID) error
+	// Списать заблокированные средства
+	// (POST /holds/{holdId}/capture)
+	CaptureHold(ctx echo.Context, holdId openapi_types.UUID, params CaptureHoldParams) error
+	// Отменить блокировку
+	// (POST /holds/{holdId}/void)
+	VoidHold(ctx echo.Context, holdId openapi_types.UUID, params VoidHoldParams) error
 }
 
 // ServerInterfaceWrapper converts echo contexts to parameters.
@@ -95,8 +370,47 @@ type ServerInterfaceWrapper struct {
 func (w *ServerInterfaceWrapper) ChangeWallet(ctx echo.Context) error {
 	var err error
 
+	ctx.Set(BearerAuthScopes, []string{"wallet:deposit"})
+
+	ctx.Set(BearerAuthScopes, []string{"wallet:withdraw"})
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params ChangeWalletParams
+
+	headers := ctx.Request().Header
+	// ------------- Optional header parameter "Idempotency-Key" -------------
+	if valueList, found := headers[http.CanonicalHeaderKey("Idempotency-Key")]; found {
+		var IdempotencyKey IdempotencyKey
+		n := len(valueList)
+		if n != 1 {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Expected one value for Idempotency-Key, got %d", n))
+		}
+
+		err = runtime.BindStyledParameterWithOptions("simple", "Idempotency-Key", valueList[0], &IdempotencyKey, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationHeader, Explode: false, Required: false})
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter Idempotency-Key: %s", err))
+		}
+
+		params.IdempotencyKey = &IdempotencyKey
+	}
+	// ------------- Optional header parameter "If-Match" -------------
+	if valueList, found := headers[http.CanonicalHeaderKey("If-Match")]; found {
+		var IfMatch IfMatch
+		n := len(valueList)
+		if n != 1 {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Expected one value for If-Match, got %d", n))
+		}
+
+		err = runtime.BindStyledParameterWithOptions("simple", "If-Match", valueList[0], &IfMatch, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationHeader, Explode: false, Required: false})
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter If-Match: %s", err))
+		}
+
+		params.IfMatch = &IfMatch
+	}
+
 	// Invoke the callback with all the unmarshaled arguments
-	err = w.Handler.ChangeWallet(ctx)
+	err = w.Handler.ChangeWallet(ctx, params)
 	return err
 }
 
@@ -104,6 +418,8 @@ func (w *ServerInterfaceWrapper) ChangeWallet(ctx echo.Context) error {
 func (w *ServerInterfaceWrapper) ListWallets(ctx echo.Context) error {
 	var err error
 
+	ctx.Set(BearerAuthScopes, []string{"wallet:read"})
+
 	// Invoke the callback with all the unmarshaled arguments
 	err = w.Handler.ListWallets(ctx)
 	return err
@@ -113,8 +429,30 @@ func (w *ServerInterfaceWrapper) ListWallets(ctx echo.Context) error {
 func (w *ServerInterfaceWrapper) CreateWallet(ctx echo.Context) error {
 	var err error
 
+	ctx.Set(BearerAuthScopes, []string{"wallet:create"})
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params CreateWalletParams
+
+	headers := ctx.Request().Header
+	// ------------- Optional header parameter "Idempotency-Key" -------------
+	if valueList, found := headers[http.CanonicalHeaderKey("Idempotency-Key")]; found {
+		var IdempotencyKey IdempotencyKey
+		n := len(valueList)
+		if n != 1 {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Expected one value for Idempotency-Key, got %d", n))
+		}
+
+		err = runtime.BindStyledParameterWithOptions("simple", "Idempotency-Key", valueList[0], &IdempotencyKey, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationHeader, Explode: false, Required: false})
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter Idempotency-Key: %s", err))
+		}
+
+		params.IdempotencyKey = &IdempotencyKey
+	}
+
 	// Invoke the callback with all the unmarshaled arguments
-	err = w.Handler.CreateWallet(ctx)
+	err = w.Handler.CreateWallet(ctx, params)
 	return err
 }
 
@@ -129,8 +467,30 @@ func (w *ServerInterfaceWrapper) DeleteWallet(ctx echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter walletId: %s", err))
 	}
 
+	ctx.Set(BearerAuthScopes, []string{"wallet:admin"})
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params DeleteWalletParams
+
+	headers := ctx.Request().Header
+	// ------------- Optional header parameter "If-Match" -------------
+	if valueList, found := headers[http.CanonicalHeaderKey("If-Match")]; found {
+		var IfMatch IfMatch
+		n := len(valueList)
+		if n != 1 {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Expected one value for If-Match, got %d", n))
+		}
+
+		err = runtime.BindStyledParameterWithOptions("simple", "If-Match", valueList[0], &IfMatch, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationHeader, Explode: false, Required: false})
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter If-Match: %s", err))
+		}
+
+		params.IfMatch = &IfMatch
+	}
+
 	// Invoke the callback with all the unmarshaled arguments
-	err = w.Handler.DeleteWallet(ctx, walletId)
+	err = w.Handler.DeleteWallet(ctx, walletId, params)
 	return err
 }
 
@@ -145,11 +505,260 @@ func (w *ServerInterfaceWrapper) GetWallet(ctx echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter walletId: %s", err))
 	}
 
+	ctx.Set(BearerAuthScopes, []string{"wallet:read"})
+
 	// Invoke the callback with all the unmarshaled arguments
 	err = w.Handler.GetWallet(ctx, walletId)
 	return err
 }
 
+// ListWalletTransactions converts echo context to params.
+func (w *ServerInterfaceWrapper) ListWalletTransactions(ctx echo.Context) error {
+	var err error
+	// ------------- Path parameter "walletId" -------------
+	var walletId openapi_types.UUID
+
+	err = runtime.BindStyledParameterWithOptions("simple", "walletId", ctx.Param("walletId"), &walletId, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter walletId: %s", err))
+	}
+
+	ctx.Set(BearerAuthScopes, []string{"wallet:read"})
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params ListWalletTransactionsParams
+	// ------------- Optional query parameter "cursor" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "cursor", ctx.QueryParams(), &params.Cursor)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter cursor: %s", err))
+	}
+
+	// ------------- Optional query parameter "limit" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "limit", ctx.QueryParams(), &params.Limit)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter limit: %s", err))
+	}
+
+	// ------------- Optional query parameter "operationType" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "operationType", ctx.QueryParams(), &params.OperationType)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter operationType: %s", err))
+	}
+
+	// ------------- Optional query parameter "minAmount" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "minAmount", ctx.QueryParams(), &params.MinAmount)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter minAmount: %s", err))
+	}
+
+	// ------------- Optional query parameter "maxAmount" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "maxAmount", ctx.QueryParams(), &params.MaxAmount)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter maxAmount: %s", err))
+	}
+
+	// ------------- Optional query parameter "from" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "from", ctx.QueryParams(), &params.From)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter from: %s", err))
+	}
+
+	// ------------- Optional query parameter "to" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "to", ctx.QueryParams(), &params.To)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter to: %s", err))
+	}
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.ListWalletTransactions(ctx, walletId, params)
+	return err
+}
+
+// CreateTransfer converts echo context to params.
+func (w *ServerInterfaceWrapper) CreateTransfer(ctx echo.Context) error {
+	var err error
+
+	ctx.Set(BearerAuthScopes, []string{"wallet:transfer"})
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params CreateTransferParams
+
+	headers := ctx.Request().Header
+	// ------------- Optional header parameter "Idempotency-Key" -------------
+	if valueList, found := headers[http.CanonicalHeaderKey("Idempotency-Key")]; found {
+		var IdempotencyKey IdempotencyKey
+		n := len(valueList)
+		if n != 1 {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Expected one value for Idempotency-Key, got %d", n))
+		}
+
+		err = runtime.BindStyledParameterWithOptions("simple", "Idempotency-Key", valueList[0], &IdempotencyKey, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationHeader, Explode: false, Required: false})
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter Idempotency-Key: %s", err))
+		}
+
+		params.IdempotencyKey = &IdempotencyKey
+	}
+	// ------------- Optional header parameter "If-Match" -------------
+	if valueList, found := headers[http.CanonicalHeaderKey("If-Match")]; found {
+		var IfMatch IfMatch
+		n := len(valueList)
+		if n != 1 {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Expected one value for If-Match, got %d", n))
+		}
+
+		err = runtime.BindStyledParameterWithOptions("simple", "If-Match", valueList[0], &IfMatch, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationHeader, Explode: false, Required: false})
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter If-Match: %s", err))
+		}
+
+		params.IfMatch = &IfMatch
+	}
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.CreateTransfer(ctx, params)
+	return err
+}
+
+// AuthorizeHold converts echo context to params.
+func (w *ServerInterfaceWrapper) AuthorizeHold(ctx echo.Context) error {
+	var err error
+	// ------------- Path parameter "walletId" -------------
+	var walletId openapi_types.UUID
+
+	err = runtime.BindStyledParameterWithOptions("simple", "walletId", ctx.Param("walletId"), &walletId, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter walletId: %s", err))
+	}
+
+	ctx.Set(BearerAuthScopes, []string{"wallet:hold"})
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params AuthorizeHoldParams
+
+	headers := ctx.Request().Header
+	// ------------- Optional header parameter "If-Match" -------------
+	if valueList, found := headers[http.CanonicalHeaderKey("If-Match")]; found {
+		var IfMatch IfMatch
+		n := len(valueList)
+		if n != 1 {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Expected one value for If-Match, got %d", n))
+		}
+
+		err = runtime.BindStyledParameterWithOptions("simple", "If-Match", valueList[0], &IfMatch, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationHeader, Explode: false, Required: false})
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter If-Match: %s", err))
+		}
+
+		params.IfMatch = &IfMatch
+	}
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.AuthorizeHold(ctx, walletId, params)
+	return err
+}
+
+// GetHold converts echo context to params.
+func (w *ServerInterfaceWrapper) GetHold(ctx echo.Context) error {
+	var err error
+	// ------------- Path parameter "holdId" -------------
+	var holdId openapi_types.UUID
+
+	err = runtime.BindStyledParameterWithOptions("simple", "holdId", ctx.Param("holdId"), &holdId, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter holdId: %s", err))
+	}
+
+	ctx.Set(BearerAuthScopes, []string{"wallet:read"})
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.GetHold(ctx, holdId)
+	return err
+}
+
+// CaptureHold converts echo context to params.
+func (w *ServerInterfaceWrapper) CaptureHold(ctx echo.Context) error {
+	var err error
+	// ------------- Path parameter "holdId" -------------
+	var holdId openapi_types.UUID
+
+	err = runtime.BindStyledParameterWithOptions("simple", "holdId", ctx.Param("holdId"), &holdId, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter holdId: %s", err))
+	}
+
+	ctx.Set(BearerAuthScopes, []string{"wallet:hold"})
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params CaptureHoldParams
+
+	headers := ctx.Request().Header
+	// ------------- Optional header parameter "If-Match" -------------
+	if valueList, found := headers[http.CanonicalHeaderKey("If-Match")]; found {
+		var IfMatch IfMatch
+		n := len(valueList)
+		if n != 1 {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Expected one value for If-Match, got %d", n))
+		}
+
+		err = runtime.BindStyledParameterWithOptions("simple", "If-Match", valueList[0], &IfMatch, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationHeader, Explode: false, Required: false})
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter If-Match: %s", err))
+		}
+
+		params.IfMatch = &IfMatch
+	}
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.CaptureHold(ctx, holdId, params)
+	return err
+}
+
+// VoidHold converts echo context to params.
+func (w *ServerInterfaceWrapper) VoidHold(ctx echo.Context) error {
+	var err error
+	// ------------- Path parameter "holdId" -------------
+	var holdId openapi_types.UUID
+
+	err = runtime.BindStyledParameterWithOptions("simple", "holdId", ctx.Param("holdId"), &holdId, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter holdId: %s", err))
+	}
+
+	ctx.Set(BearerAuthScopes, []string{"wallet:hold"})
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params VoidHoldParams
+
+	headers := ctx.Request().Header
+	// ------------- Optional header parameter "If-Match" -------------
+	if valueList, found := headers[http.CanonicalHeaderKey("If-Match")]; found {
+		var IfMatch IfMatch
+		n := len(valueList)
+		if n != 1 {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Expected one value for If-Match, got %d", n))
+		}
+
+		err = runtime.BindStyledParameterWithOptions("simple", "If-Match", valueList[0], &IfMatch, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationHeader, Explode: false, Required: false})
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter If-Match: %s", err))
+		}
+
+		params.IfMatch = &IfMatch
+	}
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.VoidHold(ctx, holdId, params)
+	return err
+}
+
 // This is a simple interface which specifies echo.Route addition functions which
 // are present on both echo.Echo and echo.Group, since we want to allow using
 // either of them for path registration
@@ -183,5 +792,11 @@ func RegisterHandlersWithBaseURL(router EchoRouter, si ServerInterface, baseURL
 	router.POST(baseURL+"/wallets", wrapper.CreateWallet)
 	router.DELETE(baseURL+"/wallet/:walletId", wrapper.DeleteWallet)
 	router.GET(baseURL+"/wallet/:walletId", wrapper.GetWallet)
+	router.GET(baseURL+"/wallet/:walletId/transactions", wrapper.ListWalletTransactions)
+	router.POST(baseURL+"/transfers", wrapper.CreateTransfer)
+	router.POST(baseURL+"/wallet/:walletId/holds", wrapper.AuthorizeHold)
+	router.GET(baseURL+"/holds/:holdId", wrapper.GetHold)
+	router.POST(baseURL+"/holds/:holdId/capture", wrapper.CaptureHold)
+	router.POST(baseURL+"/holds/:holdId/void", wrapper.VoidHold)
 
 }
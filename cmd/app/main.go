@@ -1,19 +1,54 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
 	"github.com/ichigo7diabol/go-test-wallet/api/handlers"
+	"github.com/ichigo7diabol/go-test-wallet/api/middleware"
 	"github.com/ichigo7diabol/go-test-wallet/api/openapi"
 	"github.com/ichigo7diabol/go-test-wallet/internal/app"
 	"github.com/ichigo7diabol/go-test-wallet/internal/config"
+	"github.com/ichigo7diabol/go-test-wallet/internal/database"
+	"github.com/ichigo7diabol/go-test-wallet/internal/migrations"
 	"github.com/ichigo7diabol/go-test-wallet/internal/models"
 	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.infratographer.com/x/echox/echozap"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
 	"go.uber.org/zap"
-	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/plugin/opentelemetry/tracing"
 )
 
+const ServiceName = "go-test-wallet"
+
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "healthcheck":
+			os.Exit(Healthcheck(config.Load().Port))
+		case "migrate":
+			os.Exit(Migrate(config.Load(), os.Args[2:]))
+		}
+	}
+
 	z, _ := zap.NewProduction()
 	defer z.Sync()
 
@@ -26,39 +61,419 @@ func main() {
 	config := config.Load()
 
 	z.Info("Configfiguration",
+		zap.Bool("Release", config.Release),
 		zap.String("Port", config.Port),
 		zap.String("Dsn", config.Dsn),
+		zap.String("DefaultCurrency", config.DefaultCurrency),
+		zap.Duration("HoldTTL", config.HoldTTL),
+		zap.Duration("HoldSweepInterval", config.HoldSweepInterval),
+		zap.Duration("ReadTimeout", config.ReadTimeout),
+		zap.Duration("WriteTimeout", config.WriteTimeout),
+		zap.Bool("AuthDisabled", config.AuthDisabled),
+		zap.String("AuthJWKSURL", config.AuthJWKSURL),
+		zap.String("AuthJWKSFile", config.AuthJWKSFile),
+		zap.String("AuthIssuer", config.AuthIssuer),
+		zap.String("AuthAudience", config.AuthAudience),
+		zap.String("TenantHeader", config.TenantHeader),
+		zap.String("TenantsFile", config.TenantsFile),
+		zap.Float64("RateLimitClientRPS", config.RateLimitClientRPS),
+		zap.Int("RateLimitClientBurst", config.RateLimitClientBurst),
+		zap.Float64("RateLimitWalletRPS", config.RateLimitWalletRPS),
+		zap.Int("RateLimitWalletBurst", config.RateLimitWalletBurst),
+		zap.String("TracingExporter", config.TracingExporter),
+		zap.String("TracingEndpoint", config.TracingEndpoint),
+		zap.Duration("ShutdownDelay", config.ShutdownDelay),
+		zap.Duration("ShutdownTimeout", config.ShutdownTimeout),
+		zap.Bool("MigrateOnStart", config.MigrateOnStart),
+		zap.Int("BalanceSlots", config.BalanceSlots),
+		zap.Bool("AtomicUpdates", config.AtomicUpdates),
+		zap.Duration("BatchWindow", config.BatchWindow),
+		zap.Int("BatchSize", config.BatchSize),
 	)
+	z.Info("Setup tracing")
+	shutdownTracing, err := NewTracerProvider(context.Background(), config)
+	if err != nil {
+		z.Sugar().Fatal(err)
+	}
 	z.Info("Connecting to database")
 	db, cleanup, err := NewDatabaseConnection(config.Dsn)
 	if err != nil {
 		z.Sugar().Fatal(err)
 	}
-	defer cleanup()
-	if err := db.AutoMigrate(&models.WalletModel{}); err != nil {
+	migrator, err := migrations.New(db)
+	if err != nil {
+		z.Sugar().Fatal(err)
+	}
+	if config.MigrateOnStart {
+		z.Info("Applying migrations")
+		applied, err := migrator.Up(context.Background())
+		if err != nil {
+			z.Sugar().Fatal(err)
+		}
+		for _, m := range applied {
+			z.Info("Applied migration", zap.Int64("Version", m.Version), zap.String("Name", m.Name))
+		}
+	}
+	tenants, err := NewTenantPolicies(config.TenantsFile)
+	if err != nil {
 		z.Sugar().Fatal(err)
 	}
-	repository := app.NewRepository(db)
-	walletService := app.NewWalletService(repository)
+	repository := app.NewRepository(db).
+		WithBalanceSlots(config.BalanceSlots).
+		WithAtomicUpdates(config.AtomicUpdates)
+	walletService := app.NewWalletService(repository, app.WalletServiceOptions{
+		DefaultCurrency: config.DefaultCurrency,
+		HoldTTL:         config.HoldTTL,
+		ReadTimeout:     config.ReadTimeout,
+		WriteTimeout:    config.WriteTimeout,
+		Tenants:         tenants,
+		BatchWindow:     config.BatchWindow,
+		BatchSize:       config.BatchSize,
+	})
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	sweeper := make(chan struct{})
+	if config.HoldSweepInterval > 0 {
+		go func() {
+			defer close(sweeper)
+			runHoldExpiry(ctx, z, walletService, config.HoldSweepInterval)
+		}()
+	} else {
+		z.Warn("Hold sweeper disabled; expired holds keep their funds until captured or voided")
+		close(sweeper)
+	}
 	h := handlers.NewWalletHandler(walletService)
-	openapi.RegisterHandlersWithBaseURL(e, h, "/api/v1")
+
+	api := e.Group("/api/v1")
+	if config.AuthDisabled {
+		if config.Release {
+			z.Fatal("Authentication cannot be disabled in a release build")
+		}
+		z.Warn("Authentication is disabled")
+	} else {
+		z.Info("Loading JWKS")
+		authMiddleware, err := NewAuthMiddleware(context.Background(), config)
+		if err != nil {
+			z.Sugar().Fatal(err)
+		}
+		api.Use(authMiddleware)
+	}
+	if config.TenantHeader != "" {
+		api.Use(middleware.Tenant(config.TenantHeader))
+	}
+	api.Use(middleware.RateLimit(middleware.RateLimitOptions{
+		ClientRPS:   config.RateLimitClientRPS,
+		ClientBurst: config.RateLimitClientBurst,
+		WalletRPS:   config.RateLimitWalletRPS,
+		WalletBurst: config.RateLimitWalletBurst,
+		WalletRoutes: map[string]string{
+			"/api/v1/wallet":    "walletId",
+			"/api/v1/transfers": "fromWalletId",
+		},
+	}))
+	openapi.RegisterHandlersWithBaseURL(api, h, "")
 
 	z.Info("Setup middleware")
 	e.Use(echozap.Middleware(z))
+	e.Use(middleware.Tracing(ServiceName))
+	e.Use(middleware.Metrics(prometheus.DefaultRegisterer))
+	e.GET("/metrics", echo.WrapHandler(promhttp.Handler()))
+	health := handlers.NewHealthHandler(
+		handlers.HealthCheck{Name: "database", Check: PingDatabase(db)},
+		handlers.HealthCheck{Name: "migrations", Check: MigrationsApplied(migrator)},
+	)
+	health.Register(e)
 
-	z.Info("Starting server")
-	e.Logger.Fatal(e.Start(":" + config.Port))
+	go func() {
+		z.Info("Starting server")
+		if err := e.Start(":" + config.Port); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			z.Sugar().Fatal(err)
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+	Shutdown(z, e, health, sweeper, config,
+		Closer{Name: "database", Close: cleanup},
+		Closer{Name: "tracing", Close: shutdownTracing},
+	)
+}
+
+// Closer is a resource released by Shutdown once requests have drained.
+type Closer struct {
+	Name  string
+	Close func() error
+}
+
+// Shutdown fails readiness, keeps serving for ShutdownDelay so load balancers
+// stop routing here, then stops accepting connections and waits up to
+// ShutdownTimeout for in-flight requests, balance changes included, and the
+// hold sweeper to finish. Then it closes closers in order.
+func Shutdown(z *zap.Logger, e *echo.Echo, health *handlers.HealthHandler, sweeper <-chan struct{}, cfg *config.Config, closers ...Closer) {
+	z.Info("Shutting down", zap.Duration("Delay", cfg.ShutdownDelay), zap.Duration("Timeout", cfg.ShutdownTimeout))
+	health.SetDraining()
+	time.Sleep(cfg.ShutdownDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := e.Shutdown(ctx); err != nil {
+		z.Error("Waiting for in-flight requests", zap.Error(err))
+	}
+	select {
+	case <-sweeper:
+	case <-ctx.Done():
+		z.Error("Waiting for hold sweeper", zap.Error(ctx.Err()))
+	}
+	z.Info("Server stopped")
+	for _, c := range closers {
+		z.Info("Closing " + c.Name)
+		if err := c.Close(); err != nil {
+			z.Error("Closing "+c.Name, zap.Error(err))
+		}
+	}
 }
 
 func NewDatabaseConnection(dsn string) (*gorm.DB, func() error, error) {
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	db, err := database.Open(dsn)
 	if err != nil {
 		return nil, nil, err
 	}
+	if err := db.Use(tracing.NewPlugin(tracing.WithoutMetrics())); err != nil {
+		return nil, nil, err
+	}
 	sqlDB, err := db.DB()
 	if err != nil {
 		return nil, nil, err
 	}
+	if err := prometheus.Register(collectors.NewDBStatsCollector(sqlDB, "wallet")); err != nil {
+		return nil, nil, err
+	}
 	cleanup := func() error { return sqlDB.Close() }
 	return db, cleanup, nil
 }
+
+func NewAuthMiddleware(ctx context.Context, cfg *config.Config) (echo.MiddlewareFunc, error) {
+	opts := middleware.AuthOptions{
+		JWKSURL:  cfg.AuthJWKSURL,
+		JWKSFile: cfg.AuthJWKSFile,
+		Issuer:   cfg.AuthIssuer,
+		Audience: cfg.AuthAudience,
+	}
+	keys, err := middleware.NewKeyfunc(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return middleware.Auth(keys.Keyfunc, opts)
+}
+
+// PingDatabase checks that a pooled connection to the database works.
+func PingDatabase(db *gorm.DB) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		sqlDB, err := db.DB()
+		if err != nil {
+			return err
+		}
+		return sqlDB.PingContext(ctx)
+	}
+}
+
+// MigrationsApplied checks that no migration is pending.
+func MigrationsApplied(migrator *migrations.Migrator) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		pending, err := migrator.Pending(ctx)
+		if err != nil {
+			return err
+		}
+		if len(pending) > 0 {
+			return fmt.Errorf("%d migrations pending, next is %d_%s", len(pending), pending[0].Version, pending[0].Name)
+		}
+		return nil
+	}
+}
+
+// Migrate runs "migrate apply", "migrate rollback [steps]" or
+// "migrate status" and returns the process exit code.
+func Migrate(cfg *config.Config, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: app migrate apply | rollback [steps] | status")
+		return 2
+	}
+	db, cleanup, err := NewDatabaseConnection(cfg.Dsn)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer cleanup()
+	migrator, err := migrations.New(db)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	ctx := context.Background()
+	switch args[0] {
+	case "apply":
+		applied, err := migrator.Up(ctx)
+		for _, m := range applied {
+			fmt.Printf("applied %d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	case "rollback":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				fmt.Fprintln(os.Stderr, "rollback steps must be a positive integer")
+				return 2
+			}
+		}
+		rolledBack, err := migrator.Down(ctx, steps)
+		for _, m := range rolledBack {
+			fmt.Printf("rolled back %d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, s := range statuses {
+			appliedAt := "pending"
+			if s.AppliedAt != nil {
+				appliedAt = s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, s.Name, appliedAt)
+		}
+		w.Flush()
+	default:
+		fmt.Fprintf(os.Stderr, "unknown migrate command %q\n", args[0])
+		return 2
+	}
+	return 0
+}
+
+// Healthcheck probes /readyz of the server on port and returns the process
+// exit code, for the container HEALTHCHECK.
+func Healthcheck(port string) int {
+	client := http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get("http://127.0.0.1:" + port + "/readyz")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintln(os.Stderr, "readyz:", resp.Status)
+		return 1
+	}
+	return 0
+}
+
+// NewTracerProvider installs the global tracer provider and W3C trace context
+// propagator. The returned function flushes pending spans.
+func NewTracerProvider(ctx context.Context, cfg *config.Config) (func() error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch cfg.TracingExporter {
+	case config.TracingExporterNone, "":
+		return func() error { return nil }, nil
+	case config.TracingExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	case config.TracingExporterOTLP:
+		var opts []otlptracehttp.Option
+		if cfg.TracingEndpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpointURL(cfg.TracingEndpoint))
+		}
+		exporter, err = otlptracehttp.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q", cfg.TracingExporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(ServiceName),
+	))
+	if err != nil {
+		return nil, err
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(tp)
+	return func() error { return tp.Shutdown(context.Background()) }, nil
+}
+
+// NewTenantPolicies loads the tenants file. An empty path allows every
+// tenant.
+func NewTenantPolicies(path string) (map[string]app.TenantPolicy, error) {
+	if path == "" {
+		return nil, nil
+	}
+	tenants, err := config.LoadTenants(path)
+	if err != nil {
+		return nil, err
+	}
+	policies := make(map[string]app.TenantPolicy, len(tenants))
+	for _, t := range tenants {
+		if t.ID == "" {
+			return nil, fmt.Errorf("tenants file %s: tenant without id", path)
+		}
+		var policy app.TenantPolicy
+		for _, code := range t.Currencies {
+			c, ok := models.LookupCurrency(code)
+			if !ok {
+				return nil, fmt.Errorf("tenant %s: %w: %s", t.ID, app.ErrUnknownCurrency, code)
+			}
+			policy.Currencies = append(policy.Currencies, c.Code)
+		}
+		if t.MaxOperationAmount != "" {
+			limit, err := models.ParseMoney(t.MaxOperationAmount)
+			if err != nil {
+				return nil, fmt.Errorf("tenant %s: %w", t.ID, err)
+			}
+			policy.MaxOperationAmount = &limit
+		}
+		policies[t.ID] = policy
+	}
+	return policies, nil
+}
+
+// runHoldExpiry periodically releases holds that expired without being
+// captured or voided. It returns once ctx is done; a sweep in progress is
+// allowed to finish.
+func runHoldExpiry(ctx context.Context, z *zap.Logger, walletService *app.WalletService, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		released, err := walletService.ExpireHolds(context.WithoutCancel(ctx))
+		if err != nil {
+			z.Error("Expiring holds", zap.Error(err))
+			continue
+		}
+		if released > 0 {
+			z.Info("Expired holds", zap.Int("Released", released))
+		}
+	}
+}
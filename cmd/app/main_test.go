@@ -0,0 +1,100 @@
+//go:build unit
+
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ichigo7diabol/go-test-wallet/api/handlers"
+	"github.com/ichigo7diabol/go-test-wallet/internal/config"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestShutdown_DrainsBeforeClosing(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	e := echo.New()
+	e.HideBanner, e.HidePort = true, true
+	e.GET("/slow", func(c echo.Context) error {
+		close(started)
+		<-release
+		return c.String(http.StatusOK, "done")
+	})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	e.Listener = listener
+	addr := listener.Addr().String()
+	go e.Start("")
+
+	type response struct {
+		body string
+		err  error
+	}
+	responses := make(chan response, 1)
+	go func() {
+		resp, err := http.Get("http://" + addr + "/slow")
+		if err != nil {
+			responses <- response{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		responses <- response{body: string(body), err: err}
+	}()
+	<-started
+
+	var mu sync.Mutex
+	var closed []string
+	closer := func(name string) Closer {
+		return Closer{Name: name, Close: func() error {
+			mu.Lock()
+			defer mu.Unlock()
+			closed = append(closed, name)
+			return nil
+		}}
+	}
+	closedNames := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), closed...)
+	}
+	sweeper := make(chan struct{})
+	close(sweeper)
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		Shutdown(zap.NewNop(), e, handlers.NewHealthHandler(), sweeper,
+			&config.Config{ShutdownTimeout: 5 * time.Second},
+			closer("database"), closer("tracing"))
+	}()
+
+	// New connections are refused while the slow request is still in flight
+	// and nothing is closed under it.
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+		}
+		return err != nil
+	}, time.Second, 10*time.Millisecond)
+	assert.Empty(t, closedNames())
+
+	close(release)
+	r := <-responses
+	require.NoError(t, r.err)
+	assert.Equal(t, "done", r.body)
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown did not return after the request completed")
+	}
+	assert.Equal(t, []string{"database", "tracing"}, closedNames())
+}
@@ -0,0 +1,262 @@
+package apptest
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/ichigo7diabol/go-test-wallet/internal/app"
+	"github.com/ichigo7diabol/go-test-wallet/internal/models"
+	"github.com/stretchr/testify/require"
+)
+
+// ConcurrencyOptions sizes RunConcurrencyConformance.
+type ConcurrencyOptions struct {
+	// Operations is the number of calls each test makes; 2000 when zero.
+	Operations int
+	// Workers is how many of them are in flight at once; 64 when zero.
+	Workers int
+	// UnorderedDeposits is set for repositories that let deposits to one
+	// wallet commit concurrently, such as ones with balance slots. Their
+	// ledger balances do not form a chain, so lost deposits are only caught
+	// by the final balance.
+	UnorderedDeposits bool
+}
+
+// RunConcurrencyConformance hammers repositories made by newRepo with
+// concurrent balance changes and checks that money is conserved, that no
+// balance goes negative and that no update is lost.
+func RunConcurrencyConformance(t *testing.T, newRepo NewRepository, opts ConcurrencyOptions) {
+	if opts.Operations == 0 {
+		opts.Operations = 2000
+	}
+	if opts.Workers == 0 {
+		opts.Workers = 64
+	}
+	// Calls only interleave mid-operation with several threads running Go
+	// code, which a CI machine with one or two CPUs would not give.
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(max(runtime.GOMAXPROCS(0), minProcs)))
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, repo app.WalletRepositoryService, opts ConcurrencyOptions)
+	}{
+		{"DepositsNotLost", testConcurrentDeposits},
+		{"WithdrawalsNeverOverdraw", testConcurrentWithdrawals},
+		{"MixedConservesMoney", testConcurrentMixed},
+		{"TransfersConserveMoney", testConcurrentTransfers},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.fn(t, newRepo(t), opts)
+		})
+	}
+}
+
+// hammer calls op(i) for i in [0, n) from workers goroutines and returns the
+// errors op returned, indexed by i.
+func hammer(n int, workers int, op func(i int) error) []error {
+	errs := make([]error, n)
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				errs[i] = op(i)
+			}
+		}()
+	}
+	for i := range n {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return errs
+}
+
+// minProcs is the least GOMAXPROCS the suite runs with.
+const minProcs = 8
+
+var cent = models.MustParseMoney("0.01")
+
+// cents returns n hundredths.
+func cents(n int) models.Money {
+	return models.MustParseMoney(fmt.Sprintf("%d.%02d", n/100, n%100))
+}
+
+// add returns x + y, failing t if the sum is not exact.
+func add(t *testing.T, x, y models.Money) models.Money {
+	t.Helper()
+	sum, err := x.Add(y)
+	require.NoError(t, err)
+	return sum
+}
+
+// sub returns x - y, failing t if the difference is not exact.
+func sub(t *testing.T, x, y models.Money) models.Money {
+	t.Helper()
+	diff, err := x.Sub(y)
+	require.NoError(t, err)
+	return diff
+}
+
+func testConcurrentDeposits(t *testing.T, repo app.WalletRepositoryService, opts ConcurrencyOptions) {
+	n := opts.Operations
+	w, err := repo.Create(models.MoneyFromInt(0), "USD", "user-1")
+	require.NoError(t, err)
+
+	errs := hammer(n, opts.Workers, func(int) error {
+		_, _, _, err := repo.Deposit(w.ID, cent, "USD", nil)
+		return err
+	})
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+
+	got, err := repo.GetByID(w.ID)
+	require.NoError(t, err)
+	require.Equal(t, cents(n), got.Balance)
+	if opts.UnorderedDeposits {
+		return
+	}
+
+	// A lost update shows up as two deposits that started from the same
+	// balance.
+	entries, err := repo.ListTransactions(w.ID, app.TransactionFilter{
+		OperationTypes: []app.WalletOperation{app.DepositOperation},
+	})
+	require.NoError(t, err)
+	require.Equal(t, n, len(entries))
+	seen := make(map[string]bool, n)
+	for _, e := range entries {
+		before := e.BalanceBefore.String()
+		require.False(t, seen[before], "two deposits started from balance %s", before)
+		seen[before] = true
+		require.Equal(t, add(t, e.BalanceBefore, cent), e.BalanceAfter)
+	}
+}
+
+func testConcurrentWithdrawals(t *testing.T, repo app.WalletRepositoryService, opts ConcurrencyOptions) {
+	n := opts.Operations
+	funded := n / 2
+	w, err := repo.Create(cents(funded), "USD", "user-1")
+	require.NoError(t, err)
+
+	errs := hammer(n, opts.Workers, func(int) error {
+		_, newBalance, _, err := repo.Withdraw(w.ID, cent, "USD", nil)
+		if err == nil && newBalance.IsNegative() {
+			return fmt.Errorf("balance went negative: %s", newBalance)
+		}
+		return err
+	})
+	succeeded := 0
+	for _, err := range errs {
+		if errors.Is(err, app.ErrInsufficientFunds) {
+			continue
+		}
+		require.NoError(t, err)
+		succeeded++
+	}
+	require.Equal(t, funded, succeeded)
+
+	got, err := repo.GetByID(w.ID)
+	require.NoError(t, err)
+	require.True(t, got.Balance.IsZero(), "balance is %s", got.Balance)
+}
+
+func testConcurrentMixed(t *testing.T, repo app.WalletRepositoryService, opts ConcurrencyOptions) {
+	n := opts.Operations
+	initial := cents(500)
+	w, err := repo.Create(initial, "USD", "user-1")
+	require.NoError(t, err)
+
+	amounts := make([]models.Money, n)
+	for i := range amounts {
+		amounts[i] = cents(1 + rand.IntN(100))
+	}
+	deposit := func(i int) bool { return i%2 == 0 }
+
+	errs := hammer(n, opts.Workers, func(i int) error {
+		var err error
+		if deposit(i) {
+			_, _, _, err = repo.Deposit(w.ID, amounts[i], "USD", nil)
+		} else {
+			_, _, _, err = repo.Withdraw(w.ID, amounts[i], "USD", nil)
+		}
+		return err
+	})
+	want := initial
+	changes := 0
+	for i, err := range errs {
+		if !deposit(i) && errors.Is(err, app.ErrInsufficientFunds) {
+			continue
+		}
+		require.NoError(t, err)
+		changes++
+		if deposit(i) {
+			want = add(t, want, amounts[i])
+		} else {
+			want = sub(t, want, amounts[i])
+		}
+	}
+
+	got, err := repo.GetByID(w.ID)
+	require.NoError(t, err)
+	require.Equal(t, want, got.Balance)
+
+	// Every successful change has exactly one consistent ledger entry.
+	entries, err := repo.ListTransactions(w.ID, app.TransactionFilter{
+		OperationTypes: []app.WalletOperation{app.DepositOperation, app.WithdrawOperation},
+	})
+	require.NoError(t, err)
+	require.Equal(t, changes, len(entries))
+	for _, e := range entries {
+		require.False(t, e.BalanceAfter.IsNegative(), "balance went negative: %s", e.BalanceAfter)
+		if e.OperationType == string(app.DepositOperation) {
+			require.Equal(t, add(t, e.BalanceBefore, e.Amount), e.BalanceAfter)
+		} else {
+			require.Equal(t, sub(t, e.BalanceBefore, e.Amount), e.BalanceAfter)
+		}
+	}
+}
+
+func testConcurrentTransfers(t *testing.T, repo app.WalletRepositoryService, opts ConcurrencyOptions) {
+	const wallets = 4
+	ids := make([]uuid.UUID, wallets)
+	total := models.MoneyFromInt(0)
+	for i := range ids {
+		w, err := repo.Create(cents(1000), "USD", "user-1")
+		require.NoError(t, err)
+		ids[i] = w.ID
+		total = add(t, total, w.Balance)
+	}
+
+	// Transfers run in both directions between every pair, which deadlocks
+	// unless wallets are locked in a consistent order.
+	errs := hammer(opts.Operations, opts.Workers, func(i int) error {
+		from := i % wallets
+		to := (from + 1 + (i/wallets)%(wallets-1)) % wallets
+		_, err := repo.Transfer(ids[from], ids[to], cents(1+rand.IntN(50)), "USD", nil)
+		return err
+	})
+	for _, err := range errs {
+		if errors.Is(err, app.ErrInsufficientFunds) {
+			continue
+		}
+		require.NoError(t, err)
+	}
+
+	sum := models.MoneyFromInt(0)
+	for _, id := range ids {
+		got, err := repo.GetByID(id)
+		require.NoError(t, err)
+		require.False(t, got.Balance.IsNegative(), "balance went negative: %s", got.Balance)
+		sum = add(t, sum, got.Balance)
+	}
+	require.Equal(t, total, sum)
+}
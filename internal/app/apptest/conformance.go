@@ -0,0 +1,901 @@
+// Package apptest holds the conformance suite that every
+// app.WalletRepositoryService implementation must pass.
+package apptest
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ichigo7diabol/go-test-wallet/internal/app"
+	"github.com/ichigo7diabol/go-test-wallet/internal/models"
+	"github.com/stretchr/testify/require"
+)
+
+// NewRepository returns an empty repository in the default tenant. It is
+// called once per test.
+type NewRepository func(t *testing.T) app.WalletRepositoryService
+
+// RunRepositoryConformance runs every conformance test against repositories
+// made by newRepo.
+func RunRepositoryConformance(t *testing.T, newRepo NewRepository) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, repo app.WalletRepositoryService)
+	}{
+		{"CreateAndGet", testCreateAndGet},
+		{"DepositExactDecimal", testDepositExactDecimal},
+		{"CreateInvalidAmount", testCreateInvalidAmount},
+		{"UpdateBalance", testUpdateBalance},
+		{"UpdateBalanceInvalid", testUpdateBalanceInvalid},
+		{"UpdateBalanceNotFound", testUpdateBalanceNotFound},
+		{"UpdateBalanceBelowHeld", testUpdateBalanceBelowHeld},
+		{"Deposit", testDeposit},
+		{"DepositInvalidAmount", testDepositInvalidAmount},
+		{"DepositOutOfRange", testDepositOutOfRange},
+		{"Withdraw", testWithdraw},
+		{"WithdrawInsufficientFunds", testWithdrawInsufficientFunds},
+		{"WithdrawCurrencyMismatch", testWithdrawCurrencyMismatch},
+		{"WithdrawInvalidAmount", testWithdrawInvalidAmount},
+		{"BalanceChangeNotFound", testBalanceChangeNotFound},
+		{"Ledger", testLedger},
+		{"ListTransactions", testListTransactions},
+		{"ListTransactionsBoundaries", testListTransactionsBoundaries},
+		{"Transfer", testTransfer},
+		{"TransferInsufficientFunds", testTransferInsufficientFunds},
+		{"TransferInvalid", testTransferInvalid},
+		{"Idempotent", testIdempotent},
+		{"IdempotentFailureNotStored", testIdempotentFailureNotStored},
+		{"Authorize", testAuthorize},
+		{"Capture", testCapture},
+		{"CaptureInvalid", testCaptureInvalid},
+		{"Void", testVoid},
+		{"ExpireHolds", testExpireHolds},
+		{"WithdrawReleasesExpiredHolds", testWithdrawReleasesExpiredHolds},
+		{"Delete", testDelete},
+		{"DeleteNotFound", testDeleteNotFound},
+		{"List", testList},
+		{"ListByOwner", testListByOwner},
+		{"TenantIsolation", testTenantIsolation},
+		{"IdempotentRollsBack", testIdempotentRollsBack},
+		{"IdempotentPerTenant", testIdempotentPerTenant},
+		{"Transaction", testTransaction},
+		{"TransactionRollsBack", testTransactionRollsBack},
+		{"Versions", testVersions},
+		{"ExpectVersion", testExpectVersion},
+		{"ExpectVersionOtherWallets", testExpectVersionOtherWallets},
+		{"HoldVersions", testHoldVersions},
+		{"ExpectOwner", testExpectOwner},
+		{"ExpireHoldsAllTenants", testExpireHoldsAllTenants},
+		{"WithContextDeadline", testWithContextDeadline},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.fn(t, newRepo(t))
+		})
+	}
+}
+
+func testCreateAndGet(t *testing.T, repo app.WalletRepositoryService) {
+	w, err := repo.Create(models.MoneyFromInt(100), "USD", "user-1")
+	require.NoError(t, err)
+	require.NotNil(t, w)
+	require.Equal(t, models.MoneyFromInt(100), w.Balance)
+
+	got, err := repo.GetByID(w.ID)
+	require.NoError(t, err)
+	require.Equal(t, w.ID, got.ID)
+	require.Equal(t, w.Balance, got.Balance)
+}
+
+func testDepositExactDecimal(t *testing.T, repo app.WalletRepositoryService) {
+	w, _ := repo.Create(models.MoneyFromInt(0), "USD", "user-1")
+	for i := 0; i < 10; i++ {
+		_, _, _, err := repo.Deposit(w.ID, models.MustParseMoney("0.1"), "USD", nil)
+		require.NoError(t, err)
+	}
+
+	got, err := repo.GetByID(w.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.MoneyFromInt(1), got.Balance)
+}
+
+func testCreateInvalidAmount(t *testing.T, repo app.WalletRepositoryService) {
+	w, err := repo.Create(models.MoneyFromInt(-10), "USD", "user-1")
+	require.ErrorIs(t, err, app.ErrInvalidAmount)
+	require.Nil(t, w)
+}
+
+func testUpdateBalance(t *testing.T, repo app.WalletRepositoryService) {
+	w, _ := repo.Create(models.MoneyFromInt(50), "USD", "user-1")
+	old, newBal, updated, err := repo.UpdateBalance(w.ID, models.MoneyFromInt(200))
+	require.NoError(t, err)
+	require.Equal(t, models.MoneyFromInt(50), old)
+	require.Equal(t, models.MoneyFromInt(200), newBal)
+	require.Equal(t, models.MoneyFromInt(200), updated.Balance)
+}
+
+func testUpdateBalanceInvalid(t *testing.T, repo app.WalletRepositoryService) {
+	w, _ := repo.Create(models.MoneyFromInt(50), "USD", "user-1")
+	_, _, _, err := repo.UpdateBalance(w.ID, models.MoneyFromInt(-5))
+	require.ErrorIs(t, err, app.ErrInvalidAmount)
+}
+
+func testUpdateBalanceNotFound(t *testing.T, repo app.WalletRepositoryService) {
+	id := uuid.New()
+	_, _, _, err := repo.UpdateBalance(id, models.MoneyFromInt(100))
+	require.ErrorIs(t, err, app.ErrWalletNotFound)
+}
+
+func testUpdateBalanceBelowHeld(t *testing.T, repo app.WalletRepositoryService) {
+	w, _ := repo.Create(models.MoneyFromInt(100), "USD", "user-1")
+	_, err := repo.Authorize(w.ID, models.MoneyFromInt(30), "USD", time.Now().Add(time.Hour), nil)
+	require.NoError(t, err)
+	expiring, err := repo.Authorize(w.ID, models.MoneyFromInt(50), "USD", time.Now().Add(50*time.Millisecond), nil)
+	require.NoError(t, err)
+
+	_, _, _, err = repo.UpdateBalance(w.ID, models.MoneyFromInt(70))
+	require.ErrorIs(t, err, app.ErrBalanceBelowHeld)
+	got, err := repo.GetByID(w.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.MoneyFromInt(100), got.Balance)
+
+	// Expired holds no longer need covering.
+	time.Sleep(100 * time.Millisecond)
+	_, _, _, err = repo.UpdateBalance(w.ID, models.MoneyFromInt(29))
+	require.ErrorIs(t, err, app.ErrBalanceBelowHeld)
+	_, newBalance, updated, err := repo.UpdateBalance(w.ID, models.MoneyFromInt(30))
+	require.NoError(t, err)
+	require.Equal(t, models.MoneyFromInt(30), newBalance)
+	require.Equal(t, models.MoneyFromInt(30), updated.Held)
+	h, err := repo.GetHold(expiring.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.HoldExpired, h.Status)
+}
+
+func testDeposit(t *testing.T, repo app.WalletRepositoryService) {
+	w, _ := repo.Create(models.MoneyFromInt(100), "USD", "user-1")
+	old, newBal, updated, err := repo.Deposit(w.ID, models.MoneyFromInt(50), "USD", nil)
+	require.NoError(t, err)
+	require.Equal(t, models.MoneyFromInt(100), old)
+	require.Equal(t, models.MoneyFromInt(150), newBal)
+	require.Equal(t, models.MoneyFromInt(150), updated.Balance)
+}
+
+func testDepositInvalidAmount(t *testing.T, repo app.WalletRepositoryService) {
+	w, _ := repo.Create(models.MoneyFromInt(100), "USD", "user-1")
+	_, _, _, err := repo.Deposit(w.ID, models.MoneyFromInt(-5), "USD", nil)
+	require.ErrorIs(t, err, app.ErrInvalidAmount)
+}
+
+func testDepositOutOfRange(t *testing.T, repo app.WalletRepositoryService) {
+	largest := models.MustParseMoney("9999999999999999999999999999999999")
+	w, err := repo.Create(largest, "USD", "user-1")
+	require.NoError(t, err)
+
+	// The sum needs 36 significant digits; rather than round it away, the
+	// deposit fails and leaves no ledger entry.
+	_, _, _, err = repo.Deposit(w.ID, models.MustParseMoney("0.01"), "USD", nil)
+	require.ErrorIs(t, err, models.ErrMoneyOutOfRange)
+	got, err := repo.GetByID(w.ID)
+	require.NoError(t, err)
+	require.Equal(t, largest, got.Balance)
+	entries, err := repo.ListTransactions(w.ID, app.TransactionFilter{})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+}
+
+func testWithdraw(t *testing.T, repo app.WalletRepositoryService) {
+	w, _ := repo.Create(models.MoneyFromInt(100), "USD", "user-1")
+	old, newBal, updated, err := repo.Withdraw(w.ID, models.MoneyFromInt(60), "USD", nil)
+	require.NoError(t, err)
+	require.Equal(t, models.MoneyFromInt(100), old)
+	require.Equal(t, models.MoneyFromInt(40), newBal)
+	require.Equal(t, models.MoneyFromInt(40), updated.Balance)
+}
+
+func testWithdrawInsufficientFunds(t *testing.T, repo app.WalletRepositoryService) {
+	w, _ := repo.Create(models.MoneyFromInt(30), "USD", "user-1")
+	_, _, _, err := repo.Withdraw(w.ID, models.MoneyFromInt(50), "USD", nil)
+	require.ErrorIs(t, err, app.ErrInsufficientFunds)
+}
+
+func testWithdrawCurrencyMismatch(t *testing.T, repo app.WalletRepositoryService) {
+	w, _ := repo.Create(models.MoneyFromInt(30), "USD", "user-1")
+	_, _, _, err := repo.Withdraw(w.ID, models.MoneyFromInt(10), "EUR", nil)
+	require.ErrorIs(t, err, app.ErrCurrencyMismatch)
+
+	got, err := repo.GetByID(w.ID)
+	require.NoError(t, err)
+	require.Equal(t, "USD", got.Currency)
+	require.Equal(t, models.MoneyFromInt(30), got.Balance)
+}
+
+func testBalanceChangeNotFound(t *testing.T, repo app.WalletRepositoryService) {
+	id := uuid.New()
+	_, _, _, err := repo.Deposit(id, models.MoneyFromInt(10), "USD", nil)
+	require.ErrorIs(t, err, app.ErrWalletNotFound)
+	_, _, _, err = repo.Withdraw(id, models.MoneyFromInt(10), "USD", nil)
+	require.ErrorIs(t, err, app.ErrWalletNotFound)
+}
+
+func testWithdrawInvalidAmount(t *testing.T, repo app.WalletRepositoryService) {
+	w, _ := repo.Create(models.MoneyFromInt(30), "USD", "user-1")
+	_, _, _, err := repo.Withdraw(w.ID, models.MoneyFromInt(-10), "USD", nil)
+	require.ErrorIs(t, err, app.ErrInvalidAmount)
+}
+
+func testLedger(t *testing.T, repo app.WalletRepositoryService) {
+	w, _ := repo.Create(models.MoneyFromInt(100), "USD", "user-1")
+	_, _, _, err := repo.Deposit(w.ID, models.MustParseMoney("25.50"), "USD", map[string]string{"orderId": "A-1"})
+	require.NoError(t, err)
+	_, _, _, err = repo.Withdraw(w.ID, models.MoneyFromInt(40), "USD", nil)
+	require.NoError(t, err)
+	_, _, _, err = repo.Withdraw(w.ID, models.MoneyFromInt(1000), "USD", nil)
+	require.ErrorIs(t, err, app.ErrInsufficientFunds)
+
+	entries, err := repo.ListTransactions(w.ID, app.TransactionFilter{})
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	slices.Reverse(entries)
+
+	require.Equal(t, string(app.OpenOperation), entries[0].OperationType)
+	require.Equal(t, models.MoneyFromInt(100), entries[0].BalanceAfter)
+
+	require.Equal(t, string(app.DepositOperation), entries[1].OperationType)
+	require.Equal(t, models.MustParseMoney("25.5"), entries[1].Amount)
+	require.Equal(t, models.MoneyFromInt(100), entries[1].BalanceBefore)
+	require.Equal(t, models.MustParseMoney("125.5"), entries[1].BalanceAfter)
+	require.Equal(t, map[string]string{"orderId": "A-1"}, entries[1].Metadata)
+
+	require.Equal(t, string(app.WithdrawOperation), entries[2].OperationType)
+	require.Equal(t, models.MustParseMoney("85.5"), entries[2].BalanceAfter)
+	require.Equal(t, "USD", entries[2].Currency)
+}
+
+func testListTransactions(t *testing.T, repo app.WalletRepositoryService) {
+	w, _ := repo.Create(models.MoneyFromInt(0), "USD", "user-1")
+	for _, amount := range []int64{5, 20, 100} {
+		_, _, _, err := repo.Deposit(w.ID, models.MoneyFromInt(amount), "USD", nil)
+		require.NoError(t, err)
+		time.Sleep(5 * time.Millisecond)
+	}
+	_, _, _, err := repo.Withdraw(w.ID, models.MoneyFromInt(30), "USD", nil)
+	require.NoError(t, err)
+
+	all, err := repo.ListTransactions(w.ID, app.TransactionFilter{})
+	require.NoError(t, err)
+	require.Len(t, all, 5)
+	require.Equal(t, string(app.WithdrawOperation), all[0].OperationType)
+	require.Equal(t, string(app.OpenOperation), all[4].OperationType)
+
+	deposits, err := repo.ListTransactions(w.ID, app.TransactionFilter{
+		OperationTypes: []app.WalletOperation{app.DepositOperation},
+	})
+	require.NoError(t, err)
+	require.Len(t, deposits, 3)
+
+	minAmount, maxAmount := models.MoneyFromInt(10), models.MoneyFromInt(50)
+	ranged, err := repo.ListTransactions(w.ID, app.TransactionFilter{MinAmount: &minAmount, MaxAmount: &maxAmount})
+	require.NoError(t, err)
+	require.Len(t, ranged, 2)
+	require.Equal(t, models.MoneyFromInt(30), ranged[0].Amount)
+	require.Equal(t, models.MoneyFromInt(20), ranged[1].Amount)
+
+	cursor := app.NewTransactionCursor(&all[1])
+	rest, err := repo.ListTransactions(w.ID, app.TransactionFilter{After: &cursor, Limit: 2})
+	require.NoError(t, err)
+	require.Len(t, rest, 2)
+	require.Equal(t, all[2].ID, rest[0].ID)
+	require.Equal(t, all[3].ID, rest[1].ID)
+}
+
+func testListTransactionsBoundaries(t *testing.T, repo app.WalletRepositoryService) {
+	w, _ := repo.Create(models.MoneyFromInt(0), "USD", "user-1")
+	// The two large amounts are the same float64.
+	low, high := models.MustParseMoney("12345678901234567.01"), models.MustParseMoney("12345678901234567.02")
+	for _, amount := range []models.Money{low, high, models.MustParseMoney("0.1")} {
+		_, _, _, err := repo.Deposit(w.ID, amount, "USD", nil)
+		require.NoError(t, err)
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	exact, err := repo.ListTransactions(w.ID, app.TransactionFilter{MinAmount: &low, MaxAmount: &low})
+	require.NoError(t, err)
+	require.Len(t, exact, 1)
+	require.Equal(t, low, exact[0].Amount)
+
+	above, err := repo.ListTransactions(w.ID, app.TransactionFilter{MinAmount: &high})
+	require.NoError(t, err)
+	require.Len(t, above, 1)
+	require.Equal(t, high, above[0].Amount)
+
+	// The limit counts matching entries only.
+	below, err := repo.ListTransactions(w.ID, app.TransactionFilter{MaxAmount: &low, Limit: 2})
+	require.NoError(t, err)
+	require.Len(t, below, 2)
+	require.Equal(t, models.MustParseMoney("0.1"), below[0].Amount)
+	require.Equal(t, low, below[1].Amount)
+
+	// Time bounds in another zone name the same instants.
+	all, err := repo.ListTransactions(w.ID, app.TransactionFilter{})
+	require.NoError(t, err)
+	require.Len(t, all, 4)
+	at := all[1].CreatedAt.In(time.FixedZone("UTC+5", 5*60*60))
+	from, err := repo.ListTransactions(w.ID, app.TransactionFilter{From: &at})
+	require.NoError(t, err)
+	require.Len(t, from, 2)
+	require.Equal(t, all[1].ID, from[1].ID)
+	to, err := repo.ListTransactions(w.ID, app.TransactionFilter{To: &at})
+	require.NoError(t, err)
+	require.Len(t, to, 2)
+	require.Equal(t, all[2].ID, to[0].ID)
+}
+
+func testTransfer(t *testing.T, repo app.WalletRepositoryService) {
+	from, _ := repo.Create(models.MoneyFromInt(100), "USD", "user-1")
+	to, _ := repo.Create(models.MoneyFromInt(5), "USD", "user-1")
+
+	res, err := repo.Transfer(from.ID, to.ID, models.MustParseMoney("40.25"), "USD", map[string]string{"orderId": "A-1"})
+	require.NoError(t, err)
+	require.Equal(t, models.MoneyFromInt(100), res.FromOldBalance)
+	require.Equal(t, models.MustParseMoney("59.75"), res.FromNewBalance)
+	require.Equal(t, models.MoneyFromInt(5), res.ToOldBalance)
+	require.Equal(t, models.MustParseMoney("45.25"), res.ToNewBalance)
+
+	gotFrom, _ := repo.GetByID(from.ID)
+	gotTo, _ := repo.GetByID(to.ID)
+	require.Equal(t, models.MustParseMoney("59.75"), gotFrom.Balance)
+	require.Equal(t, models.MustParseMoney("45.25"), gotTo.Balance)
+
+	entries, err := repo.ListTransactions(to.ID, app.TransactionFilter{
+		OperationTypes: []app.WalletOperation{app.TransferOperation},
+	})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, res.ID.String(), entries[0].Metadata["transferId"])
+	require.Equal(t, from.ID.String(), entries[0].Metadata["counterpartyWalletId"])
+	require.Equal(t, "A-1", entries[0].Metadata["orderId"])
+}
+
+func testTransferInsufficientFunds(t *testing.T, repo app.WalletRepositoryService) {
+	from, _ := repo.Create(models.MoneyFromInt(10), "USD", "user-1")
+	to, _ := repo.Create(models.MoneyFromInt(0), "USD", "user-1")
+
+	_, err := repo.Transfer(from.ID, to.ID, models.MoneyFromInt(11), "USD", nil)
+	require.ErrorIs(t, err, app.ErrInsufficientFunds)
+
+	gotFrom, _ := repo.GetByID(from.ID)
+	gotTo, _ := repo.GetByID(to.ID)
+	require.Equal(t, models.MoneyFromInt(10), gotFrom.Balance)
+	require.Equal(t, models.MoneyFromInt(0), gotTo.Balance)
+}
+
+func testTransferInvalid(t *testing.T, repo app.WalletRepositoryService) {
+	usd, _ := repo.Create(models.MoneyFromInt(10), "USD", "user-1")
+	eur, _ := repo.Create(models.MoneyFromInt(10), "EUR", "user-1")
+
+	_, err := repo.Transfer(usd.ID, usd.ID, models.MoneyFromInt(1), "USD", nil)
+	require.ErrorIs(t, err, app.ErrSameWallet)
+
+	_, err = repo.Transfer(usd.ID, eur.ID, models.MoneyFromInt(1), "USD", nil)
+	require.ErrorIs(t, err, app.ErrCurrencyMismatch)
+
+	_, err = repo.Transfer(usd.ID, uuid.New(), models.MoneyFromInt(1), "USD", nil)
+	require.ErrorIs(t, err, app.ErrWalletNotFound)
+}
+
+func testIdempotent(t *testing.T, repo app.WalletRepositoryService) {
+	w, _ := repo.Create(models.MoneyFromInt(0), "USD", "user-1")
+
+	calls := 0
+	deposit := func(tx app.WalletRepositoryService) ([]byte, error) {
+		calls++
+		_, newBalance, _, err := tx.Deposit(w.ID, models.MoneyFromInt(10), "USD", nil)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(newBalance.String()), nil
+	}
+
+	first, err := repo.Idempotent("key-1", "fp-1", deposit)
+	require.NoError(t, err)
+	require.Equal(t, "10", string(first))
+
+	replay, err := repo.Idempotent("key-1", "fp-1", deposit)
+	require.NoError(t, err)
+	require.Equal(t, first, replay)
+	require.Equal(t, 1, calls)
+
+	_, err = repo.Idempotent("key-1", "fp-2", deposit)
+	require.ErrorIs(t, err, app.ErrIdempotencyKeyReused)
+
+	got, _ := repo.GetByID(w.ID)
+	require.Equal(t, models.MoneyFromInt(10), got.Balance)
+}
+
+func testIdempotentFailureNotStored(t *testing.T, repo app.WalletRepositoryService) {
+	w, _ := repo.Create(models.MoneyFromInt(5), "USD", "user-1")
+
+	withdraw := func(amount int64) func(app.WalletRepositoryService) ([]byte, error) {
+		return func(tx app.WalletRepositoryService) ([]byte, error) {
+			_, newBalance, _, err := tx.Withdraw(w.ID, models.MoneyFromInt(amount), "USD", nil)
+			if err != nil {
+				return nil, err
+			}
+			return []byte(newBalance.String()), nil
+		}
+	}
+
+	_, err := repo.Idempotent("key-1", "fp-1", withdraw(10))
+	require.ErrorIs(t, err, app.ErrInsufficientFunds)
+
+	resp, err := repo.Idempotent("key-1", "fp-1", withdraw(5))
+	require.NoError(t, err)
+	require.Equal(t, "0", string(resp))
+
+	entries, err := repo.ListTransactions(w.ID, app.TransactionFilter{})
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+}
+
+func testAuthorize(t *testing.T, repo app.WalletRepositoryService) {
+	w, _ := repo.Create(models.MoneyFromInt(100), "USD", "user-1")
+	hold, err := repo.Authorize(w.ID, models.MoneyFromInt(70), "USD", time.Now().Add(time.Hour), nil)
+	require.NoError(t, err)
+	require.Equal(t, models.HoldActive, hold.Status)
+
+	got, _ := repo.GetByID(w.ID)
+	require.Equal(t, models.MoneyFromInt(100), got.Balance)
+	require.Equal(t, models.MoneyFromInt(30), available(t, got))
+
+	_, _, _, err = repo.Withdraw(w.ID, models.MoneyFromInt(40), "USD", nil)
+	require.ErrorIs(t, err, app.ErrInsufficientFunds)
+	_, err = repo.Authorize(w.ID, models.MoneyFromInt(40), "USD", time.Now().Add(time.Hour), nil)
+	require.ErrorIs(t, err, app.ErrInsufficientFunds)
+}
+
+func testCapture(t *testing.T, repo app.WalletRepositoryService) {
+	w, _ := repo.Create(models.MoneyFromInt(100), "USD", "user-1")
+	hold, _ := repo.Authorize(w.ID, models.MoneyFromInt(70), "USD", time.Now().Add(time.Hour), nil)
+
+	partial := models.MoneyFromInt(50)
+	captured, old, newBal, err := repo.Capture(hold.ID, &partial)
+	require.NoError(t, err)
+	require.Equal(t, models.HoldCaptured, captured.Status)
+	require.Equal(t, partial, captured.CapturedAmount)
+	require.Equal(t, models.MoneyFromInt(100), old)
+	require.Equal(t, models.MoneyFromInt(50), newBal)
+
+	got, _ := repo.GetByID(w.ID)
+	require.True(t, got.Held.IsZero())
+	require.Equal(t, models.MoneyFromInt(50), available(t, got))
+
+	entries, err := repo.ListTransactions(w.ID, app.TransactionFilter{OperationTypes: []app.WalletOperation{app.CaptureOperation}})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, hold.ID.String(), entries[0].Metadata["holdId"])
+
+	_, _, _, err = repo.Capture(hold.ID, nil)
+	require.ErrorIs(t, err, app.ErrHoldNotActive)
+}
+
+func testCaptureInvalid(t *testing.T, repo app.WalletRepositoryService) {
+	w, _ := repo.Create(models.MoneyFromInt(100), "USD", "user-1")
+	hold, _ := repo.Authorize(w.ID, models.MoneyFromInt(10), "USD", time.Now().Add(time.Hour), nil)
+
+	tooMuch := models.MoneyFromInt(11)
+	_, _, _, err := repo.Capture(hold.ID, &tooMuch)
+	require.ErrorIs(t, err, app.ErrHoldAmountExceeded)
+
+	_, _, _, err = repo.Capture(uuid.New(), nil)
+	require.ErrorIs(t, err, app.ErrHoldNotFound)
+}
+
+func testVoid(t *testing.T, repo app.WalletRepositoryService) {
+	w, _ := repo.Create(models.MoneyFromInt(100), "USD", "user-1")
+	hold, _ := repo.Authorize(w.ID, models.MoneyFromInt(70), "USD", time.Now().Add(time.Hour), nil)
+
+	voided, err := repo.Void(hold.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.HoldVoided, voided.Status)
+
+	got, _ := repo.GetByID(w.ID)
+	require.Equal(t, models.MoneyFromInt(100), available(t, got))
+
+	_, err = repo.Void(hold.ID)
+	require.ErrorIs(t, err, app.ErrHoldNotActive)
+}
+
+func testExpireHolds(t *testing.T, repo app.WalletRepositoryService) {
+	w, _ := repo.Create(models.MoneyFromInt(100), "USD", "user-1")
+	expiring, _ := repo.Authorize(w.ID, models.MoneyFromInt(30), "USD", time.Now().Add(time.Minute), nil)
+	_, _ = repo.Authorize(w.ID, models.MoneyFromInt(20), "USD", time.Now().Add(time.Hour), nil)
+
+	released, err := repo.ExpireHolds(time.Now().Add(2 * time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, 1, released)
+
+	hold, _ := repo.GetHold(expiring.ID)
+	require.Equal(t, models.HoldExpired, hold.Status)
+	got, _ := repo.GetByID(w.ID)
+	require.Equal(t, models.MoneyFromInt(20), got.Held)
+}
+
+func testWithdrawReleasesExpiredHolds(t *testing.T, repo app.WalletRepositoryService) {
+	w, _ := repo.Create(models.MoneyFromInt(100), "USD", "user-1")
+	hold, _ := repo.Authorize(w.ID, models.MoneyFromInt(80), "USD", time.Now().Add(50*time.Millisecond), nil)
+	time.Sleep(100 * time.Millisecond)
+
+	_, newBal, _, err := repo.Withdraw(w.ID, models.MoneyFromInt(90), "USD", nil)
+	require.NoError(t, err)
+	require.Equal(t, models.MoneyFromInt(10), newBal)
+
+	got, _ := repo.GetHold(hold.ID)
+	require.Equal(t, models.HoldExpired, got.Status)
+
+	_, _, _, err = repo.Capture(hold.ID, nil)
+	require.ErrorIs(t, err, app.ErrHoldNotActive)
+}
+
+func testDelete(t *testing.T, repo app.WalletRepositoryService) {
+	w, _ := repo.Create(models.MoneyFromInt(70), "USD", "user-1")
+	err := repo.Delete(w.ID)
+	require.NoError(t, err)
+
+	_, err = repo.GetByID(w.ID)
+	require.ErrorIs(t, err, app.ErrWalletNotFound)
+}
+
+func testDeleteNotFound(t *testing.T, repo app.WalletRepositoryService) {
+	id := uuid.New()
+	err := repo.Delete(id)
+	require.ErrorIs(t, err, app.ErrWalletNotFound)
+}
+
+func testList(t *testing.T, repo app.WalletRepositoryService) {
+	for i := 0; i < 3; i++ {
+		_, err := repo.Create(models.MoneyFromInt(int64(10*i)), "USD", "user-1")
+		require.NoError(t, err)
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	list, err := repo.List()
+	require.NoError(t, err)
+	require.Len(t, list, 3)
+	require.GreaterOrEqual(t, list[1].CreatedAt.UnixNano(), list[0].CreatedAt.UnixNano())
+}
+
+func testListByOwner(t *testing.T, repo app.WalletRepositoryService) {
+	mine, _ := repo.Create(models.MoneyFromInt(10), "USD", "user-1")
+	_, _ = repo.Create(models.MoneyFromInt(20), "USD", "user-2")
+
+	list, err := repo.ListByOwner("user-1")
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	require.Equal(t, mine.ID, list[0].ID)
+	require.Equal(t, "user-1", list[0].OwnerID)
+}
+
+func testTenantIsolation(t *testing.T, repo app.WalletRepositoryService) {
+	acme := repo.ForTenant("acme")
+	globex := repo.ForTenant("globex")
+
+	w, err := acme.Create(models.MoneyFromInt(100), "USD", "user-1")
+	require.NoError(t, err)
+	require.Equal(t, "acme", w.TenantID)
+	other, _ := globex.Create(models.MoneyFromInt(100), "USD", "user-1")
+	hold, _ := acme.Authorize(w.ID, models.MoneyFromInt(10), "USD", time.Now().Add(time.Hour), nil)
+
+	_, err = globex.GetByID(w.ID)
+	require.ErrorIs(t, err, app.ErrWalletNotFound)
+	_, _, _, err = globex.Deposit(w.ID, models.MoneyFromInt(1), "USD", nil)
+	require.ErrorIs(t, err, app.ErrWalletNotFound)
+	_, err = globex.Transfer(other.ID, w.ID, models.MoneyFromInt(1), "USD", nil)
+	require.ErrorIs(t, err, app.ErrWalletNotFound)
+	require.ErrorIs(t, globex.Delete(w.ID), app.ErrWalletNotFound)
+	_, err = globex.GetHold(hold.ID)
+	require.ErrorIs(t, err, app.ErrHoldNotFound)
+	entries, err := globex.ListTransactions(w.ID, app.TransactionFilter{Limit: 10})
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	list, err := globex.ListByOwner("user-1")
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	require.Equal(t, other.ID, list[0].ID)
+	all, _ := acme.List()
+	require.Len(t, all, 1)
+
+	got, _ := acme.GetByID(w.ID)
+	require.Equal(t, models.MoneyFromInt(100), got.Balance)
+}
+
+func testIdempotentRollsBack(t *testing.T, repo app.WalletRepositoryService) {
+	w, _ := repo.Create(models.MoneyFromInt(5), "USD", "user-1")
+	failure := errors.New("response not stored")
+
+	_, err := repo.Idempotent("key-1", "fp-1", func(tx app.WalletRepositoryService) ([]byte, error) {
+		if _, _, _, err := tx.Deposit(w.ID, models.MoneyFromInt(10), "USD", nil); err != nil {
+			return nil, err
+		}
+		if _, err := tx.Create(models.MoneyFromInt(1), "USD", "user-1"); err != nil {
+			return nil, err
+		}
+		return nil, failure
+	})
+	require.ErrorIs(t, err, failure)
+
+	got, err := repo.GetByID(w.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.MoneyFromInt(5), got.Balance)
+	entries, err := repo.ListTransactions(w.ID, app.TransactionFilter{})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	list, err := repo.List()
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+}
+
+func testTransaction(t *testing.T, repo app.WalletRepositoryService) {
+	w, _ := repo.Create(models.MoneyFromInt(5), "USD", "user-1")
+
+	// A change that fails is undone alone; the others commit together.
+	var results []models.Money
+	err := repo.Transaction(func(tx app.WalletRepositoryService) error {
+		_, newBalance, _, err := tx.Deposit(w.ID, models.MoneyFromInt(10), "USD", nil)
+		require.NoError(t, err)
+		results = append(results, newBalance)
+		_, _, _, err = tx.Withdraw(w.ID, models.MoneyFromInt(100), "USD", nil)
+		require.ErrorIs(t, err, app.ErrInsufficientFunds)
+		_, newBalance, _, err = tx.Withdraw(w.ID, models.MoneyFromInt(3), "USD", nil)
+		require.NoError(t, err)
+		results = append(results, newBalance)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []models.Money{models.MoneyFromInt(15), models.MoneyFromInt(12)}, results)
+
+	got, err := repo.GetByID(w.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.MoneyFromInt(12), got.Balance)
+	entries, err := repo.ListTransactions(w.ID, app.TransactionFilter{})
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+}
+
+func testTransactionRollsBack(t *testing.T, repo app.WalletRepositoryService) {
+	w, _ := repo.Create(models.MoneyFromInt(5), "USD", "user-1")
+	failure := errors.New("batch failed")
+
+	err := repo.Transaction(func(tx app.WalletRepositoryService) error {
+		_, _, _, err := tx.Deposit(w.ID, models.MoneyFromInt(10), "USD", nil)
+		require.NoError(t, err)
+		return failure
+	})
+	require.ErrorIs(t, err, failure)
+
+	got, err := repo.GetByID(w.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.MoneyFromInt(5), got.Balance)
+	entries, err := repo.ListTransactions(w.ID, app.TransactionFilter{})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+}
+
+func testVersions(t *testing.T, repo app.WalletRepositoryService) {
+	w, err := repo.Create(models.MoneyFromInt(10), "USD", "user-1")
+	require.NoError(t, err)
+	require.Equal(t, int64(1), w.Version)
+
+	// Every change moves the version on, and its result reports the version
+	// a read sees afterwards.
+	version := w.Version
+	changes := []func() (*models.WalletModel, error){
+		func() (*models.WalletModel, error) {
+			_, _, w, err := repo.Deposit(w.ID, models.MoneyFromInt(5), "USD", nil)
+			return w, err
+		},
+		func() (*models.WalletModel, error) {
+			_, _, w, err := repo.Withdraw(w.ID, models.MoneyFromInt(3), "USD", nil)
+			return w, err
+		},
+		func() (*models.WalletModel, error) {
+			_, _, w, err := repo.UpdateBalance(w.ID, models.MoneyFromInt(20))
+			return w, err
+		},
+	}
+	for _, change := range changes {
+		changed, err := change()
+		require.NoError(t, err)
+		require.Greater(t, changed.Version, version)
+		got, err := repo.GetByID(w.ID)
+		require.NoError(t, err)
+		require.Equal(t, changed.Version, got.Version)
+		version = got.Version
+	}
+
+	_, _, _, err = repo.Withdraw(w.ID, models.MoneyFromInt(100), "USD", nil)
+	require.ErrorIs(t, err, app.ErrInsufficientFunds)
+	got, err := repo.GetByID(w.ID)
+	require.NoError(t, err)
+	require.Equal(t, version, got.Version)
+}
+
+func testExpectVersion(t *testing.T, repo app.WalletRepositoryService) {
+	w, _ := repo.Create(models.MoneyFromInt(100), "USD", "user-1")
+	other, _ := repo.Create(models.MoneyFromInt(0), "USD", "user-1")
+	_, _, _, err := repo.Deposit(w.ID, models.MoneyFromInt(1), "USD", nil)
+	require.NoError(t, err)
+	current, err := repo.GetByID(w.ID)
+	require.NoError(t, err)
+
+	stale := repo.ExpectVersion(w.ID, w.Version)
+	_, _, _, err = stale.UpdateBalance(w.ID, models.MoneyFromInt(0))
+	require.ErrorIs(t, err, app.ErrVersionConflict)
+	_, _, _, err = stale.Deposit(w.ID, models.MoneyFromInt(1), "USD", nil)
+	require.ErrorIs(t, err, app.ErrVersionConflict)
+	_, _, _, err = stale.Withdraw(w.ID, models.MoneyFromInt(1), "USD", nil)
+	require.ErrorIs(t, err, app.ErrVersionConflict)
+	_, err = stale.Transfer(w.ID, other.ID, models.MoneyFromInt(1), "USD", nil)
+	require.ErrorIs(t, err, app.ErrVersionConflict)
+	_, err = stale.Authorize(w.ID, models.MoneyFromInt(1), "USD", time.Now().Add(time.Hour), nil)
+	require.ErrorIs(t, err, app.ErrVersionConflict)
+	require.ErrorIs(t, stale.Delete(w.ID), app.ErrVersionConflict)
+
+	got, err := repo.GetByID(w.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.MoneyFromInt(101), got.Balance)
+	require.Equal(t, current.Version, got.Version)
+
+	_, newBalance, updated, err := repo.ExpectVersion(w.ID, current.Version).UpdateBalance(w.ID, models.MoneyFromInt(0))
+	require.NoError(t, err)
+	require.True(t, newBalance.IsZero(), "balance is %s", newBalance)
+	// The version read before the first write is stale for the next one.
+	_, _, _, err = repo.ExpectVersion(w.ID, current.Version).Deposit(w.ID, models.MoneyFromInt(1), "USD", nil)
+	require.ErrorIs(t, err, app.ErrVersionConflict)
+	require.NoError(t, repo.ExpectVersion(w.ID, updated.Version).Delete(w.ID))
+}
+
+func testExpectVersionOtherWallets(t *testing.T, repo app.WalletRepositoryService) {
+	w, _ := repo.Create(models.MoneyFromInt(100), "USD", "user-1")
+	other, _ := repo.Create(models.MoneyFromInt(0), "USD", "user-1")
+
+	// Only the wallet the version was read from is checked: here the
+	// destination of a transfer from an up-to-date source.
+	res, err := repo.ExpectVersion(w.ID, w.Version).Transfer(w.ID, other.ID, models.MoneyFromInt(10), "USD", nil)
+	require.NoError(t, err)
+	require.Equal(t, models.MoneyFromInt(10), res.ToNewBalance)
+	_, _, _, err = repo.ExpectVersion(w.ID, w.Version).Deposit(other.ID, models.MoneyFromInt(1), "USD", nil)
+	require.NoError(t, err)
+	_, err = repo.ExpectVersion(other.ID, other.Version).Transfer(w.ID, other.ID, models.MoneyFromInt(1), "USD", nil)
+	require.ErrorIs(t, err, app.ErrVersionConflict)
+}
+
+func testExpectOwner(t *testing.T, repo app.WalletRepositoryService) {
+	w, _ := repo.Create(models.MoneyFromInt(100), "USD", "user-1")
+	other, _ := repo.Create(models.MoneyFromInt(0), "USD", "user-2")
+
+	stranger := repo.ExpectOwner(w.ID, "user-2")
+	_, _, _, err := stranger.Deposit(w.ID, models.MoneyFromInt(1), "USD", nil)
+	require.ErrorIs(t, err, app.ErrWalletNotFound)
+	_, _, _, err = stranger.Withdraw(w.ID, models.MoneyFromInt(1), "USD", nil)
+	require.ErrorIs(t, err, app.ErrWalletNotFound)
+	_, _, _, err = stranger.ExpectVersion(w.ID, w.Version).Withdraw(w.ID, models.MoneyFromInt(1), "USD", nil)
+	require.ErrorIs(t, err, app.ErrWalletNotFound)
+	_, err = stranger.Idempotent("key-1", "fp-1", func(tx app.WalletRepositoryService) ([]byte, error) {
+		_, _, _, err := tx.Deposit(w.ID, models.MoneyFromInt(1), "USD", nil)
+		return nil, err
+	})
+	require.ErrorIs(t, err, app.ErrWalletNotFound)
+
+	got, err := repo.GetByID(w.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.MoneyFromInt(100), got.Balance)
+	require.Equal(t, w.Version, got.Version)
+
+	// The owner may change the wallet, and other wallets are not checked.
+	_, newBalance, _, err := repo.ExpectOwner(w.ID, "user-1").Withdraw(w.ID, models.MoneyFromInt(10), "USD", nil)
+	require.NoError(t, err)
+	require.Equal(t, models.MoneyFromInt(90), newBalance)
+	_, _, _, err = stranger.Deposit(other.ID, models.MoneyFromInt(1), "USD", nil)
+	require.NoError(t, err)
+}
+
+func testHoldVersions(t *testing.T, repo app.WalletRepositoryService) {
+	w, _ := repo.Create(models.MoneyFromInt(100), "USD", "user-1")
+	version := func() int64 {
+		got, err := repo.GetByID(w.ID)
+		require.NoError(t, err)
+		return got.Version
+	}
+
+	// Hold changes report the version a read of their wallet sees afterwards.
+	first, err := repo.Authorize(w.ID, models.MoneyFromInt(10), "USD", time.Now().Add(time.Hour), nil)
+	require.NoError(t, err)
+	require.Equal(t, version(), first.WalletVersion)
+	second, err := repo.Authorize(w.ID, models.MoneyFromInt(20), "USD", time.Now().Add(time.Hour), nil)
+	require.NoError(t, err)
+	require.Equal(t, version(), second.WalletVersion)
+
+	// Capture and void check the version expected of the hold's wallet.
+	stale := repo.ExpectVersion(w.ID, first.WalletVersion)
+	_, _, _, err = stale.Capture(first.ID, nil)
+	require.ErrorIs(t, err, app.ErrVersionConflict)
+	_, err = stale.Void(second.ID)
+	require.ErrorIs(t, err, app.ErrVersionConflict)
+	require.Equal(t, second.WalletVersion, version())
+
+	captured, _, _, err := repo.ExpectVersion(w.ID, second.WalletVersion).Capture(first.ID, nil)
+	require.NoError(t, err)
+	require.Equal(t, version(), captured.WalletVersion)
+	voided, err := repo.ExpectVersion(w.ID, captured.WalletVersion).Void(second.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.HoldVoided, voided.Status)
+	require.Equal(t, version(), voided.WalletVersion)
+}
+
+func testIdempotentPerTenant(t *testing.T, repo app.WalletRepositoryService) {
+	acme := repo.ForTenant("acme")
+	globex := repo.ForTenant("globex")
+
+	create := func(tx app.WalletRepositoryService) ([]byte, error) {
+		w, err := tx.Create(models.MoneyFromInt(0), "USD", "user-1")
+		if err != nil {
+			return nil, err
+		}
+		return []byte(w.TenantID), nil
+	}
+
+	first, err := acme.Idempotent("key-1", "fp-1", create)
+	require.NoError(t, err)
+	require.Equal(t, "acme", string(first))
+
+	second, err := globex.Idempotent("key-1", "fp-2", create)
+	require.NoError(t, err)
+	require.Equal(t, "globex", string(second))
+}
+
+func testExpireHoldsAllTenants(t *testing.T, repo app.WalletRepositoryService) {
+	acme := repo.ForTenant("acme")
+	globex := repo.ForTenant("globex")
+
+	a, _ := acme.Create(models.MoneyFromInt(100), "USD", "user-1")
+	g, _ := globex.Create(models.MoneyFromInt(100), "USD", "user-1")
+	_, _ = acme.Authorize(a.ID, models.MoneyFromInt(30), "USD", time.Now().Add(time.Minute), nil)
+	_, _ = globex.Authorize(g.ID, models.MoneyFromInt(40), "USD", time.Now().Add(time.Minute), nil)
+
+	released, err := repo.ExpireHolds(time.Now().Add(2 * time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, 2, released)
+
+	gotA, _ := acme.GetByID(a.ID)
+	require.True(t, gotA.Held.IsZero())
+	gotG, _ := globex.GetByID(g.ID)
+	require.True(t, gotG.Held.IsZero())
+}
+
+func testWithContextDeadline(t *testing.T, repo app.WalletRepositoryService) {
+	w, _ := repo.Create(models.MoneyFromInt(100), "USD", "user-1")
+
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	_, _, _, err := repo.WithContext(ctx).Deposit(w.ID, models.MoneyFromInt(10), "USD", nil)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	got, _ := repo.GetByID(w.ID)
+	require.Equal(t, models.MoneyFromInt(100), got.Balance)
+}
+
+// available returns the available balance of w, failing t if it cannot be
+// computed exactly.
+func available(t *testing.T, w *models.WalletModel) models.Money {
+	t.Helper()
+	m, err := w.Available()
+	require.NoError(t, err)
+	return m
+}
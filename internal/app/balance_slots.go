@@ -0,0 +1,224 @@
+package app
+
+import (
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ichigo7diabol/go-test-wallet/internal/models"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Sharded balances relieve hot wallets. Every change to a plain wallet waits
+// for its row lock, so a wallet receiving many deposits at once commits them
+// one by one. A sharded wallet keeps part of its balance in slot rows and a
+// deposit locks one random slot instead of the wallet row, letting as many
+// deposits commit at once as there are slots. Everything else still locks the
+// wallet row; a debit that the wallet's own balance cannot cover first sweeps
+// the slots into it.
+
+// WithBalanceSlots returns a repository that creates wallets whose balance is
+// spread over n slots and sends deposits to them. n below 2 creates plain
+// wallets. Wallets keep the layout they were created with; without slots,
+// deposits to sharded wallets credit the wallet row.
+func (r *RepositoryService) WithBalanceSlots(n int) *RepositoryService {
+	if n < 2 {
+		n = 0
+	}
+	c := *r
+	c.slots = n
+	return &c
+}
+
+// createSlots adds the empty slots of a new sharded wallet.
+func createSlots(tx *gorm.DB, w *models.WalletModel) error {
+	if w.BalanceSlots == 0 {
+		return nil
+	}
+	slots := make([]models.BalanceSlotModel, w.BalanceSlots)
+	for i := range slots {
+		slots[i] = models.BalanceSlotModel{WalletID: w.ID, Slot: i}
+	}
+	return tx.Create(&slots).Error
+}
+
+// depositToSlot credits amount to a random slot of the sharded wallet w
+// without locking or writing the wallet row. w is updated to the whole
+// balance and version, which concurrent deposits to other slots may have changed too, so
+// their balances are consistent with each other only after they commit.
+func depositToSlot(tx *gorm.DB, w *models.WalletModel, amount models.Money, metadata map[string]string) (
+	oldBalance models.Money,
+	newBalance models.Money,
+	err error,
+) {
+	var s models.BalanceSlotModel
+	if err := lockSlot(tx, w.ID, rand.IntN(w.BalanceSlots), &s); err != nil {
+		return models.Money{}, models.Money{}, err
+	}
+	if s.Balance, err = s.Balance.Add(amount); err != nil {
+		return models.Money{}, models.Money{}, err
+	}
+	// Save would insert slot 0, taking its zero key for unset.
+	if err := tx.Model(&s).Where("wallet_id = ? AND slot = ?", s.WalletID, s.Slot).
+		Updates(map[string]any{"balance": s.Balance, "version": gorm.Expr("version + 1")}).Error; err != nil {
+		return models.Money{}, models.Money{}, err
+	}
+
+	totals, err := wholeBalances(tx, []uuid.UUID{w.ID})
+	if err != nil {
+		return models.Money{}, models.Money{}, err
+	}
+	w.Balance, w.Version = totals[w.ID].Balance, totals[w.ID].Version
+	w.UpdatedAt = time.Now()
+	newBalance = w.Balance
+	if oldBalance, err = newBalance.Sub(amount); err != nil {
+		return models.Money{}, models.Money{}, err
+	}
+	return oldBalance, newBalance, appendTransaction(tx, w, DepositOperation, amount, oldBalance, metadata)
+}
+
+// lockSlot loads a slot of the wallet into s holding a row lock until tx ends,
+// like lockWallet.
+func lockSlot(tx *gorm.DB, walletID uuid.UUID, slot int, s *models.BalanceSlotModel) error {
+	ctx, span := tracer.Start(tx.Statement.Context, "RepositoryService.lockSlot",
+		trace.WithAttributes(walletIDAttr(walletID)))
+	defer span.End()
+
+	start := time.Now()
+	err := tx.WithContext(ctx).Clauses(clause.Locking{Strength: "UPDATE"}).
+		First(s, "wallet_id = ? AND slot = ?", walletID, slot).Error
+	wait := time.Since(start)
+	lockWaitDuration.Observe(wait.Seconds())
+	span.SetAttributes(LockWaitTimeKey.Float64(float64(wait.Microseconds()) / 1000))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrWalletNotFound
+		}
+		span.RecordError(err)
+		return err
+	}
+	return nil
+}
+
+// shardTotal is the balance and version of some rows of a sharded wallet
+// added up.
+type shardTotal struct {
+	Balance models.Money
+	Version int64
+}
+
+func (t shardTotal) add(s models.BalanceSlotModel) (shardTotal, error) {
+	balance, err := t.Balance.Add(s.Balance)
+	return shardTotal{Balance: balance, Version: t.Version + s.Version}, err
+}
+
+// slotTotal returns what the slots of w hold and their versions. w must be
+// locked, so no sweep can run concurrently; deposits that have not committed
+// are not counted.
+func slotTotal(tx *gorm.DB, w *models.WalletModel) (total shardTotal, err error) {
+	if w.BalanceSlots == 0 {
+		return total, nil
+	}
+	var slots []models.BalanceSlotModel
+	if err := tx.Find(&slots, "wallet_id = ?", w.ID).Error; err != nil {
+		return shardTotal{}, err
+	}
+	for _, s := range slots {
+		if total, err = total.add(s); err != nil {
+			return shardTotal{}, err
+		}
+	}
+	return total, nil
+}
+
+// coverFromSlots makes sure a debit of amount from the locked wallet w can be
+// judged on its whole balance. If w's own available balance falls short, its
+// slots are swept into w.Balance; the caller saves w. It returns what is left
+// in the slots.
+func coverFromSlots(tx *gorm.DB, w *models.WalletModel, amount models.Money) (shardTotal, error) {
+	if w.BalanceSlots == 0 || w.Covers(amount) {
+		return slotTotal(tx, w)
+	}
+	return sweepSlots(tx, w)
+}
+
+// sweepSlots locks every slot of the locked wallet w, waiting for deposits in
+// flight, and moves their balance into w.Balance. The caller saves w. It
+// returns what is left in the slots: their versions, which a sweep keeps.
+func sweepSlots(tx *gorm.DB, w *models.WalletModel) (shardTotal, error) {
+	if w.BalanceSlots == 0 {
+		return shardTotal{}, nil
+	}
+	var slots []models.BalanceSlotModel
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("wallet_id = ?", w.ID).Order("slot").Find(&slots).Error; err != nil {
+		return shardTotal{}, err
+	}
+	var total shardTotal
+	for _, s := range slots {
+		var err error
+		if total, err = total.add(s); err != nil {
+			return shardTotal{}, err
+		}
+	}
+	left := shardTotal{Version: total.Version}
+	if total.Balance.IsZero() {
+		return left, nil
+	}
+	balance, err := w.Balance.Add(total.Balance)
+	if err != nil {
+		return shardTotal{}, err
+	}
+	w.Balance = balance
+	return left, tx.Model(&models.BalanceSlotModel{}).Where("wallet_id = ?", w.ID).
+		Update("balance", models.Money{}).Error
+}
+
+// withSlotBalances adds the slots of the sharded wallets among ws to their
+// Balance and Version.
+func withSlotBalances(db *gorm.DB, ws []models.WalletModel) error {
+	var ids []uuid.UUID
+	for _, w := range ws {
+		if w.BalanceSlots > 0 {
+			ids = append(ids, w.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	totals, err := wholeBalances(db, ids)
+	if err != nil {
+		return err
+	}
+	for i := range ws {
+		if total, ok := totals[ws[i].ID]; ok {
+			ws[i].Balance, ws[i].Version = total.Balance, total.Version
+		}
+	}
+	return nil
+}
+
+// wholeBalances returns the balance and version of each wallet in ids, slots
+// included. The wallet rows and their slots are read in one statement, so a
+// sweep committing meanwhile is neither missed nor counted twice.
+func wholeBalances(db *gorm.DB, ids []uuid.UUID) (map[uuid.UUID]shardTotal, error) {
+	var parts []models.BalanceSlotModel
+	if err := db.Raw(`SELECT id AS wallet_id, balance, version FROM wallet_models WHERE id IN ?
+		UNION ALL
+		SELECT wallet_id, balance, version FROM balance_slot_models WHERE wallet_id IN ?`, ids, ids).
+		Scan(&parts).Error; err != nil {
+		return nil, err
+	}
+	totals := make(map[uuid.UUID]shardTotal, len(ids))
+	for _, p := range parts {
+		total, err := totals[p.WalletID].add(p)
+		if err != nil {
+			return nil, err
+		}
+		totals[p.WalletID] = total
+	}
+	return totals, nil
+}
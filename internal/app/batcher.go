@@ -0,0 +1,137 @@
+package app
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ichigo7diabol/go-test-wallet/internal/models"
+)
+
+// balanceBatcher groups concurrent balance changes to the same wallet into one
+// database transaction, so a busy wallet pays for one commit and one row lock
+// acquisition per batch rather than per change. The first change to arrive
+// leads its batch: it waits up to window for others to join, or until size of
+// them have, then applies them in arrival order and hands each its own
+// outcome.
+type balanceBatcher struct {
+	repo    WalletRepositoryService
+	window  time.Duration
+	size    int
+	timeout time.Duration
+
+	mu      sync.Mutex
+	pending map[batchKey]*balanceBatch
+}
+
+type batchKey struct {
+	tenantID string
+	walletID uuid.UUID
+}
+
+type balanceBatch struct {
+	changes []*batchedChange
+	// full is closed when the batch reaches its size.
+	full chan struct{}
+}
+
+// batchedChange is one caller's deposit or withdrawal and, once done is
+// closed, its outcome.
+type batchedChange struct {
+	ctx      context.Context
+	p        *Principal
+	op       WalletOperation
+	amount   models.Money
+	currency string
+	metadata map[string]string
+
+	res  balanceChange
+	err  error
+	done chan struct{}
+}
+
+// newBalanceBatcher batches changes made through repo. A size of zero leaves
+// batches bounded by window alone; timeout bounds each batch's transaction.
+func newBalanceBatcher(repo WalletRepositoryService, window time.Duration, size int, timeout time.Duration) *balanceBatcher {
+	return &balanceBatcher{
+		repo:    repo,
+		window:  window,
+		size:    size,
+		timeout: timeout,
+		pending: make(map[batchKey]*balanceBatch),
+	}
+}
+
+// do applies c to the tenant's wallet id as part of a batch and returns its
+// outcome.
+func (b *balanceBatcher) do(tenantID string, id uuid.UUID, c *batchedChange) (balanceChange, error) {
+	key := batchKey{tenantID: tenantID, walletID: id}
+	c.done = make(chan struct{})
+
+	b.mu.Lock()
+	batch, joined := b.pending[key]
+	if !joined {
+		batch = &balanceBatch{full: make(chan struct{})}
+		b.pending[key] = batch
+	}
+	batch.changes = append(batch.changes, c)
+	if b.size > 0 && len(batch.changes) >= b.size {
+		delete(b.pending, key)
+		close(batch.full)
+	}
+	b.mu.Unlock()
+
+	if joined {
+		<-c.done
+		return c.res, c.err
+	}
+
+	timer := time.NewTimer(b.window)
+	select {
+	case <-timer.C:
+	case <-batch.full:
+		timer.Stop()
+	}
+	b.mu.Lock()
+	if b.pending[key] == batch {
+		delete(b.pending, key)
+	}
+	changes := batch.changes
+	b.mu.Unlock()
+
+	b.apply(c.ctx, tenantID, id, changes)
+	return c.res, c.err
+}
+
+// apply runs changes in one transaction under the leader's ctx. The batch
+// does not end with the leader's request: a change whose caller has gone
+// away by its turn is skipped, and the rest still apply. A change that fails
+// is undone alone; if the transaction itself fails, every change gets its
+// error.
+func (b *balanceBatcher) apply(ctx context.Context, tenantID string, id uuid.UUID, changes []*batchedChange) {
+	ctx, cancel := withTimeout(context.WithoutCancel(ctx), b.timeout)
+	defer cancel()
+
+	batchSize.Observe(float64(len(changes)))
+	err := b.repo.ForTenant(tenantID).WithContext(ctx).Transaction(func(repo WalletRepositoryService) error {
+		for _, c := range changes {
+			if c.err = c.ctx.Err(); c.err != nil {
+				continue
+			}
+			owned := expectOwner(repo, c.p, id)
+			apply := owned.Deposit
+			if c.op == WithdrawOperation {
+				apply = owned.Withdraw
+			}
+			c.res.OldBalance, c.res.NewBalance, c.res.Wallet, c.err = apply(id, c.amount, c.currency, c.metadata)
+		}
+		return nil
+	})
+	for _, c := range changes {
+		if err != nil {
+			c.res, c.err = balanceChange{}, err
+		}
+		close(c.done)
+	}
+}
@@ -0,0 +1,145 @@
+//go:build unit
+
+package app_test
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ichigo7diabol/go-test-wallet/internal/app"
+	"github.com/ichigo7diabol/go-test-wallet/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingRepository counts the transactions opened through Transaction.
+type countingRepository struct {
+	app.WalletRepositoryService
+	transactions *atomic.Int32
+}
+
+func (r countingRepository) ForTenant(tenantID string) app.WalletRepositoryService {
+	return countingRepository{r.WalletRepositoryService.ForTenant(tenantID), r.transactions}
+}
+
+func (r countingRepository) WithContext(ctx context.Context) app.WalletRepositoryService {
+	return countingRepository{r.WalletRepositoryService.WithContext(ctx), r.transactions}
+}
+
+func (r countingRepository) Transaction(fn func(repo app.WalletRepositoryService) error) error {
+	r.transactions.Add(1)
+	return r.WalletRepositoryService.Transaction(fn)
+}
+
+// batchResult is one caller's outcome of ChangeBalance.
+type batchResult struct {
+	old, new models.Money
+	err      error
+}
+
+// changeConcurrently makes n ChangeBalance calls at once and returns their
+// outcomes.
+func changeConcurrently(service *app.WalletService, id uuid.UUID, op app.WalletOperation, amount models.Money, n int) []batchResult {
+	results := make([]batchResult, n)
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			r := &results[i]
+			r.old, r.new, _, r.err = service.ChangeBalance(context.Background(), owner, id, op, amount, "USD", nil, nil, nil)
+		}()
+	}
+	close(start)
+	wg.Wait()
+	return results
+}
+
+func newBatchingService(t *testing.T, opts app.WalletServiceOptions) (*app.WalletService, *atomic.Int32, uuid.UUID) {
+	repo := countingRepository{app.NewMemoryRepository(), new(atomic.Int32)}
+	w, err := repo.Create(models.MoneyFromInt(5), "USD", "user-1")
+	require.NoError(t, err)
+	opts.WriteTimeout = time.Second
+	return app.NewWalletService(repo, opts), repo.transactions, w.ID
+}
+
+func TestChangeBalance_Batched(t *testing.T) {
+	service, transactions, id := newBatchingService(t, app.WalletServiceOptions{BatchWindow: 200 * time.Millisecond})
+
+	results := changeConcurrently(service, id, app.WithdrawOperation, models.MoneyFromInt(1), 10)
+
+	assert.Equal(t, int32(1), transactions.Load())
+	// Each caller gets its own outcome: five withdrawals drain the wallet one
+	// after the other and the rest find it empty.
+	var olds []models.Money
+	failed := 0
+	for _, r := range results {
+		if r.err != nil {
+			assert.ErrorIs(t, r.err, app.ErrInsufficientFunds)
+			failed++
+			continue
+		}
+		want, err := r.old.Sub(models.MoneyFromInt(1))
+		require.NoError(t, err)
+		assert.Equal(t, want, r.new)
+		olds = append(olds, r.old)
+	}
+	assert.Equal(t, 5, failed)
+	assert.ElementsMatch(t, []models.Money{
+		models.MoneyFromInt(5), models.MoneyFromInt(4), models.MoneyFromInt(3), models.MoneyFromInt(2), models.MoneyFromInt(1),
+	}, olds)
+
+	w, err := service.GetWallet(context.Background(), owner, id)
+	require.NoError(t, err)
+	assert.True(t, w.Balance.IsZero(), "balance is %s", w.Balance)
+}
+
+func TestChangeBalance_BatchSize(t *testing.T) {
+	service, transactions, id := newBatchingService(t, app.WalletServiceOptions{
+		BatchWindow: 200 * time.Millisecond,
+		BatchSize:   4,
+	})
+
+	results := changeConcurrently(service, id, app.DepositOperation, models.MoneyFromInt(1), 10)
+
+	for _, r := range results {
+		require.NoError(t, r.err)
+	}
+	assert.Equal(t, int32(3), transactions.Load())
+	w, err := service.GetWallet(context.Background(), owner, id)
+	require.NoError(t, err)
+	assert.Equal(t, models.MoneyFromInt(15), w.Balance)
+}
+
+func TestChangeBalance_IdempotentNotBatched(t *testing.T) {
+	service, transactions, id := newBatchingService(t, app.WalletServiceOptions{BatchWindow: time.Hour})
+
+	_, newBalance, _, err := service.ChangeBalance(context.Background(), owner, id, app.DepositOperation,
+		models.MoneyFromInt(1), "USD", nil, nil, &app.Idempotency{Key: "key-1", Fingerprint: "fp-1"})
+
+	require.NoError(t, err)
+	assert.Equal(t, models.MoneyFromInt(6), newBalance)
+	assert.Zero(t, transactions.Load())
+}
+
+func TestChangeBalance_VersionedNotBatched(t *testing.T) {
+	service, transactions, id := newBatchingService(t, app.WalletServiceOptions{BatchWindow: time.Hour})
+	w, err := service.GetWallet(context.Background(), owner, id)
+	require.NoError(t, err)
+
+	_, newBalance, _, err := service.ChangeBalance(context.Background(), owner, id, app.DepositOperation,
+		models.MoneyFromInt(1), "USD", nil, &w.Version, nil)
+	require.NoError(t, err)
+	assert.Equal(t, models.MoneyFromInt(6), newBalance)
+	assert.Zero(t, transactions.Load())
+
+	_, _, _, err = service.ChangeBalance(context.Background(), owner, id, app.DepositOperation,
+		models.MoneyFromInt(1), "USD", nil, &w.Version, nil)
+	assert.ErrorIs(t, err, app.ErrVersionConflict)
+}
@@ -0,0 +1,51 @@
+package app
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ichigo7diabol/go-test-wallet/internal/models"
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
+
+// TransactionCursor points at the last ledger entry of a page. It is handed
+// to clients as an opaque string.
+type TransactionCursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+}
+
+func NewTransactionCursor(t *models.TransactionModel) TransactionCursor {
+	return TransactionCursor{CreatedAt: t.CreatedAt, ID: t.ID}
+}
+
+func (c TransactionCursor) Encode() string {
+	raw := c.CreatedAt.Format(time.RFC3339Nano) + "|" + c.ID.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func DecodeTransactionCursor(s string) (TransactionCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return TransactionCursor{}, ErrInvalidCursor
+	}
+	ts, id, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return TransactionCursor{}, ErrInvalidCursor
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		return TransactionCursor{}, ErrInvalidCursor
+	}
+	uid, err := uuid.Parse(id)
+	if err != nil {
+		return TransactionCursor{}, ErrInvalidCursor
+	}
+	return TransactionCursor{CreatedAt: createdAt, ID: uid}, nil
+}
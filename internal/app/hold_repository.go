@@ -0,0 +1,281 @@
+package app
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ichigo7diabol/go-test-wallet/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrHoldNotFound       = errors.New("hold not found")
+	ErrHoldNotActive      = errors.New("hold is not active")
+	ErrHoldExpired        = errors.New("hold has expired")
+	ErrHoldAmountExceeded = errors.New("capture amount exceeds hold amount")
+)
+
+// Authorize reserves amount on the wallet until expiresAt without debiting it.
+func (r *RepositoryService) Authorize(
+	walletID uuid.UUID,
+	amount models.Money,
+	currency string,
+	expiresAt time.Time,
+	metadata map[string]string,
+) (*models.HoldModel, error) {
+	if amount.IsNegative() {
+		return nil, ErrInvalidAmount
+	}
+	var h *models.HoldModel
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var w models.WalletModel
+		if err := r.lock(tx, walletID, &w); err != nil {
+			return err
+		}
+		if w.Currency != currency {
+			return ErrCurrencyMismatch
+		}
+		now := time.Now()
+		if err := releaseExpiredHolds(tx, &w, now); err != nil {
+			return err
+		}
+		// Holds are backed by the wallet's own balance, never by its slots.
+		slots, err := coverFromSlots(tx, &w, amount)
+		if err != nil {
+			return err
+		}
+		if !w.Covers(amount) {
+			return ErrInsufficientFunds
+		}
+
+		if w.Held, err = w.Held.Add(amount); err != nil {
+			return err
+		}
+		w.UpdatedAt = now
+		if err := saveWallet(tx, &w); err != nil {
+			return err
+		}
+
+		h = &models.HoldModel{
+			ID:        uuid.New(),
+			TenantID:  w.TenantID,
+			WalletID:  w.ID,
+			Amount:    amount,
+			Currency:  w.Currency,
+			Status:    models.HoldActive,
+			ExpiresAt: expiresAt,
+			Metadata:  metadata,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		if err := tx.Create(h).Error; err != nil {
+			return err
+		}
+		h.WalletVersion = w.Version + slots.Version
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func (r *RepositoryService) GetHold(id uuid.UUID) (*models.HoldModel, error) {
+	var h models.HoldModel
+	if err := r.db.First(&h, "id = ? AND tenant_id = ?", id, r.tenantID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrHoldNotFound
+		}
+		return nil, err
+	}
+	return &h, nil
+}
+
+// Capture debits amount from the hold's wallet and releases the rest of the
+// hold. A nil amount captures the full hold.
+func (r *RepositoryService) Capture(id uuid.UUID, amount *models.Money) (
+	hold *models.HoldModel,
+	oldBalance models.Money,
+	newBalance models.Money,
+	err error,
+) {
+	if amount != nil && amount.IsNegative() {
+		return nil, models.Money{}, models.Money{}, ErrInvalidAmount
+	}
+	var h models.HoldModel
+
+	err = r.db.Transaction(func(tx *gorm.DB) error {
+		var w models.WalletModel
+		if err := r.lockHold(tx, id, &h, &w); err != nil {
+			return err
+		}
+		now := time.Now()
+		if !h.ExpiresAt.After(now) {
+			return ErrHoldExpired
+		}
+		captured := h.Amount
+		if amount != nil {
+			captured = *amount
+		}
+		if captured.Cmp(h.Amount) > 0 {
+			return ErrHoldAmountExceeded
+		}
+
+		slots, err := slotTotal(tx, &w)
+		if err != nil {
+			return err
+		}
+		var a arith
+		oldBalance = a.add(w.Balance, slots.Balance)
+		newBalance = a.sub(oldBalance, captured)
+		w.Balance = a.sub(w.Balance, captured)
+		w.Held = a.sub(w.Held, h.Amount)
+		if a.err != nil {
+			return a.err
+		}
+		w.UpdatedAt = now
+		if err := saveWallet(tx, &w); err != nil {
+			return err
+		}
+		w.Balance = newBalance
+
+		h.Status = models.HoldCaptured
+		h.CapturedAmount = captured
+		h.UpdatedAt = now
+		if err := tx.Save(&h).Error; err != nil {
+			return err
+		}
+		h.WalletVersion = w.Version + slots.Version
+		metadata := map[string]string{"holdId": h.ID.String()}
+		return appendTransaction(tx, &w, CaptureOperation, captured, oldBalance, metadata)
+	})
+
+	// An expired hold is released even though the capture itself fails.
+	if errors.Is(err, ErrHoldExpired) {
+		if _, releaseErr := r.releaseHold(id, models.HoldExpired); releaseErr != nil {
+			return nil, models.Money{}, models.Money{}, releaseErr
+		}
+	}
+	if err != nil {
+		return nil, models.Money{}, models.Money{}, err
+	}
+	return &h, oldBalance, newBalance, nil
+}
+
+// Void releases an active hold without debiting the wallet.
+func (r *RepositoryService) Void(id uuid.UUID) (*models.HoldModel, error) {
+	return r.releaseHold(id, models.HoldVoided)
+}
+
+// ExpireHolds releases active holds whose expiry is not after now and returns
+// how many were released. It is a maintenance sweep and covers every tenant,
+// whichever tenant r is scoped to.
+func (r *RepositoryService) ExpireHolds(now time.Time) (int, error) {
+	var expired []models.HoldModel
+	if err := r.db.Select("id", "tenant_id").
+		Where("status = ? AND expires_at <= ?", models.HoldActive, now).
+		Find(&expired).Error; err != nil {
+		return 0, err
+	}
+	released := 0
+	for _, h := range expired {
+		tenant := *r
+		tenant.tenantID = h.TenantID
+		_, err := tenant.releaseHold(h.ID, models.HoldExpired)
+		if errors.Is(err, ErrHoldNotActive) {
+			continue
+		}
+		if err != nil {
+			return released, err
+		}
+		released++
+	}
+	return released, nil
+}
+
+// releaseHold ends an active hold with status and returns it.
+func (r *RepositoryService) releaseHold(id uuid.UUID, status models.HoldStatus) (*models.HoldModel, error) {
+	var h models.HoldModel
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var w models.WalletModel
+		if err := r.lockHold(tx, id, &h, &w); err != nil {
+			return err
+		}
+		now := time.Now()
+		held, err := w.Held.Sub(h.Amount)
+		if err != nil {
+			return err
+		}
+		w.Held = held
+		w.UpdatedAt = now
+		if err := saveWallet(tx, &w); err != nil {
+			return err
+		}
+		h.Status = status
+		h.UpdatedAt = now
+		if err := tx.Save(&h).Error; err != nil {
+			return err
+		}
+		slots, err := slotTotal(tx, &w)
+		h.WalletVersion = w.Version + slots.Version
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+// lockHold locks an active hold of the tenant and its wallet. The wallet is
+// locked first, matching the order used by balance operations.
+func (r *RepositoryService) lockHold(tx *gorm.DB, id uuid.UUID, h *models.HoldModel, w *models.WalletModel) error {
+	if err := tx.First(h, "id = ? AND tenant_id = ?", id, r.tenantID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrHoldNotFound
+		}
+		return err
+	}
+	if err := r.lock(tx, h.WalletID, w); err != nil {
+		return err
+	}
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		First(h, "id = ?", id).Error; err != nil {
+		return err
+	}
+	if h.Status != models.HoldActive {
+		return ErrHoldNotActive
+	}
+	return nil
+}
+
+// releaseExpiredHolds expires the wallet's outstanding holds that are past
+// their expiry so they stop reducing the available balance. w must be locked.
+func releaseExpiredHolds(tx *gorm.DB, w *models.WalletModel, now time.Time) error {
+	if w.Held.IsZero() {
+		return nil
+	}
+	var expired []models.HoldModel
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("tenant_id = ? AND wallet_id = ? AND status = ? AND expires_at <= ?",
+			w.TenantID, w.ID, models.HoldActive, now).
+		Find(&expired).Error; err != nil {
+		return err
+	}
+	for i := range expired {
+		held, err := w.Held.Sub(expired[i].Amount)
+		if err != nil {
+			return err
+		}
+		w.Held = held
+		expired[i].Status = models.HoldExpired
+		expired[i].UpdatedAt = now
+		if err := tx.Save(&expired[i]).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
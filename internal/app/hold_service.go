@@ -0,0 +1,156 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ichigo7diabol/go-test-wallet/internal/models"
+)
+
+var (
+	ErrInvalidHoldExpiry = errors.New("hold expiry must be in the future")
+)
+
+// AuthorizeHold reserves amount on the wallet. A nil expiresAt uses the
+// configured HoldTTL.
+func (s *WalletService) AuthorizeHold(
+	ctx context.Context,
+	p *Principal,
+	walletID uuid.UUID,
+	amount models.Money,
+	currency string,
+	expiresAt *time.Time,
+	metadata map[string]string,
+	version *int64,
+) (_ *models.HoldModel, err error) {
+	ctx, span := startSpan(ctx, "AuthorizeHold", walletIDAttr(walletID))
+	defer endSpan(span, &err)
+	ctx, cancel := withTimeout(ctx, s.opts.WriteTimeout)
+	defer cancel()
+
+	c, err := checkAmount(amount, currency)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	expiry := now.Add(s.opts.HoldTTL)
+	if expiresAt != nil {
+		expiry = *expiresAt
+	}
+	if !expiry.After(now) {
+		return nil, ErrInvalidHoldExpiry
+	}
+	repo, policy, err := s.tenant(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	if err := policy.checkOperation(amount, c.Code); err != nil {
+		return nil, err
+	}
+	if _, err := accessibleWallet(repo, p, walletID); err != nil {
+		return nil, err
+	}
+	return expectVersion(repo, walletID, version).Authorize(walletID, amount, c.Code, expiry, metadata)
+}
+
+func (s *WalletService) GetHold(ctx context.Context, p *Principal, id uuid.UUID) (_ *models.HoldModel, err error) {
+	ctx, span := startSpan(ctx, "GetHold", HoldIDKey.String(id.String()))
+	defer endSpan(span, &err)
+	ctx, cancel := withTimeout(ctx, s.opts.ReadTimeout)
+	defer cancel()
+
+	repo, _, err := s.tenant(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	return accessibleHold(repo, p, id)
+}
+
+// CaptureHold debits the held funds. A nil amount captures the whole hold; a
+// smaller amount captures part of it and releases the remainder. A non-nil
+// version is the version the hold's wallet must be at.
+func (s *WalletService) CaptureHold(ctx context.Context, p *Principal, id uuid.UUID, amount *models.Money, version *int64) (
+	hold *models.HoldModel,
+	oldBalance models.Money,
+	newBalance models.Money,
+	err error,
+) {
+	ctx, span := startSpan(ctx, "CaptureHold",
+		HoldIDKey.String(id.String()),
+		OperationKey.String(string(CaptureOperation)),
+	)
+	defer endSpan(span, &err)
+	ctx, cancel := withTimeout(ctx, s.opts.WriteTimeout)
+	defer cancel()
+
+	repo, _, err := s.tenant(ctx, p)
+	if err != nil {
+		return nil, models.Money{}, models.Money{}, err
+	}
+	h, err := accessibleHold(repo, p, id)
+	if err != nil {
+		return nil, models.Money{}, models.Money{}, err
+	}
+	span.SetAttributes(walletIDAttr(h.WalletID))
+	if amount != nil {
+		if _, err := checkAmount(*amount, h.Currency); err != nil {
+			return nil, models.Money{}, models.Money{}, err
+		}
+	}
+	start := time.Now()
+	hold, oldBalance, newBalance, err = expectVersion(repo, h.WalletID, version).Capture(id, amount)
+	observeTransaction(CaptureOperation, start)
+	if err != nil {
+		return nil, models.Money{}, models.Money{}, err
+	}
+	observeMoneyMoved(CaptureOperation, hold.Currency, hold.CapturedAmount)
+	return hold, oldBalance, newBalance, nil
+}
+
+// VoidHold releases the hold. A non-nil version is the version the hold's
+// wallet must be at.
+func (s *WalletService) VoidHold(ctx context.Context, p *Principal, id uuid.UUID, version *int64) (_ *models.HoldModel, err error) {
+	ctx, span := startSpan(ctx, "VoidHold", HoldIDKey.String(id.String()))
+	defer endSpan(span, &err)
+	ctx, cancel := withTimeout(ctx, s.opts.WriteTimeout)
+	defer cancel()
+
+	repo, _, err := s.tenant(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	h, err := accessibleHold(repo, p, id)
+	if err != nil {
+		return nil, err
+	}
+	return expectVersion(repo, h.WalletID, version).Void(id)
+}
+
+// ExpireHolds releases every active hold that is past its expiry, across all
+// tenants.
+func (s *WalletService) ExpireHolds(ctx context.Context) (_ int, err error) {
+	ctx, span := startSpan(ctx, "ExpireHolds")
+	defer endSpan(span, &err)
+	ctx, cancel := withTimeout(ctx, s.opts.WriteTimeout)
+	defer cancel()
+
+	return s.repository.WithContext(ctx).ExpireHolds(time.Now())
+}
+
+// accessibleHold loads a hold on a wallet p may act on. Like wallets, holds
+// owned by someone else are reported as not found.
+func accessibleHold(repo WalletRepositoryService, p *Principal, id uuid.UUID) (*models.HoldModel, error) {
+	h, err := repo.GetHold(id)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := accessibleWallet(repo, p, h.WalletID); err != nil {
+		if errors.Is(err, ErrWalletNotFound) {
+			return nil, ErrHoldNotFound
+		}
+		return nil, err
+	}
+	return h, nil
+}
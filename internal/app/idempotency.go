@@ -0,0 +1,57 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/ichigo7diabol/go-test-wallet/internal/models"
+)
+
+const MaxIdempotencyKeyLength = 255
+
+var (
+	ErrInvalidIdempotencyKey = errors.New("invalid idempotency key")
+)
+
+// Idempotency identifies a request the client may retry. Fingerprint is a
+// digest of the request body; a retry must carry the same one.
+type Idempotency struct {
+	Key         string
+	Fingerprint string
+}
+
+// balanceChange is the stored outcome of ChangeBalance.
+type balanceChange struct {
+	OldBalance models.Money
+	NewBalance models.Money
+	Wallet     *models.WalletModel
+}
+
+// idempotent runs fn directly when idem is nil. Otherwise fn runs through
+// WalletRepositoryService.Idempotent and its result is stored as JSON, so the
+// first call and every replay decode the same value.
+func idempotent[T any](
+	repo WalletRepositoryService,
+	idem *Idempotency,
+	fn func(repo WalletRepositoryService) (T, error),
+) (T, error) {
+	var result T
+	if idem == nil {
+		return fn(repo)
+	}
+	if idem.Key == "" || len(idem.Key) > MaxIdempotencyKeyLength {
+		return result, ErrInvalidIdempotencyKey
+	}
+	data, err := repo.Idempotent(idem.Key, idem.Fingerprint, func(repo WalletRepositoryService) ([]byte, error) {
+		res, err := fn(repo)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(res)
+	})
+	if err != nil {
+		return result, err
+	}
+	err = json.Unmarshal(data, &result)
+	return result, err
+}
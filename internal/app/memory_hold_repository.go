@@ -0,0 +1,238 @@
+package app
+
+import (
+	"errors"
+	"maps"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ichigo7diabol/go-test-wallet/internal/models"
+)
+
+// Authorize reserves amount on the wallet until expiresAt without debiting it.
+func (r *MemoryRepository) Authorize(
+	walletID uuid.UUID,
+	amount models.Money,
+	currency string,
+	expiresAt time.Time,
+	metadata map[string]string,
+) (*models.HoldModel, error) {
+	if amount.IsNegative() {
+		return nil, ErrInvalidAmount
+	}
+	var h models.HoldModel
+
+	err := r.update([]uuid.UUID{walletID}, func(ws []*memoryWallet) error {
+		mw := ws[0]
+		if mw.wallet.Currency != currency {
+			return ErrCurrencyMismatch
+		}
+		now := time.Now()
+		if err := mw.releaseExpiredHolds(now); err != nil {
+			return err
+		}
+		if !mw.wallet.Covers(amount) {
+			return ErrInsufficientFunds
+		}
+		held, err := mw.wallet.Held.Add(amount)
+		if err != nil {
+			return err
+		}
+		mw.wallet.Held = held
+		mw.wallet.UpdatedAt = now
+		mw.wallet.Version++
+
+		hold := &models.HoldModel{
+			ID:        uuid.New(),
+			TenantID:  mw.wallet.TenantID,
+			WalletID:  mw.wallet.ID,
+			Amount:    amount,
+			Currency:  mw.wallet.Currency,
+			Status:    models.HoldActive,
+			ExpiresAt: expiresAt,
+			Metadata:  maps.Clone(metadata),
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		mw.holds[hold.ID] = hold
+		mw.holdOrder = append(mw.holdOrder, hold.ID)
+		mw.active[hold.ID] = hold
+		r.store.mu.Lock()
+		r.store.holds[hold.ID] = mw
+		r.store.mu.Unlock()
+		h = *hold
+		h.WalletVersion = mw.wallet.Version
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+func (r *MemoryRepository) GetHold(id uuid.UUID) (*models.HoldModel, error) {
+	if err := r.ctx.Err(); err != nil {
+		return nil, err
+	}
+	mw := r.findHold(id)
+	if mw == nil {
+		return nil, ErrHoldNotFound
+	}
+	defer r.lock(mw, false)()
+	hold, ok := mw.holds[id]
+	if !ok {
+		return nil, ErrHoldNotFound
+	}
+	h := *hold
+	return &h, nil
+}
+
+// Capture debits amount from the hold's wallet and releases the rest of the
+// hold. A nil amount captures the full hold.
+func (r *MemoryRepository) Capture(id uuid.UUID, amount *models.Money) (
+	hold *models.HoldModel,
+	oldBalance models.Money,
+	newBalance models.Money,
+	err error,
+) {
+	if amount != nil && amount.IsNegative() {
+		return nil, models.Money{}, models.Money{}, ErrInvalidAmount
+	}
+	var h models.HoldModel
+	expired := false
+
+	err = r.updateHold(id, func(mw *memoryWallet, held *models.HoldModel) error {
+		now := time.Now()
+		if !held.ExpiresAt.After(now) {
+			// An expired hold is released even though the capture itself
+			// fails.
+			if err := mw.release(held, models.HoldExpired, now); err != nil {
+				return err
+			}
+			mw.wallet.Version++
+			expired = true
+			return nil
+		}
+		captured := held.Amount
+		if amount != nil {
+			captured = *amount
+		}
+		if captured.Cmp(held.Amount) > 0 {
+			return ErrHoldAmountExceeded
+		}
+
+		balance, err := mw.wallet.Balance.Sub(captured)
+		if err != nil {
+			return err
+		}
+		if err := mw.release(held, models.HoldCaptured, now); err != nil {
+			return err
+		}
+		oldBalance = mw.wallet.Balance
+		mw.wallet.Balance = balance
+		mw.wallet.Version++
+		newBalance = mw.wallet.Balance
+		held.CapturedAmount = captured
+		mw.appendTransaction(CaptureOperation, captured, oldBalance, map[string]string{"holdId": held.ID.String()})
+		h = *held
+		h.WalletVersion = mw.wallet.Version
+		return nil
+	})
+	if err == nil && expired {
+		err = ErrHoldExpired
+	}
+	if err != nil {
+		return nil, models.Money{}, models.Money{}, err
+	}
+	return &h, oldBalance, newBalance, nil
+}
+
+// Void releases an active hold without debiting the wallet.
+func (r *MemoryRepository) Void(id uuid.UUID) (*models.HoldModel, error) {
+	var h models.HoldModel
+	err := r.updateHold(id, func(mw *memoryWallet, held *models.HoldModel) error {
+		if err := mw.release(held, models.HoldVoided, time.Now()); err != nil {
+			return err
+		}
+		mw.wallet.Version++
+		h = *held
+		h.WalletVersion = mw.wallet.Version
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+// ExpireHolds releases active holds whose expiry is not after now and returns
+// how many were released. It is a maintenance sweep and covers every tenant,
+// whichever tenant r is scoped to.
+func (r *MemoryRepository) ExpireHolds(now time.Time) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	r.store.mu.RLock()
+	wallets := make([]*memoryWallet, 0, len(r.store.wallets))
+	for _, mw := range r.store.wallets {
+		wallets = append(wallets, mw)
+	}
+	r.store.mu.RUnlock()
+
+	released := 0
+	for _, mw := range wallets {
+		tenant := &MemoryRepository{store: r.store, tenantID: mw.tenantID, ctx: r.ctx}
+		err := tenant.update([]uuid.UUID{mw.wallet.ID}, func(ws []*memoryWallet) error {
+			for _, h := range ws[0].active {
+				if !h.ExpiresAt.After(now) {
+					if err := ws[0].release(h, models.HoldExpired, time.Now()); err != nil {
+						return err
+					}
+					ws[0].wallet.Version++
+					released++
+				}
+			}
+			return nil
+		})
+		if errors.Is(err, ErrWalletNotFound) {
+			continue
+		}
+		if err != nil {
+			return released, err
+		}
+	}
+	return released, nil
+}
+
+// findHold returns the wallet of the tenant's hold with id.
+func (r *MemoryRepository) findHold(id uuid.UUID) *memoryWallet {
+	r.store.mu.RLock()
+	mw := r.store.holds[id]
+	r.store.mu.RUnlock()
+	if mw == nil || mw.tenantID != r.tenantID {
+		return nil
+	}
+	return mw
+}
+
+// updateHold locks the wallet of the tenant's active hold with id and runs fn
+// on both, like lockHold.
+func (r *MemoryRepository) updateHold(id uuid.UUID, fn func(mw *memoryWallet, h *models.HoldModel) error) error {
+	if err := r.ctx.Err(); err != nil {
+		return err
+	}
+	mw := r.findHold(id)
+	if mw == nil {
+		return ErrHoldNotFound
+	}
+	return r.update([]uuid.UUID{mw.wallet.ID}, func(ws []*memoryWallet) error {
+		h, ok := ws[0].holds[id]
+		if !ok {
+			return ErrHoldNotFound
+		}
+		if h.Status != models.HoldActive {
+			return ErrHoldNotActive
+		}
+		return fn(ws[0], h)
+	})
+}
@@ -0,0 +1,743 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"maps"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ichigo7diabol/go-test-wallet/internal/models"
+)
+
+// MemoryRepository keeps wallets, ledger entries, holds and idempotency keys
+// in process memory, for tests and prototyping without a database. It is safe
+// for concurrent use: every wallet has its own mutex, taken where
+// RepositoryService takes the row lock, so operations on different wallets
+// run in parallel. Each operation applies entirely or not at all. Data is
+// lost when the process exits.
+type MemoryRepository struct {
+	store    *memoryStore
+	tenantID string
+	ctx      context.Context
+	// tx is set on the repository handed to an Idempotent callback.
+	tx *memoryTx
+	// expected is the wallet version set by ExpectVersion and owner the
+	// wallet owner set by ExpectOwner.
+	expected *expectedVersion
+	owner    *expectedOwner
+}
+
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{
+		store: &memoryStore{
+			wallets: map[uuid.UUID]*memoryWallet{},
+			holds:   map[uuid.UUID]*memoryWallet{},
+			keys:    map[memoryKeyID]*memoryKey{},
+		},
+		ctx: context.Background(),
+	}
+}
+
+// ForTenant returns a repository whose reads and writes are confined to
+// tenantID.
+func (r *MemoryRepository) ForTenant(tenantID string) WalletRepositoryService {
+	return &MemoryRepository{store: r.store, tenantID: tenantID, ctx: r.ctx, tx: r.tx, expected: r.expected, owner: r.owner}
+}
+
+// WithContext returns a repository whose operations fail once ctx is done.
+// An operation already waiting for a wallet lock is not interrupted.
+func (r *MemoryRepository) WithContext(ctx context.Context) WalletRepositoryService {
+	return &MemoryRepository{store: r.store, tenantID: r.tenantID, ctx: ctx, tx: r.tx, expected: r.expected, owner: r.owner}
+}
+
+// ExpectVersion returns a repository whose changes to wallet id fail with
+// ErrVersionConflict unless the wallet is at version when they lock it.
+func (r *MemoryRepository) ExpectVersion(id uuid.UUID, version int64) WalletRepositoryService {
+	return &MemoryRepository{
+		store:    r.store,
+		tenantID: r.tenantID,
+		ctx:      r.ctx,
+		tx:       r.tx,
+		expected: &expectedVersion{id: id, version: version},
+		owner:    r.owner,
+	}
+}
+
+// ExpectOwner returns a repository whose changes to wallet id fail with
+// ErrWalletNotFound unless ownerID owns it.
+func (r *MemoryRepository) ExpectOwner(id uuid.UUID, ownerID string) WalletRepositoryService {
+	return &MemoryRepository{
+		store:    r.store,
+		tenantID: r.tenantID,
+		ctx:      r.ctx,
+		tx:       r.tx,
+		expected: r.expected,
+		owner:    &expectedOwner{id: id, ownerID: ownerID},
+	}
+}
+
+// memoryStore indexes the wallets. mu guards the maps only; a wallet's
+// contents, its ledger and its holds are guarded by the wallet's own mutex.
+// mu is never held while waiting for a wallet.
+type memoryStore struct {
+	mu      sync.RWMutex
+	wallets map[uuid.UUID]*memoryWallet
+	// holds maps a hold id to the wallet it reserves funds on.
+	holds map[uuid.UUID]*memoryWallet
+	keys  map[memoryKeyID]*memoryKey
+}
+
+type memoryWallet struct {
+	// tenantID is the wallet's tenant. It never changes, so unlike wallet it
+	// may be read without mu.
+	tenantID string
+
+	mu      sync.Mutex
+	wallet  models.WalletModel
+	deleted bool
+	ledger  []models.TransactionModel
+	holds   map[uuid.UUID]*models.HoldModel
+	// holdOrder lists hold ids in creation order, so that holds created by
+	// a failed operation can be dropped again.
+	holdOrder []uuid.UUID
+	active    map[uuid.UUID]*models.HoldModel
+}
+
+type memoryKeyID struct {
+	tenantID string
+	key      string
+}
+
+// memoryKey is an idempotency key. mu is held while the request that claimed
+// it runs, so a concurrent retry waits and then replays the response.
+type memoryKey struct {
+	mu          sync.Mutex
+	done        bool
+	fingerprint string
+	response    []byte
+}
+
+// memoryTx is the state of one Idempotent call. Like a database transaction
+// it keeps every wallet it changed locked until it ends, and undoes the
+// changes if the callback fails.
+type memoryTx struct {
+	locked map[*memoryWallet]memorySnapshot
+	undo   []func()
+}
+
+func (tx *memoryTx) commit() {
+	for mw := range tx.locked {
+		mw.mu.Unlock()
+	}
+}
+
+func (tx *memoryTx) rollback() {
+	for i := len(tx.undo) - 1; i >= 0; i-- {
+		tx.undo[i]()
+	}
+	for mw, s := range tx.locked {
+		mw.restore(s)
+		mw.mu.Unlock()
+	}
+}
+
+// memorySnapshot is what restore needs to undo changes to a locked wallet.
+// Of the wallet only its balances, version and update time change; of the
+// holds only the active ones, and ledger entries and holds are appended.
+type memorySnapshot struct {
+	balance   models.Money
+	held      models.Money
+	version   int64
+	updatedAt time.Time
+	deleted   bool
+	ledger    int
+	holds     int
+	active    []models.HoldModel
+}
+
+func (mw *memoryWallet) snapshot() memorySnapshot {
+	s := memorySnapshot{
+		balance:   mw.wallet.Balance,
+		held:      mw.wallet.Held,
+		version:   mw.wallet.Version,
+		updatedAt: mw.wallet.UpdatedAt,
+		deleted:   mw.deleted,
+		ledger:    len(mw.ledger),
+		holds:     len(mw.holdOrder),
+		active:    make([]models.HoldModel, 0, len(mw.active)),
+	}
+	for _, h := range mw.active {
+		s.active = append(s.active, *h)
+	}
+	return s
+}
+
+func (mw *memoryWallet) restore(s memorySnapshot) {
+	mw.wallet.Balance = s.balance
+	mw.wallet.Held = s.held
+	mw.wallet.Version = s.version
+	mw.wallet.UpdatedAt = s.updatedAt
+	mw.deleted = s.deleted
+	mw.ledger = mw.ledger[:s.ledger]
+	for _, id := range mw.holdOrder[s.holds:] {
+		delete(mw.holds, id)
+	}
+	mw.holdOrder = mw.holdOrder[:s.holds]
+	mw.active = make(map[uuid.UUID]*models.HoldModel, len(s.active))
+	for _, h := range s.active {
+		*mw.holds[h.ID] = h
+		mw.active[h.ID] = mw.holds[h.ID]
+	}
+}
+
+// appendTransaction records a ledger entry for a balance change that has
+// already been applied to the wallet.
+func (mw *memoryWallet) appendTransaction(
+	op WalletOperation,
+	amount models.Money,
+	balanceBefore models.Money,
+	metadata map[string]string,
+) {
+	w := &mw.wallet
+	mw.ledger = append(mw.ledger, models.TransactionModel{
+		ID:            uuid.New(),
+		TenantID:      w.TenantID,
+		WalletID:      w.ID,
+		OperationType: string(op),
+		Amount:        amount,
+		BalanceBefore: balanceBefore,
+		BalanceAfter:  w.Balance,
+		Currency:      w.Currency,
+		Metadata:      maps.Clone(metadata),
+		CreatedAt:     w.UpdatedAt,
+	})
+}
+
+// release returns an active hold's amount to the available balance.
+func (mw *memoryWallet) release(h *models.HoldModel, status models.HoldStatus, now time.Time) error {
+	held, err := mw.wallet.Held.Sub(h.Amount)
+	if err != nil {
+		return err
+	}
+	mw.wallet.Held = held
+	mw.wallet.UpdatedAt = now
+	h.Status = status
+	h.UpdatedAt = now
+	delete(mw.active, h.ID)
+	return nil
+}
+
+// releaseExpiredHolds expires the wallet's active holds that are past their
+// expiry so they stop reducing the available balance.
+func (mw *memoryWallet) releaseExpiredHolds(now time.Time) error {
+	for _, h := range mw.active {
+		if !h.ExpiresAt.After(now) {
+			if err := mw.release(h, models.HoldExpired, now); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// find returns the tenant's wallet with id, deleted or not.
+func (r *MemoryRepository) find(id uuid.UUID) *memoryWallet {
+	r.store.mu.RLock()
+	mw := r.store.wallets[id]
+	r.store.mu.RUnlock()
+	if mw == nil || mw.tenantID != r.tenantID {
+		return nil
+	}
+	return mw
+}
+
+// lock takes mw's mutex and returns the function releasing it. Inside an
+// Idempotent call a wallet about to change stays locked until the call ends,
+// and one the call already holds is not locked again.
+func (r *MemoryRepository) lock(mw *memoryWallet, write bool) func() {
+	if r.tx != nil {
+		if _, ok := r.tx.locked[mw]; ok {
+			return func() {}
+		}
+	}
+	start := time.Now()
+	mw.mu.Lock()
+	if !write {
+		return mw.mu.Unlock
+	}
+	lockWaitDuration.Observe(time.Since(start).Seconds())
+	if r.tx != nil {
+		r.tx.locked[mw] = mw.snapshot()
+		return func() {}
+	}
+	return mw.mu.Unlock
+}
+
+// read runs fn on the tenant's wallet with id while holding its lock.
+func (r *MemoryRepository) read(id uuid.UUID, fn func(mw *memoryWallet) error) error {
+	if err := r.ctx.Err(); err != nil {
+		return err
+	}
+	mw := r.find(id)
+	if mw == nil {
+		return ErrWalletNotFound
+	}
+	defer r.lock(mw, false)()
+	if mw.deleted {
+		return ErrWalletNotFound
+	}
+	return fn(mw)
+}
+
+// update locks the tenant's wallets in ascending id order, as RepositoryService
+// does, checks the owner and version expected of any of them and runs fn on
+// them in the order of ids. If fn fails its changes are undone.
+func (r *MemoryRepository) update(ids []uuid.UUID, fn func(ws []*memoryWallet) error) error {
+	if err := r.ctx.Err(); err != nil {
+		return err
+	}
+	order := slices.Clone(ids)
+	slices.SortFunc(order, func(a, b uuid.UUID) int { return bytes.Compare(a[:], b[:]) })
+
+	locked := make(map[uuid.UUID]*memoryWallet, len(ids))
+	var unlocks []func()
+	defer func() {
+		for i := len(unlocks) - 1; i >= 0; i-- {
+			unlocks[i]()
+		}
+	}()
+	for _, id := range order {
+		mw := r.find(id)
+		if mw == nil {
+			return ErrWalletNotFound
+		}
+		unlocks = append(unlocks, r.lock(mw, true))
+		if mw.deleted || !r.owner.owns(&mw.wallet) {
+			return ErrWalletNotFound
+		}
+		locked[id] = mw
+	}
+	if r.expected != nil {
+		if mw, ok := locked[r.expected.id]; ok && mw.wallet.Version != r.expected.version {
+			return ErrVersionConflict
+		}
+	}
+
+	ws := make([]*memoryWallet, len(ids))
+	snapshots := make([]memorySnapshot, len(ids))
+	for i, id := range ids {
+		ws[i] = locked[id]
+		snapshots[i] = ws[i].snapshot()
+	}
+	if err := fn(ws); err != nil {
+		for i := len(ws) - 1; i >= 0; i-- {
+			ws[i].restore(snapshots[i])
+		}
+		return err
+	}
+	return nil
+}
+
+func (r *MemoryRepository) Create(initialBalance models.Money, currency string, ownerID string) (*models.WalletModel, error) {
+	if err := r.ctx.Err(); err != nil {
+		return nil, err
+	}
+	if initialBalance.IsNegative() {
+		return nil, ErrInvalidAmount
+	}
+	now := time.Now()
+	mw := &memoryWallet{
+		tenantID: r.tenantID,
+		wallet: models.WalletModel{
+			ID:        uuid.New(),
+			Balance:   initialBalance,
+			Currency:  currency,
+			TenantID:  r.tenantID,
+			OwnerID:   ownerID,
+			Version:   1,
+			CreatedAt: now,
+			UpdatedAt: now,
+		},
+		holds:  map[uuid.UUID]*models.HoldModel{},
+		active: map[uuid.UUID]*models.HoldModel{},
+	}
+	mw.appendTransaction(OpenOperation, initialBalance, models.Money{}, nil)
+	w := mw.wallet
+
+	if r.tx != nil {
+		// Hidden from others until the Idempotent call commits, and gone if
+		// it rolls back.
+		mw.mu.Lock()
+		s := mw.snapshot()
+		s.deleted, s.ledger = true, 0
+		r.tx.locked[mw] = s
+	}
+	r.store.mu.Lock()
+	r.store.wallets[w.ID] = mw
+	r.store.mu.Unlock()
+	return &w, nil
+}
+
+func (r *MemoryRepository) GetByID(id uuid.UUID) (*models.WalletModel, error) {
+	var w models.WalletModel
+	err := r.read(id, func(mw *memoryWallet) error {
+		w = mw.wallet
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+func (r *MemoryRepository) UpdateBalance(id uuid.UUID, balance models.Money) (
+	oldBalance models.Money,
+	newBalance models.Money,
+	model *models.WalletModel,
+	err error,
+) {
+	if balance.IsNegative() {
+		return models.Money{}, models.Money{}, nil, ErrInvalidAmount
+	}
+	var w models.WalletModel
+	err = r.update([]uuid.UUID{id}, func(ws []*memoryWallet) error {
+		mw := ws[0]
+		now := time.Now()
+		if err := mw.releaseExpiredHolds(now); err != nil {
+			return err
+		}
+		if balance.Cmp(mw.wallet.Held) < 0 {
+			return ErrBalanceBelowHeld
+		}
+		oldBalance = mw.wallet.Balance
+		mw.wallet.Balance = balance
+		mw.wallet.UpdatedAt = now
+		mw.wallet.Version++
+		newBalance = mw.wallet.Balance
+		change, err := newBalance.Sub(oldBalance)
+		if err != nil {
+			return err
+		}
+		mw.appendTransaction(AdjustOperation, change, oldBalance, nil)
+		w = mw.wallet
+		return nil
+	})
+	if err != nil {
+		return models.Money{}, models.Money{}, nil, err
+	}
+	return oldBalance, newBalance, &w, nil
+}
+
+func (r *MemoryRepository) Delete(id uuid.UUID) error {
+	return r.update([]uuid.UUID{id}, func(ws []*memoryWallet) error {
+		ws[0].deleted = true
+		return nil
+	})
+}
+
+func (r *MemoryRepository) List() ([]models.WalletModel, error) {
+	return r.list(func(*models.WalletModel) bool { return true })
+}
+
+func (r *MemoryRepository) ListByOwner(ownerID string) ([]models.WalletModel, error) {
+	return r.list(func(w *models.WalletModel) bool { return w.OwnerID == ownerID })
+}
+
+// list returns the tenant's wallets matching keep, oldest first.
+func (r *MemoryRepository) list(keep func(w *models.WalletModel) bool) ([]models.WalletModel, error) {
+	if err := r.ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.store.mu.RLock()
+	candidates := make([]*memoryWallet, 0, len(r.store.wallets))
+	for _, mw := range r.store.wallets {
+		if mw.tenantID == r.tenantID {
+			candidates = append(candidates, mw)
+		}
+	}
+	r.store.mu.RUnlock()
+
+	wallets := make([]models.WalletModel, 0, len(candidates))
+	for _, mw := range candidates {
+		unlock := r.lock(mw, false)
+		if !mw.deleted && keep(&mw.wallet) {
+			wallets = append(wallets, mw.wallet)
+		}
+		unlock()
+	}
+	sort.Slice(wallets, func(i, j int) bool {
+		if !wallets[i].CreatedAt.Equal(wallets[j].CreatedAt) {
+			return wallets[i].CreatedAt.Before(wallets[j].CreatedAt)
+		}
+		return bytes.Compare(wallets[i].ID[:], wallets[j].ID[:]) < 0
+	})
+	return wallets, nil
+}
+
+func (r *MemoryRepository) Deposit(id uuid.UUID, amount models.Money, currency string, metadata map[string]string) (
+	oldBalance models.Money,
+	newBalance models.Money,
+	model *models.WalletModel,
+	err error,
+) {
+	if amount.IsNegative() {
+		return models.Money{}, models.Money{}, nil, ErrInvalidAmount
+	}
+	var w models.WalletModel
+	err = r.update([]uuid.UUID{id}, func(ws []*memoryWallet) error {
+		mw := ws[0]
+		if mw.wallet.Currency != currency {
+			return ErrCurrencyMismatch
+		}
+		balance, err := mw.wallet.Balance.Add(amount)
+		if err != nil {
+			return err
+		}
+		oldBalance = mw.wallet.Balance
+		mw.wallet.Balance = balance
+		mw.wallet.UpdatedAt = time.Now()
+		mw.wallet.Version++
+		newBalance = mw.wallet.Balance
+		mw.appendTransaction(DepositOperation, amount, oldBalance, metadata)
+		w = mw.wallet
+		return nil
+	})
+	if err != nil {
+		return models.Money{}, models.Money{}, nil, err
+	}
+	return oldBalance, newBalance, &w, nil
+}
+
+func (r *MemoryRepository) Withdraw(id uuid.UUID, amount models.Money, currency string, metadata map[string]string) (
+	oldBalance models.Money,
+	newBalance models.Money,
+	model *models.WalletModel,
+	err error,
+) {
+	if amount.IsNegative() {
+		return models.Money{}, models.Money{}, nil, ErrInvalidAmount
+	}
+	var w models.WalletModel
+	err = r.update([]uuid.UUID{id}, func(ws []*memoryWallet) error {
+		mw := ws[0]
+		if mw.wallet.Currency != currency {
+			return ErrCurrencyMismatch
+		}
+		now := time.Now()
+		if err := mw.releaseExpiredHolds(now); err != nil {
+			return err
+		}
+		if !mw.wallet.Covers(amount) {
+			return ErrInsufficientFunds
+		}
+		balance, err := mw.wallet.Balance.Sub(amount)
+		if err != nil {
+			return err
+		}
+		oldBalance = mw.wallet.Balance
+		mw.wallet.Balance = balance
+		mw.wallet.UpdatedAt = now
+		mw.wallet.Version++
+		newBalance = mw.wallet.Balance
+		mw.appendTransaction(WithdrawOperation, amount, oldBalance, metadata)
+		w = mw.wallet
+		return nil
+	})
+	if err != nil {
+		return models.Money{}, models.Money{}, nil, err
+	}
+	return oldBalance, newBalance, &w, nil
+}
+
+// Transfer debits fromID and credits toID atomically. Both wallets are locked
+// in ascending id order so that concurrent transfers in opposite directions
+// cannot deadlock.
+func (r *MemoryRepository) Transfer(
+	fromID uuid.UUID,
+	toID uuid.UUID,
+	amount models.Money,
+	currency string,
+	metadata map[string]string,
+) (*TransferResult, error) {
+	if amount.IsNegative() {
+		return nil, ErrInvalidAmount
+	}
+	if fromID == toID {
+		return nil, ErrSameWallet
+	}
+	var from, to models.WalletModel
+	res := &TransferResult{ID: uuid.New()}
+
+	err := r.update([]uuid.UUID{fromID, toID}, func(ws []*memoryWallet) error {
+		src, dst := ws[0], ws[1]
+		if src.wallet.Currency != currency || dst.wallet.Currency != currency {
+			return ErrCurrencyMismatch
+		}
+		now := time.Now()
+		if err := src.releaseExpiredHolds(now); err != nil {
+			return err
+		}
+		if !src.wallet.Covers(amount) {
+			return ErrInsufficientFunds
+		}
+		fromBalance, err := src.wallet.Balance.Sub(amount)
+		if err != nil {
+			return err
+		}
+		toBalance, err := dst.wallet.Balance.Add(amount)
+		if err != nil {
+			return err
+		}
+
+		res.FromOldBalance, res.ToOldBalance = src.wallet.Balance, dst.wallet.Balance
+		src.wallet.Balance, dst.wallet.Balance = fromBalance, toBalance
+		src.wallet.UpdatedAt, dst.wallet.UpdatedAt = now, now
+		src.wallet.Version++
+		dst.wallet.Version++
+		res.FromNewBalance, res.ToNewBalance = src.wallet.Balance, dst.wallet.Balance
+
+		src.appendTransaction(TransferOperation, amount, res.FromOldBalance,
+			transferMetadata(metadata, res.ID, toID, "out"))
+		dst.appendTransaction(TransferOperation, amount, res.ToOldBalance,
+			transferMetadata(metadata, res.ID, fromID, "in"))
+		from, to = src.wallet, dst.wallet
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	res.From, res.To = &from, &to
+	return res, nil
+}
+
+// ListTransactions returns ledger entries newest first, ordered by creation
+// time and then id, like RepositoryService.
+func (r *MemoryRepository) ListTransactions(walletID uuid.UUID, filter TransactionFilter) ([]models.TransactionModel, error) {
+	if err := r.ctx.Err(); err != nil {
+		return nil, err
+	}
+	entries := []models.TransactionModel{}
+	mw := r.find(walletID)
+	if mw == nil {
+		return entries, nil
+	}
+	unlock := r.lock(mw, false)
+	for _, e := range mw.ledger {
+		if matchTransaction(&e, &filter) {
+			entries = append(entries, e)
+		}
+	}
+	unlock()
+
+	sort.Slice(entries, func(i, j int) bool { return transactionAfter(&entries[i], entries[j].CreatedAt, entries[j].ID) })
+	if filter.Limit > 0 && len(entries) > filter.Limit {
+		entries = entries[:filter.Limit]
+	}
+	return entries, nil
+}
+
+// transactionAfter reports whether e sorts before the entry created at
+// createdAt with id in newest-first order.
+func transactionAfter(e *models.TransactionModel, createdAt time.Time, id uuid.UUID) bool {
+	if !e.CreatedAt.Equal(createdAt) {
+		return e.CreatedAt.After(createdAt)
+	}
+	return bytes.Compare(e.ID[:], id[:]) > 0
+}
+
+func matchTransaction(e *models.TransactionModel, f *TransactionFilter) bool {
+	if len(f.OperationTypes) > 0 && !slices.Contains(f.OperationTypes, WalletOperation(e.OperationType)) {
+		return false
+	}
+	if f.MinAmount != nil && e.Amount.Cmp(*f.MinAmount) < 0 {
+		return false
+	}
+	if f.MaxAmount != nil && e.Amount.Cmp(*f.MaxAmount) > 0 {
+		return false
+	}
+	if f.From != nil && e.CreatedAt.Before(*f.From) {
+		return false
+	}
+	if f.To != nil && !e.CreatedAt.Before(*f.To) {
+		return false
+	}
+	if f.After != nil && !transactionAfter(&models.TransactionModel{CreatedAt: f.After.CreatedAt, ID: f.After.ID}, e.CreatedAt, e.ID) {
+		return false
+	}
+	return true
+}
+
+// Transaction runs fn with every wallet it changes staying locked until fn
+// returns, then keeps the changes if fn returned nil and undoes them
+// otherwise. A change that fails is undone alone, as in a savepoint.
+func (r *MemoryRepository) Transaction(fn func(repo WalletRepositoryService) error) error {
+	if err := r.ctx.Err(); err != nil {
+		return err
+	}
+	if r.tx != nil {
+		return fn(r)
+	}
+	tx := &memoryTx{locked: map[*memoryWallet]memorySnapshot{}}
+	if err := fn(&MemoryRepository{store: r.store, tenantID: r.tenantID, ctx: r.ctx, tx: tx, expected: r.expected, owner: r.owner}); err != nil {
+		tx.rollback()
+		return err
+	}
+	tx.commit()
+	return nil
+}
+
+// Idempotent runs fn at most once per key. fn gets a repository whose
+// changes stay locked until the response is stored and are undone if fn
+// fails, so the balance change and the stored response are never observed
+// separately. Retries with the same fingerprint get the stored response
+// back; a different fingerprint yields ErrIdempotencyKeyReused.
+func (r *MemoryRepository) Idempotent(
+	key string,
+	fingerprint string,
+	fn func(repo WalletRepositoryService) ([]byte, error),
+) ([]byte, error) {
+	if err := r.ctx.Err(); err != nil {
+		return nil, err
+	}
+	id := memoryKeyID{tenantID: r.tenantID, key: key}
+	r.store.mu.Lock()
+	k, ok := r.store.keys[id]
+	if !ok {
+		k = &memoryKey{}
+		r.store.keys[id] = k
+	}
+	r.store.mu.Unlock()
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.done {
+		if k.fingerprint != fingerprint {
+			return nil, ErrIdempotencyKeyReused
+		}
+		return k.response, nil
+	}
+
+	tx := r.tx
+	if tx == nil {
+		tx = &memoryTx{locked: map[*memoryWallet]memorySnapshot{}}
+	}
+	resp, err := fn(&MemoryRepository{store: r.store, tenantID: r.tenantID, ctx: r.ctx, tx: tx, expected: r.expected, owner: r.owner})
+	if err != nil {
+		if r.tx == nil {
+			tx.rollback()
+		}
+		return nil, err
+	}
+	k.done, k.fingerprint, k.response = true, fingerprint, resp
+	if r.tx != nil {
+		// Nested in another Idempotent call, which decides the outcome.
+		r.tx.undo = append(r.tx.undo, func() { k.done, k.fingerprint, k.response = false, "", nil })
+		return resp, nil
+	}
+	tx.commit()
+	return resp, nil
+}
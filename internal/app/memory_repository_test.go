@@ -0,0 +1,22 @@
+//go:build unit
+
+package app_test
+
+import (
+	"testing"
+
+	"github.com/ichigo7diabol/go-test-wallet/internal/app"
+	"github.com/ichigo7diabol/go-test-wallet/internal/app/apptest"
+)
+
+func TestMemoryRepository_Conformance(t *testing.T) {
+	apptest.RunRepositoryConformance(t, func(*testing.T) app.WalletRepositoryService {
+		return app.NewMemoryRepository()
+	})
+}
+
+func TestMemoryRepository_Concurrency(t *testing.T) {
+	apptest.RunConcurrencyConformance(t, func(*testing.T) app.WalletRepositoryService {
+		return app.NewMemoryRepository()
+	}, apptest.ConcurrencyOptions{})
+}
@@ -0,0 +1,80 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ichigo7diabol/go-test-wallet/internal/models"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Outcomes of a balance change, used as the outcome label.
+const (
+	OutcomeSuccess           = "success"
+	OutcomeInsufficientFunds = "insufficient_funds"
+	OutcomeNotFound          = "not_found"
+	OutcomeCanceled          = "canceled"
+	OutcomeError             = "error"
+)
+
+var (
+	operationsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "wallet",
+		Name:      "operations_total",
+		Help:      "Deposits and withdrawals by operation and outcome.",
+	}, []string{"operation", "outcome"})
+
+	transactionDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "wallet",
+		Name:      "transaction_duration_seconds",
+		Help:      "Time to apply a balance change in the database, row-lock wait included.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+
+	lockWaitDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "wallet",
+		Name:      "lock_wait_seconds",
+		Help:      "Time spent acquiring a wallet row lock.",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 14),
+	})
+
+	batchSize = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "wallet",
+		Name:      "batch_size",
+		Help:      "Balance changes applied per batched transaction.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 10),
+	})
+
+	moneyMovedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "wallet",
+		Name:      "money_moved_total",
+		Help:      "Sum of successfully moved amounts by currency and operation.",
+	}, []string{"currency", "operation"})
+)
+
+func outcome(err error) string {
+	switch {
+	case err == nil:
+		return OutcomeSuccess
+	case errors.Is(err, ErrInsufficientFunds):
+		return OutcomeInsufficientFunds
+	case errors.Is(err, ErrWalletNotFound):
+		return OutcomeNotFound
+	case errors.Is(err, context.Canceled):
+		return OutcomeCanceled
+	default:
+		return OutcomeError
+	}
+}
+
+// observeTransaction records how long the database part of op took.
+func observeTransaction(op WalletOperation, start time.Time) {
+	transactionDuration.WithLabelValues(string(op)).Observe(time.Since(start).Seconds())
+}
+
+// observeMoneyMoved adds amount to the per-currency total.
+func observeMoneyMoved(op WalletOperation, currency string, amount models.Money) {
+	moneyMovedTotal.WithLabelValues(currency, string(op)).Add(amount.Float64())
+}
@@ -0,0 +1,57 @@
+package app
+
+import (
+	"slices"
+
+	"github.com/ichigo7diabol/go-test-wallet/internal/models"
+)
+
+// Token scopes. AdminScope also lets a principal act on wallets owned by
+// anyone and satisfies every other scope.
+const (
+	ReadScope     = "wallet:read"
+	CreateScope   = "wallet:create"
+	DepositScope  = "wallet:deposit"
+	WithdrawScope = "wallet:withdraw"
+	TransferScope = "wallet:transfer"
+	HoldScope     = "wallet:hold"
+	AdminScope    = "wallet:admin"
+)
+
+// Principal is the authenticated caller an operation is performed for.
+type Principal struct {
+	Subject string
+	Scopes  []string
+	// TenantID confines the principal to one tenant's wallets, admins
+	// included.
+	TenantID string
+}
+
+func (p *Principal) HasScope(scope string) bool {
+	return p != nil && slices.Contains(p.Scopes, scope)
+}
+
+// Allows reports whether p may use an operation requiring scope. A nil
+// principal (authentication disabled) and admins are allowed everything.
+func (p *Principal) Allows(scope string) bool {
+	return p == nil || p.HasScope(AdminScope) || p.HasScope(scope)
+}
+
+// CanAccess reports whether p may act on w. A nil principal is only passed
+// when authentication is disabled and may access every wallet.
+func (p *Principal) CanAccess(w *models.WalletModel) bool {
+	return !p.ownsOnly() || w.OwnerID == p.Subject
+}
+
+// ownsOnly reports whether p may only act on wallets it owns.
+func (p *Principal) ownsOnly() bool {
+	return p != nil && !p.HasScope(AdminScope)
+}
+
+// ownerID is the owner recorded on wallets p creates.
+func (p *Principal) ownerID() string {
+	if p == nil {
+		return ""
+	}
+	return p.Subject
+}
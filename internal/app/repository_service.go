@@ -1,11 +1,16 @@
 package app
 
 import (
+	"bytes"
+	"context"
 	"errors"
+	"math"
+	"strconv"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/ichigo7diabol/go-test-wallet/internal/models"
+	"go.opentelemetry.io/otel/trace"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 )
@@ -14,37 +19,98 @@ var (
 	ErrInsufficientFunds = errors.New("insufficient funds")
 	ErrInvalidAmount     = errors.New("invalid amount")
 	ErrWalletNotFound    = errors.New("wallet not found")
+	ErrCurrencyMismatch  = errors.New("currency does not match wallet currency")
+	ErrSameWallet        = errors.New("source and destination wallets are the same")
+	ErrBalanceBelowHeld  = errors.New("balance is below the held amount")
+
+	ErrIdempotencyKeyReused = errors.New("idempotency key reused with a different request")
+	errIdempotencyKeyFree   = errors.New("idempotency key not used yet")
 )
 
+// RepositoryService stores wallets in a SQL database. Every query is scoped
+// to tenantID; NewRepository starts in the default tenant "". New wallets get
+// slots balance slots, see WithBalanceSlots, atomic selects the
+// single-statement balance updates of WithAtomicUpdates, expected is the
+// wallet version set by ExpectVersion and owner the wallet owner set by
+// ExpectOwner.
 type RepositoryService struct {
-	db *gorm.DB
+	db       *gorm.DB
+	tenantID string
+	slots    int
+	atomic   bool
+	expected *expectedVersion
+	owner    *expectedOwner
 }
 
 func NewRepository(db *gorm.DB) *RepositoryService {
 	return &RepositoryService{db: db}
 }
 
+// ForTenant returns a repository whose reads and writes are confined to
+// tenantID.
+func (r *RepositoryService) ForTenant(tenantID string) WalletRepositoryService {
+	c := *r
+	c.tenantID = tenantID
+	return &c
+}
+
+// WithContext returns a repository whose queries run under ctx, so they are
+// cancelled with it and traced as its children.
+func (r *RepositoryService) WithContext(ctx context.Context) WalletRepositoryService {
+	c := *r
+	c.db = r.db.WithContext(ctx)
+	return &c
+}
+
 type WalletRepositoryService interface {
-	Create(initialBalance float32) (*models.WalletModel, error)
+	ForTenant(tenantID string) WalletRepositoryService
+	WithContext(ctx context.Context) WalletRepositoryService
+	ExpectVersion(id uuid.UUID, version int64) WalletRepositoryService
+	ExpectOwner(id uuid.UUID, ownerID string) WalletRepositoryService
+	Create(initialBalance models.Money, currency string, ownerID string) (*models.WalletModel, error)
 	GetByID(id uuid.UUID) (*models.WalletModel, error)
-	UpdateBalance(id uuid.UUID, balance float32) (oldBalance float32, newBalance float32, model *models.WalletModel, err error)
+	UpdateBalance(id uuid.UUID, balance models.Money) (oldBalance models.Money, newBalance models.Money, model *models.WalletModel, err error)
 	Delete(id uuid.UUID) error
 	List() ([]models.WalletModel, error)
-	Deposit(id uuid.UUID, amount float32) (oldBalance float32, newBalance float32, model *models.WalletModel, err error)
-	Withdraw(id uuid.UUID, amount float32) (oldBalance float32, newBalance float32, model *models.WalletModel, err error)
+	ListByOwner(ownerID string) ([]models.WalletModel, error)
+	Deposit(id uuid.UUID, amount models.Money, currency string, metadata map[string]string) (oldBalance models.Money, newBalance models.Money, model *models.WalletModel, err error)
+	Withdraw(id uuid.UUID, amount models.Money, currency string, metadata map[string]string) (oldBalance models.Money, newBalance models.Money, model *models.WalletModel, err error)
+	Transfer(fromID uuid.UUID, toID uuid.UUID, amount models.Money, currency string, metadata map[string]string) (*TransferResult, error)
+	ListTransactions(walletID uuid.UUID, filter TransactionFilter) ([]models.TransactionModel, error)
+	Idempotent(key string, fingerprint string, fn func(repo WalletRepositoryService) ([]byte, error)) ([]byte, error)
+	Transaction(fn func(repo WalletRepositoryService) error) error
+	Authorize(walletID uuid.UUID, amount models.Money, currency string, expiresAt time.Time, metadata map[string]string) (*models.HoldModel, error)
+	GetHold(id uuid.UUID) (*models.HoldModel, error)
+	Capture(id uuid.UUID, amount *models.Money) (hold *models.HoldModel, oldBalance models.Money, newBalance models.Money, err error)
+	Void(id uuid.UUID) (*models.HoldModel, error)
+	ExpireHolds(now time.Time) (int, error)
 }
 
-func (r *RepositoryService) Create(initialBalance float32) (*models.WalletModel, error) {
-	if initialBalance < 0 {
+func (r *RepositoryService) Create(initialBalance models.Money, currency string, ownerID string) (*models.WalletModel, error) {
+	if initialBalance.IsNegative() {
 		return nil, ErrInvalidAmount
 	}
 	w := &models.WalletModel{
-		ID:        uuid.New(),
-		Balance:   initialBalance,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+		ID:           uuid.New(),
+		Balance:      initialBalance,
+		Currency:     currency,
+		TenantID:     r.tenantID,
+		OwnerID:      ownerID,
+		BalanceSlots: r.slots,
+		Version:      1,
+		CreatedAt:    time.Now(),
+		UpdatedAt:    time.Now(),
 	}
-	if err := r.db.Create(w).Error; err != nil {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(w).Error; err != nil {
+			return err
+		}
+		if err := createSlots(tx, w); err != nil {
+			return err
+		}
+		return appendTransaction(tx, w, OpenOperation, initialBalance, models.Money{}, nil)
+	})
+	if err != nil {
 		return nil, err
 	}
 	return w, nil
@@ -52,48 +118,65 @@ func (r *RepositoryService) Create(initialBalance float32) (*models.WalletModel,
 
 func (r *RepositoryService) GetByID(id uuid.UUID) (*models.WalletModel, error) {
 	var w models.WalletModel
-	if err := r.db.First(&w, "id = ?", id).Error; err != nil {
+	if err := r.db.First(&w, "id = ? AND tenant_id = ?", id, r.tenantID).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, ErrWalletNotFound
 		}
 		return nil, err
 	}
-	return &w, nil
+	ws := []models.WalletModel{w}
+	if err := withSlotBalances(r.db, ws); err != nil {
+		return nil, err
+	}
+	return &ws[0], nil
 }
 
-func (r *RepositoryService) UpdateBalance(id uuid.UUID, balance float32) (
-	oldBalance float32,
-	newBalance float32,
+func (r *RepositoryService) UpdateBalance(id uuid.UUID, balance models.Money) (
+	oldBalance models.Money,
+	newBalance models.Money,
 	model *models.WalletModel,
 	err error,
 ) {
-	if balance < 0 {
-		return 0, 0, nil, ErrInvalidAmount
+	if balance.IsNegative() {
+		return models.Money{}, models.Money{}, nil, ErrInvalidAmount
 	}
 	var w models.WalletModel
 	err = r.db.Transaction(func(tx *gorm.DB) error {
-		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
-			First(&w, "id = ?", id).Error; err != nil {
-			if errors.Is(err, gorm.ErrRecordNotFound) {
-				return ErrWalletNotFound
-			}
+		if err := r.lock(tx, id, &w); err != nil {
 			return err
 		}
+		slots, err := sweepSlots(tx, &w)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		if err := releaseExpiredHolds(tx, &w, now); err != nil {
+			return err
+		}
+		// Active holds must stay covered, as a withdrawal would leave them.
+		if balance.Cmp(w.Held) < 0 {
+			return ErrBalanceBelowHeld
+		}
 		oldBalance = w.Balance
 
 		w.Balance = balance
-		w.UpdatedAt = time.Now()
+		w.UpdatedAt = now
 
 		newBalance = w.Balance
 
-		if err := tx.Save(&w).Error; err != nil {
+		if err := saveWallet(tx, &w); err != nil {
+			return err
+		}
+		w.Version += slots.Version
+		change, err := newBalance.Sub(oldBalance)
+		if err != nil {
 			return err
 		}
-		return nil
+		return appendTransaction(tx, &w, AdjustOperation, change, oldBalance, nil)
 	})
 
 	if err != nil {
-		return 0, 0, nil, err
+		return models.Money{}, models.Money{}, nil, err
 	}
 	return oldBalance, newBalance, &w, nil
 }
@@ -101,97 +184,527 @@ func (r *RepositoryService) UpdateBalance(id uuid.UUID, balance float32) (
 func (r *RepositoryService) Delete(id uuid.UUID) error {
 	return r.db.Transaction(func(tx *gorm.DB) error {
 		var w models.WalletModel
-		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
-			First(&w, "id = ?", id).Error; err != nil {
-			if errors.Is(err, gorm.ErrRecordNotFound) {
-				return ErrWalletNotFound
-			}
+		if err := r.lock(tx, id, &w); err != nil {
+			return err
+		}
+		if err := tx.Where("wallet_id = ?", id).Delete(&models.BalanceSlotModel{}).Error; err != nil {
 			return err
 		}
-
 		return tx.Delete(&w).Error
 	})
 }
 
 func (r *RepositoryService) List() ([]models.WalletModel, error) {
 	var wallets []models.WalletModel
-	if err := r.db.Find(&wallets).Error; err != nil {
+	if err := r.db.Find(&wallets, "tenant_id = ?", r.tenantID).Error; err != nil {
+		return nil, err
+	}
+	if err := withSlotBalances(r.db, wallets); err != nil {
+		return nil, err
+	}
+	return wallets, nil
+}
+
+func (r *RepositoryService) ListByOwner(ownerID string) ([]models.WalletModel, error) {
+	var wallets []models.WalletModel
+	if err := r.db.Find(&wallets, "tenant_id = ? AND owner_id = ?", r.tenantID, ownerID).Error; err != nil {
+		return nil, err
+	}
+	if err := withSlotBalances(r.db, wallets); err != nil {
 		return nil, err
 	}
 	return wallets, nil
 }
 
-func (r *RepositoryService) Deposit(id uuid.UUID, amount float32) (
-	oldBalance float32,
-	newBalance float32,
+func (r *RepositoryService) Deposit(id uuid.UUID, amount models.Money, currency string, metadata map[string]string) (
+	oldBalance models.Money,
+	newBalance models.Money,
 	model *models.WalletModel,
 	err error,
 ) {
-	if amount < 0 {
-		return 0, 0, nil, ErrInvalidAmount
+	if amount.IsNegative() {
+		return models.Money{}, models.Money{}, nil, ErrInvalidAmount
 	}
 	var w models.WalletModel
 
 	err = r.db.Transaction(func(tx *gorm.DB) error {
-		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
-			First(&w, "id = ?", id).Error; err != nil {
-			if errors.Is(err, gorm.ErrRecordNotFound) {
+		// A slot deposit does not lock the wallet row, so it cannot check an
+		// expected version.
+		if r.slots > 0 && !r.expects(id) {
+			if err := tx.First(&w, "id = ? AND tenant_id = ?", id, r.tenantID).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					return ErrWalletNotFound
+				}
+				return err
+			}
+			if !r.owner.owns(&w) {
 				return ErrWalletNotFound
 			}
+			if w.BalanceSlots > 0 {
+				if w.Currency != currency {
+					return ErrCurrencyMismatch
+				}
+				var err error
+				oldBalance, newBalance, err = depositToSlot(tx, &w, amount, metadata)
+				return err
+			}
+		}
+		if r.atomic {
+			updated, err := r.updateAtomic(tx, id, amount, currency, false, &w)
+			if err != nil {
+				return err
+			}
+			if updated {
+				newBalance = w.Balance
+				if oldBalance, err = newBalance.Sub(amount); err != nil {
+					return err
+				}
+				return appendTransaction(tx, &w, DepositOperation, amount, oldBalance, metadata)
+			}
+		}
+		if err := r.lock(tx, id, &w); err != nil {
 			return err
 		}
-		oldBalance = w.Balance
-
-		w.Balance += amount
+		if w.Currency != currency {
+			return ErrCurrencyMismatch
+		}
+		slots, err := slotTotal(tx, &w)
+		if err != nil {
+			return err
+		}
+		var a arith
+		oldBalance = a.add(w.Balance, slots.Balance)
+		w.Balance = a.add(w.Balance, amount)
+		newBalance = a.add(oldBalance, amount)
+		if a.err != nil {
+			return a.err
+		}
 		w.UpdatedAt = time.Now()
 
-		newBalance = w.Balance
-
-		return tx.Save(&w).Error
+		if err := saveWallet(tx, &w); err != nil {
+			return err
+		}
+		w.Balance, w.Version = newBalance, w.Version+slots.Version
+		return appendTransaction(tx, &w, DepositOperation, amount, oldBalance, metadata)
 	})
 
 	if err != nil {
-		return 0, 0, nil, err
+		return models.Money{}, models.Money{}, nil, err
 	}
 	return oldBalance, newBalance, &w, nil
 }
 
-func (r *RepositoryService) Withdraw(id uuid.UUID, amount float32) (
-	oldBalance float32,
-	newBalance float32,
+func (r *RepositoryService) Withdraw(id uuid.UUID, amount models.Money, currency string, metadata map[string]string) (
+	oldBalance models.Money,
+	newBalance models.Money,
 	model *models.WalletModel,
 	err error,
 ) {
-	if amount < 0 {
-		return 0, 0, nil, ErrInvalidAmount
+	if amount.IsNegative() {
+		return models.Money{}, models.Money{}, nil, ErrInvalidAmount
 	}
 	var w models.WalletModel
 
 	err = r.db.Transaction(func(tx *gorm.DB) error {
-		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
-			First(&w, "id = ?", id).Error; err != nil {
-			if errors.Is(err, gorm.ErrRecordNotFound) {
-				return ErrWalletNotFound
+		if r.atomic {
+			updated, err := r.updateAtomic(tx, id, amount, currency, true, &w)
+			if err != nil {
+				return err
+			}
+			if updated {
+				newBalance = w.Balance
+				if oldBalance, err = newBalance.Add(amount); err != nil {
+					return err
+				}
+				return appendTransaction(tx, &w, WithdrawOperation, amount, oldBalance, metadata)
 			}
+		}
+		if err := r.lock(tx, id, &w); err != nil {
 			return err
 		}
 
-		if w.Balance < amount {
+		if w.Currency != currency {
+			return ErrCurrencyMismatch
+		}
+		now := time.Now()
+		if err := releaseExpiredHolds(tx, &w, now); err != nil {
+			return err
+		}
+		slots, err := coverFromSlots(tx, &w, amount)
+		if err != nil {
+			return err
+		}
+		if !w.Covers(amount) {
 			return ErrInsufficientFunds
 		}
 
-		oldBalance = w.Balance
+		var a arith
+		oldBalance = a.add(w.Balance, slots.Balance)
+		w.Balance = a.sub(w.Balance, amount)
+		newBalance = a.sub(oldBalance, amount)
+		if a.err != nil {
+			return a.err
+		}
+		w.UpdatedAt = now
 
-		w.Balance -= amount
-		w.UpdatedAt = time.Now()
+		if err := saveWallet(tx, &w); err != nil {
+			return err
+		}
+		w.Balance, w.Version = newBalance, w.Version+slots.Version
+		return appendTransaction(tx, &w, WithdrawOperation, amount, oldBalance, metadata)
+	})
 
-		newBalance = w.Balance
+	if err != nil {
+		return models.Money{}, models.Money{}, nil, err
+	}
+	return oldBalance, newBalance, &w, nil
+}
+
+// Idempotent runs fn at most once per key. The key is claimed, fn runs
+// against a repository bound to the same transaction and its response is
+// stored before commit, so the balance change and the stored response are
+// never observed separately. Retries with the same fingerprint get the stored
+// response back; a different fingerprint yields ErrIdempotencyKeyReused.
+func (r *RepositoryService) Idempotent(
+	key string,
+	fingerprint string,
+	fn func(repo WalletRepositoryService) ([]byte, error),
+) ([]byte, error) {
+	if resp, err := r.findIdempotent(key, fingerprint); !errors.Is(err, errIdempotencyKeyFree) {
+		return resp, err
+	}
+
+	var resp []byte
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		rec := &models.IdempotencyKeyModel{
+			TenantID:    r.tenantID,
+			Key:         key,
+			Fingerprint: fingerprint,
+			CreatedAt:   time.Now(),
+		}
+		if err := tx.Create(rec).Error; err != nil {
+			return err
+		}
+		var err error
+		bound := *r
+		bound.db = tx
+		if resp, err = fn(&bound); err != nil {
+			return err
+		}
+		return tx.Model(rec).Update("response", resp).Error
+	})
+	if err != nil {
+		// A concurrent request with the same key may have committed first.
+		if replay, findErr := r.findIdempotent(key, fingerprint); !errors.Is(findErr, errIdempotencyKeyFree) {
+			return replay, findErr
+		}
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Transaction runs fn against a repository bound to one database transaction,
+// committed if fn returns nil. Every change fn makes runs in its own
+// savepoint, so one that fails is undone alone.
+func (r *RepositoryService) Transaction(fn func(repo WalletRepositoryService) error) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		bound := *r
+		bound.db = tx
+		return fn(&bound)
+	})
+}
+
+func (r *RepositoryService) findIdempotent(key string, fingerprint string) ([]byte, error) {
+	var rec models.IdempotencyKeyModel
+	if err := r.db.First(&rec, map[string]any{"tenant_id": r.tenantID, "key": key}).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errIdempotencyKeyFree
+		}
+		return nil, err
+	}
+	if rec.Fingerprint != fingerprint {
+		return nil, ErrIdempotencyKeyReused
+	}
+	return rec.Response, nil
+}
 
-		return tx.Save(&w).Error
+// TransferResult describes both sides of a completed transfer.
+type TransferResult struct {
+	ID             uuid.UUID
+	From           *models.WalletModel
+	To             *models.WalletModel
+	FromOldBalance models.Money
+	FromNewBalance models.Money
+	ToOldBalance   models.Money
+	ToNewBalance   models.Money
+}
+
+// Transfer debits fromID and credits toID in a single transaction. Both rows
+// are locked in ascending id order so that concurrent transfers in opposite
+// directions cannot deadlock.
+func (r *RepositoryService) Transfer(
+	fromID uuid.UUID,
+	toID uuid.UUID,
+	amount models.Money,
+	currency string,
+	metadata map[string]string,
+) (*TransferResult, error) {
+	if amount.IsNegative() {
+		return nil, ErrInvalidAmount
+	}
+	if fromID == toID {
+		return nil, ErrSameWallet
+	}
+	var from, to models.WalletModel
+	res := &TransferResult{ID: uuid.New()}
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		first, second := &from, &to
+		firstID, secondID := fromID, toID
+		if bytes.Compare(toID[:], fromID[:]) < 0 {
+			first, second = &to, &from
+			firstID, secondID = toID, fromID
+		}
+		if err := r.lock(tx, firstID, first); err != nil {
+			return err
+		}
+		if err := r.lock(tx, secondID, second); err != nil {
+			return err
+		}
+
+		if from.Currency != currency || to.Currency != currency {
+			return ErrCurrencyMismatch
+		}
+		now := time.Now()
+		if err := releaseExpiredHolds(tx, &from, now); err != nil {
+			return err
+		}
+		fromSlots, err := coverFromSlots(tx, &from, amount)
+		if err != nil {
+			return err
+		}
+		if !from.Covers(amount) {
+			return ErrInsufficientFunds
+		}
+		toSlots, err := slotTotal(tx, &to)
+		if err != nil {
+			return err
+		}
+
+		var a arith
+		res.FromOldBalance, res.ToOldBalance = a.add(from.Balance, fromSlots.Balance), a.add(to.Balance, toSlots.Balance)
+		from.Balance = a.sub(from.Balance, amount)
+		to.Balance = a.add(to.Balance, amount)
+		res.FromNewBalance, res.ToNewBalance = a.sub(res.FromOldBalance, amount), a.add(res.ToOldBalance, amount)
+		if a.err != nil {
+			return a.err
+		}
+		from.UpdatedAt, to.UpdatedAt = now, now
+
+		if err := saveWallet(tx, &from); err != nil {
+			return err
+		}
+		if err := saveWallet(tx, &to); err != nil {
+			return err
+		}
+		from.Balance, to.Balance = res.FromNewBalance, res.ToNewBalance
+		from.Version, to.Version = from.Version+fromSlots.Version, to.Version+toSlots.Version
+		if err := appendTransaction(tx, &from, TransferOperation, amount, res.FromOldBalance,
+			transferMetadata(metadata, res.ID, to.ID, "out")); err != nil {
+			return err
+		}
+		return appendTransaction(tx, &to, TransferOperation, amount, res.ToOldBalance,
+			transferMetadata(metadata, res.ID, from.ID, "in"))
 	})
 
 	if err != nil {
-		return 0, 0, nil, err
+		return nil, err
 	}
-	return oldBalance, newBalance, &w, nil
+	res.From, res.To = &from, &to
+	return res, nil
+}
+
+// transferMetadata copies the caller's metadata and adds the attributes that
+// link both ledger entries of a transfer.
+func transferMetadata(metadata map[string]string, transferID uuid.UUID, counterparty uuid.UUID, direction string) map[string]string {
+	m := make(map[string]string, len(metadata)+3)
+	for k, v := range metadata {
+		m[k] = v
+	}
+	m["transferId"] = transferID.String()
+	m["counterpartyWalletId"] = counterparty.String()
+	m["direction"] = direction
+	return m
+}
+
+// lockWallet loads the tenant's wallet row into w holding a row lock until tx
+// ends. The time spent waiting for the lock is recorded on its own span.
+func lockWallet(tx *gorm.DB, tenantID string, id uuid.UUID, w *models.WalletModel) error {
+	ctx, span := tracer.Start(tx.Statement.Context, "RepositoryService.lockWallet",
+		trace.WithAttributes(walletIDAttr(id)))
+	defer span.End()
+
+	start := time.Now()
+	err := tx.WithContext(ctx).Clauses(clause.Locking{Strength: "UPDATE"}).
+		First(w, "id = ? AND tenant_id = ?", id, tenantID).Error
+	wait := time.Since(start)
+	lockWaitDuration.Observe(wait.Seconds())
+	span.SetAttributes(LockWaitTimeKey.Float64(float64(wait.Microseconds()) / 1000))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrWalletNotFound
+		}
+		span.RecordError(err)
+		return err
+	}
+	return nil
+}
+
+// appendTransaction records a ledger entry for a balance change that has
+// already been applied to w within tx.
+func appendTransaction(
+	tx *gorm.DB,
+	w *models.WalletModel,
+	op WalletOperation,
+	amount models.Money,
+	balanceBefore models.Money,
+	metadata map[string]string,
+) error {
+	return tx.Create(&models.TransactionModel{
+		ID:            uuid.New(),
+		TenantID:      w.TenantID,
+		WalletID:      w.ID,
+		OperationType: string(op),
+		Amount:        amount,
+		BalanceBefore: balanceBefore,
+		BalanceAfter:  w.Balance,
+		Currency:      w.Currency,
+		Metadata:      metadata,
+		// SQLite compares created_at as text, which orders only while
+		// every entry carries the same offset.
+		CreatedAt: w.UpdatedAt.UTC(),
+	}).Error
+}
+
+// TransactionFilter narrows a wallet's ledger. Nil and empty fields match
+// everything; From is inclusive and To is exclusive.
+type TransactionFilter struct {
+	OperationTypes []WalletOperation
+	MinAmount      *models.Money
+	MaxAmount      *models.Money
+	From           *time.Time
+	To             *time.Time
+	After          *TransactionCursor
+	Limit          int
+}
+
+// ListTransactions returns ledger entries newest first, ordered by creation
+// time and then id so that pagination is stable for equal timestamps.
+//
+// SQLite stores amounts as text and can only compare them as REAL, which
+// cannot tell apart amounts that differ past the 15th digit. There the query
+// lets through the amounts near the bounds and the exact comparison drops
+// them, reading on past the last entry until Limit entries match.
+func (r *RepositoryService) ListTransactions(walletID uuid.UUID, filter TransactionFilter) ([]models.TransactionModel, error) {
+	exact := r.db.Dialector.Name() == "postgres"
+	if exact || (filter.MinAmount == nil && filter.MaxAmount == nil) {
+		return r.findTransactions(walletID, filter, exact)
+	}
+	var entries []models.TransactionModel
+	page := filter
+	for {
+		found, err := r.findTransactions(walletID, page, false)
+		if err != nil {
+			return nil, err
+		}
+		for i := range found {
+			if matchTransaction(&found[i], &filter) {
+				entries = append(entries, found[i])
+			}
+		}
+		if filter.Limit > 0 && len(entries) >= filter.Limit {
+			return entries[:filter.Limit], nil
+		}
+		if filter.Limit <= 0 || len(found) < page.Limit {
+			return entries, nil
+		}
+		last := NewTransactionCursor(&found[len(found)-1])
+		page.After = &last
+	}
+}
+
+// findTransactions runs the ledger query for filter. Unless exact, amounts
+// are compared as REAL against bounds widened well past its rounding, so the
+// query returns every entry in range and possibly a few just outside it.
+func (r *RepositoryService) findTransactions(walletID uuid.UUID, filter TransactionFilter, exact bool) ([]models.TransactionModel, error) {
+	q := r.db.Where("tenant_id = ? AND wallet_id = ?", r.tenantID, walletID)
+	if len(filter.OperationTypes) > 0 {
+		q = q.Where("operation_type IN ?", filter.OperationTypes)
+	}
+	switch {
+	case exact:
+		if filter.MinAmount != nil {
+			q = q.Where("CAST(amount AS NUMERIC) >= ?", *filter.MinAmount)
+		}
+		if filter.MaxAmount != nil {
+			q = q.Where("CAST(amount AS NUMERIC) <= ?", *filter.MaxAmount)
+		}
+	default:
+		if filter.MinAmount != nil {
+			q = q.Where("CAST(amount AS REAL) >= ?", approxBound(*filter.MinAmount, -1))
+		}
+		if filter.MaxAmount != nil {
+			q = q.Where("CAST(amount AS REAL) <= ?", approxBound(*filter.MaxAmount, 1))
+		}
+	}
+	// Timestamps are written in UTC; bounds in another zone would compare
+	// wrongly as SQLite text.
+	if filter.From != nil {
+		q = q.Where("created_at >= ?", filter.From.UTC())
+	}
+	if filter.To != nil {
+		q = q.Where("created_at < ?", filter.To.UTC())
+	}
+	if filter.After != nil {
+		after := filter.After.CreatedAt.UTC()
+		q = q.Where("created_at < ? OR (created_at = ? AND id < ?)", after, after, filter.After.ID)
+	}
+	if filter.Limit > 0 {
+		q = q.Limit(filter.Limit)
+	}
+
+	var entries []models.TransactionModel
+	if err := q.Order("created_at DESC").Order("id DESC").Find(&entries).Error; err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// approxBound is m as a float64, lowered (dir -1) or raised (dir 1) by far
+// more than the rounding of a decimal to REAL.
+func approxBound(m models.Money, dir float64) float64 {
+	f, _ := strconv.ParseFloat(m.String(), 64)
+	return f + dir*math.Abs(f)*1e-9
+}
+
+// arith does Money arithmetic and keeps the first error, so a run of sums can
+// be checked once at its end.
+type arith struct {
+	err error
+}
+
+func (a *arith) add(x, y models.Money) models.Money {
+	sum, err := x.Add(y)
+	if a.err == nil {
+		a.err = err
+	}
+	return sum
+}
+
+func (a *arith) sub(x, y models.Money) models.Money {
+	diff, err := x.Sub(y)
+	if a.err == nil {
+		a.err = err
+	}
+	return diff
 }
@@ -3,11 +3,15 @@
 package app_test
 
 import (
+	"context"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/ichigo7diabol/go-test-wallet/internal/app"
 	"github.com/ichigo7diabol/go-test-wallet/internal/models"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
@@ -15,10 +19,33 @@ import (
 // MockWalletRepository — мок реализация интерфейса WalletRepositoryService
 type MockWalletRepository struct {
 	mock.Mock
+	// tenantID and ctx are what the service last scoped the repository to.
+	tenantID string
+	ctx      context.Context
 }
 
-func (m *MockWalletRepository) Create(initialBalance float32) (*models.WalletModel, error) {
-	args := m.Called(initialBalance)
+func (m *MockWalletRepository) ForTenant(tenantID string) app.WalletRepositoryService {
+	m.tenantID = tenantID
+	return m
+}
+
+func (m *MockWalletRepository) WithContext(ctx context.Context) app.WalletRepositoryService {
+	m.ctx = ctx
+	return m
+}
+
+func (m *MockWalletRepository) ExpectVersion(id uuid.UUID, version int64) app.WalletRepositoryService {
+	m.Called(id, version)
+	return m
+}
+
+func (m *MockWalletRepository) ExpectOwner(id uuid.UUID, ownerID string) app.WalletRepositoryService {
+	m.Called(id, ownerID)
+	return m
+}
+
+func (m *MockWalletRepository) Create(initialBalance models.Money, currency string, ownerID string) (*models.WalletModel, error) {
+	args := m.Called(initialBalance, currency, ownerID)
 	if model, ok := args.Get(0).(*models.WalletModel); ok {
 		return model, args.Error(1)
 	}
@@ -33,12 +60,12 @@ func (m *MockWalletRepository) GetByID(id uuid.UUID) (*models.WalletModel, error
 	return nil, args.Error(1)
 }
 
-func (m *MockWalletRepository) UpdateBalance(id uuid.UUID, balance float32) (float32, float32, *models.WalletModel, error) {
+func (m *MockWalletRepository) UpdateBalance(id uuid.UUID, balance models.Money) (models.Money, models.Money, *models.WalletModel, error) {
 	args := m.Called(id, balance)
 	if model, ok := args.Get(2).(*models.WalletModel); ok {
-		return args.Get(0).(float32), args.Get(1).(float32), model, args.Error(3)
+		return args.Get(0).(models.Money), args.Get(1).(models.Money), model, args.Error(3)
 	}
-	return args.Get(0).(float32), args.Get(1).(float32), nil, args.Error(3)
+	return args.Get(0).(models.Money), args.Get(1).(models.Money), nil, args.Error(3)
 }
 
 func (m *MockWalletRepository) Delete(id uuid.UUID) error {
@@ -54,30 +81,115 @@ func (m *MockWalletRepository) List() ([]models.WalletModel, error) {
 	return nil, args.Error(1)
 }
 
-func (m *MockWalletRepository) Deposit(id uuid.UUID, amount float32) (float32, float32, *models.WalletModel, error) {
-	args := m.Called(id, amount)
+func (m *MockWalletRepository) ListByOwner(ownerID string) ([]models.WalletModel, error) {
+	args := m.Called(ownerID)
+	if list, ok := args.Get(0).([]models.WalletModel); ok {
+		return list, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockWalletRepository) Deposit(id uuid.UUID, amount models.Money, currency string, metadata map[string]string) (models.Money, models.Money, *models.WalletModel, error) {
+	args := m.Called(id, amount, currency, metadata)
 	if model, ok := args.Get(2).(*models.WalletModel); ok {
-		return args.Get(0).(float32), args.Get(1).(float32), model, args.Error(3)
+		return args.Get(0).(models.Money), args.Get(1).(models.Money), model, args.Error(3)
 	}
-	return args.Get(0).(float32), args.Get(1).(float32), nil, args.Error(3)
+	return args.Get(0).(models.Money), args.Get(1).(models.Money), nil, args.Error(3)
 }
 
-func (m *MockWalletRepository) Withdraw(id uuid.UUID, amount float32) (float32, float32, *models.WalletModel, error) {
-	args := m.Called(id, amount)
+func (m *MockWalletRepository) Withdraw(id uuid.UUID, amount models.Money, currency string, metadata map[string]string) (models.Money, models.Money, *models.WalletModel, error) {
+	args := m.Called(id, amount, currency, metadata)
 	if model, ok := args.Get(2).(*models.WalletModel); ok {
-		return args.Get(0).(float32), args.Get(1).(float32), model, args.Error(3)
+		return args.Get(0).(models.Money), args.Get(1).(models.Money), model, args.Error(3)
+	}
+	return args.Get(0).(models.Money), args.Get(1).(models.Money), nil, args.Error(3)
+}
+
+func (m *MockWalletRepository) ListTransactions(walletID uuid.UUID, filter app.TransactionFilter) ([]models.TransactionModel, error) {
+	args := m.Called(walletID, filter)
+	if list, ok := args.Get(0).([]models.TransactionModel); ok {
+		return list, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockWalletRepository) Transfer(fromID uuid.UUID, toID uuid.UUID, amount models.Money, currency string, metadata map[string]string) (*app.TransferResult, error) {
+	args := m.Called(fromID, toID, amount, currency, metadata)
+	if res, ok := args.Get(0).(*app.TransferResult); ok {
+		return res, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockWalletRepository) Authorize(walletID uuid.UUID, amount models.Money, currency string, expiresAt time.Time, metadata map[string]string) (*models.HoldModel, error) {
+	args := m.Called(walletID, amount, currency, expiresAt, metadata)
+	if h, ok := args.Get(0).(*models.HoldModel); ok {
+		return h, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockWalletRepository) GetHold(id uuid.UUID) (*models.HoldModel, error) {
+	args := m.Called(id)
+	if h, ok := args.Get(0).(*models.HoldModel); ok {
+		return h, args.Error(1)
 	}
-	return args.Get(0).(float32), args.Get(1).(float32), nil, args.Error(3)
+	return nil, args.Error(1)
+}
+
+func (m *MockWalletRepository) Capture(id uuid.UUID, amount *models.Money) (*models.HoldModel, models.Money, models.Money, error) {
+	args := m.Called(id, amount)
+	if h, ok := args.Get(0).(*models.HoldModel); ok {
+		return h, args.Get(1).(models.Money), args.Get(2).(models.Money), args.Error(3)
+	}
+	return nil, models.Money{}, models.Money{}, args.Error(3)
 }
 
+func (m *MockWalletRepository) Void(id uuid.UUID) (*models.HoldModel, error) {
+	args := m.Called(id)
+	if h, ok := args.Get(0).(*models.HoldModel); ok {
+		return h, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockWalletRepository) ExpireHolds(now time.Time) (int, error) {
+	args := m.Called(now)
+	return args.Int(0), args.Error(1)
+}
+
+// Idempotent replays the configured response; without one it behaves like a
+// first call and runs fn against the mock itself.
+func (m *MockWalletRepository) Idempotent(key string, fingerprint string, fn func(repo app.WalletRepositoryService) ([]byte, error)) ([]byte, error) {
+	args := m.Called(key, fingerprint, fn)
+	if resp, ok := args.Get(0).([]byte); ok {
+		return resp, args.Error(1)
+	}
+	if err := args.Error(1); err != nil {
+		return nil, err
+	}
+	return fn(m)
+}
+
+// Transaction runs fn against the mock itself.
+func (m *MockWalletRepository) Transaction(fn func(repo app.WalletRepositoryService) error) error {
+	return fn(m)
+}
+
+var (
+	owner    = &app.Principal{Subject: "user-1"}
+	stranger = &app.Principal{Subject: "user-2"}
+	admin    = &app.Principal{Subject: "ops", Scopes: []string{app.AdminScope}}
+)
+
 func TestCreateWallet(t *testing.T) {
 	repo := new(MockWalletRepository)
-	service := app.NewWalletService(repo)
+	service := app.NewWalletService(repo, app.WalletServiceOptions{DefaultCurrency: "USD", HoldTTL: time.Hour})
 
-	expected := &models.WalletModel{Balance: 100}
-	repo.On("Create", float32(100)).Return(expected, nil)
+	expected := &models.WalletModel{Balance: models.MoneyFromInt(100)}
+	repo.On("Create", models.MoneyFromInt(100), "USD", "user-1").Return(expected, nil)
 
-	wallet, err := service.CreateWallet(100)
+	wallet, err := service.CreateWallet(context.Background(), owner, models.MoneyFromInt(100), "", nil)
 
 	assert.NoError(t, err)
 	assert.Equal(t, expected, wallet)
@@ -86,61 +198,121 @@ func TestCreateWallet(t *testing.T) {
 
 func TestGetWallet(t *testing.T) {
 	repo := new(MockWalletRepository)
-	service := app.NewWalletService(repo)
+	service := app.NewWalletService(repo, app.WalletServiceOptions{DefaultCurrency: "USD", HoldTTL: time.Hour})
 	id := uuid.New()
 
-	expected := &models.WalletModel{ID: id, Balance: 50}
+	expected := &models.WalletModel{ID: id, Balance: models.MoneyFromInt(50), OwnerID: "user-1"}
 	repo.On("GetByID", id).Return(expected, nil)
 
-	wallet, err := service.GetWallet(id)
+	wallet, err := service.GetWallet(context.Background(), owner, id)
 
 	assert.NoError(t, err)
 	assert.Equal(t, expected, wallet)
 	repo.AssertExpectations(t)
 }
 
+func TestGetWallet_NotOwner(t *testing.T) {
+	repo := new(MockWalletRepository)
+	service := app.NewWalletService(repo, app.WalletServiceOptions{DefaultCurrency: "USD", HoldTTL: time.Hour})
+	id := uuid.New()
+
+	repo.On("GetByID", id).Return(&models.WalletModel{ID: id, OwnerID: "user-1"}, nil)
+
+	_, err := service.GetWallet(context.Background(), stranger, id)
+	assert.ErrorIs(t, err, app.ErrWalletNotFound)
+
+	wallet, err := service.GetWallet(context.Background(), admin, id)
+	assert.NoError(t, err)
+	assert.Equal(t, id, wallet.ID)
+}
+
 func TestDeleteWallet(t *testing.T) {
 	repo := new(MockWalletRepository)
-	service := app.NewWalletService(repo)
+	service := app.NewWalletService(repo, app.WalletServiceOptions{DefaultCurrency: "USD", HoldTTL: time.Hour})
+	id := uuid.New()
+
+	repo.On("GetByID", id).Return(&models.WalletModel{ID: id, OwnerID: "user-1"}, nil)
+	repo.On("Delete", id).Return(nil)
+
+	err := service.DeleteWallet(context.Background(), owner, id, nil)
+
+	assert.NoError(t, err)
+	repo.AssertExpectations(t)
+}
+
+func TestDeleteWallet_ExpectedVersion(t *testing.T) {
+	repo := new(MockWalletRepository)
+	service := app.NewWalletService(repo, app.WalletServiceOptions{DefaultCurrency: "USD", HoldTTL: time.Hour})
 	id := uuid.New()
+	version := int64(7)
 
+	repo.On("GetByID", id).Return(&models.WalletModel{ID: id, OwnerID: "user-1"}, nil)
+	repo.On("ExpectVersion", id, version).Return()
 	repo.On("Delete", id).Return(nil)
 
-	err := service.DeleteWallet(id)
+	err := service.DeleteWallet(context.Background(), owner, id, &version)
 
 	assert.NoError(t, err)
 	repo.AssertExpectations(t)
 }
 
+func TestDeleteWallet_NotOwner(t *testing.T) {
+	repo := new(MockWalletRepository)
+	service := app.NewWalletService(repo, app.WalletServiceOptions{DefaultCurrency: "USD", HoldTTL: time.Hour})
+	id := uuid.New()
+
+	repo.On("GetByID", id).Return(&models.WalletModel{ID: id, OwnerID: "user-1"}, nil)
+
+	err := service.DeleteWallet(context.Background(), stranger, id, nil)
+
+	assert.ErrorIs(t, err, app.ErrWalletNotFound)
+	repo.AssertNotCalled(t, "Delete", mock.Anything)
+}
+
 func TestListWallets(t *testing.T) {
 	repo := new(MockWalletRepository)
-	service := app.NewWalletService(repo)
+	service := app.NewWalletService(repo, app.WalletServiceOptions{DefaultCurrency: "USD", HoldTTL: time.Hour})
 
 	list := []models.WalletModel{
-		{Balance: 10},
-		{Balance: 20},
+		{Balance: models.MoneyFromInt(10)},
+		{Balance: models.MoneyFromInt(20)},
 	}
-	repo.On("List").Return(list, nil)
+	repo.On("ListByOwner", "user-1").Return(list, nil)
 
-	result, err := service.ListWallets()
+	result, err := service.ListWallets(context.Background(), owner)
 
 	assert.NoError(t, err)
 	assert.Equal(t, list, result)
 	repo.AssertExpectations(t)
 }
 
+func TestListWallets_Admin(t *testing.T) {
+	repo := new(MockWalletRepository)
+	service := app.NewWalletService(repo, app.WalletServiceOptions{DefaultCurrency: "USD", HoldTTL: time.Hour})
+
+	list := []models.WalletModel{{OwnerID: "user-1"}, {OwnerID: "user-2"}}
+	repo.On("List").Return(list, nil)
+
+	result, err := service.ListWallets(context.Background(), admin)
+
+	assert.NoError(t, err)
+	assert.Equal(t, list, result)
+	repo.AssertNotCalled(t, "ListByOwner", mock.Anything)
+}
+
 func TestChangeBalance_Deposit(t *testing.T) {
 	repo := new(MockWalletRepository)
-	service := app.NewWalletService(repo)
+	service := app.NewWalletService(repo, app.WalletServiceOptions{DefaultCurrency: "USD", HoldTTL: time.Hour})
 	id := uuid.New()
 
-	old := float32(50)
-	new := float32(100)
+	old := models.MoneyFromInt(50)
+	new := models.MoneyFromInt(100)
 	wallet := &models.WalletModel{ID: id, Balance: new}
 
-	repo.On("Withdraw", id, float32(50)).Return(old, new, wallet, nil)
+	repo.On("ExpectOwner", id, "user-1").Return()
+	repo.On("Deposit", id, models.MoneyFromInt(50), "EUR", map[string]string{"orderId": "A-1"}).Return(old, new, wallet, nil)
 
-	oldBalance, newBalance, model, err := service.ChangeBalance(id, app.DepositOperation, 50)
+	oldBalance, newBalance, model, err := service.ChangeBalance(context.Background(), owner, id, app.DepositOperation, models.MoneyFromInt(50), "eur", map[string]string{"orderId": "A-1"}, nil, nil)
 
 	assert.NoError(t, err)
 	assert.Equal(t, old, oldBalance)
@@ -149,18 +321,35 @@ func TestChangeBalance_Deposit(t *testing.T) {
 	repo.AssertExpectations(t)
 }
 
+func TestChangeBalance_AdminSkipsOwnerCheck(t *testing.T) {
+	repo := new(MockWalletRepository)
+	service := app.NewWalletService(repo, app.WalletServiceOptions{DefaultCurrency: "USD", HoldTTL: time.Hour})
+	id := uuid.New()
+
+	repo.On("Deposit", id, models.MoneyFromInt(5), "USD", map[string]string(nil)).
+		Return(models.MoneyFromInt(0), models.MoneyFromInt(5), &models.WalletModel{ID: id}, nil)
+
+	_, _, _, err := service.ChangeBalance(context.Background(), admin, id, app.DepositOperation, models.MoneyFromInt(5), "USD", nil, nil, nil)
+
+	assert.NoError(t, err)
+	repo.AssertExpectations(t)
+	repo.AssertNotCalled(t, "ExpectOwner", mock.Anything, mock.Anything)
+	repo.AssertNotCalled(t, "GetByID", mock.Anything)
+}
+
 func TestChangeBalance_Withdraw(t *testing.T) {
 	repo := new(MockWalletRepository)
-	service := app.NewWalletService(repo)
+	service := app.NewWalletService(repo, app.WalletServiceOptions{DefaultCurrency: "USD", HoldTTL: time.Hour})
 	id := uuid.New()
 
-	old := float32(100)
-	new := float32(50)
+	old := models.MoneyFromInt(100)
+	new := models.MoneyFromInt(50)
 	wallet := &models.WalletModel{ID: id, Balance: new}
 
-	repo.On("Deposit", id, float32(50)).Return(old, new, wallet, nil)
+	repo.On("ExpectOwner", id, "user-1").Return()
+	repo.On("Withdraw", id, models.MoneyFromInt(50), "EUR", map[string]string(nil)).Return(old, new, wallet, nil)
 
-	oldBalance, newBalance, model, err := service.ChangeBalance(id, app.WithdrawOperation, 50)
+	oldBalance, newBalance, model, err := service.ChangeBalance(context.Background(), owner, id, app.WithdrawOperation, models.MoneyFromInt(50), "EUR", nil, nil, nil)
 
 	assert.NoError(t, err)
 	assert.Equal(t, old, oldBalance)
@@ -169,12 +358,433 @@ func TestChangeBalance_Withdraw(t *testing.T) {
 	repo.AssertExpectations(t)
 }
 
+func TestChangeBalance_ExpectedVersion(t *testing.T) {
+	repo := new(MockWalletRepository)
+	service := app.NewWalletService(repo, app.WalletServiceOptions{DefaultCurrency: "USD", HoldTTL: time.Hour})
+	id := uuid.New()
+	version := int64(3)
+
+	repo.On("ExpectOwner", id, "user-1").Return()
+	repo.On("ExpectVersion", id, version).Return()
+	repo.On("Withdraw", id, models.MoneyFromInt(50), "USD", map[string]string(nil)).
+		Return(models.Money{}, models.Money{}, nil, app.ErrVersionConflict)
+
+	_, _, _, err := service.ChangeBalance(context.Background(), owner, id, app.WithdrawOperation, models.MoneyFromInt(50), "USD", nil, &version, nil)
+
+	assert.ErrorIs(t, err, app.ErrVersionConflict)
+	repo.AssertExpectations(t)
+}
+
+// counterValue reads a counter from the default registry, 0 if absent.
+func counterValue(t *testing.T, name string, labels map[string]string) float64 {
+	families, err := prometheus.DefaultGatherer.Gather()
+	assert.NoError(t, err)
+	for _, f := range families {
+		if f.GetName() != name {
+			continue
+		}
+	metrics:
+		for _, m := range f.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if labels[l.GetName()] != l.GetValue() {
+					continue metrics
+				}
+			}
+			return m.GetCounter().GetValue()
+		}
+	}
+	return 0
+}
+
+func TestChangeBalance_Metrics(t *testing.T) {
+	repo := new(MockWalletRepository)
+	service := app.NewWalletService(repo, app.WalletServiceOptions{DefaultCurrency: "USD", HoldTTL: time.Hour})
+	id := uuid.New()
+	failed := map[string]string{"operation": "WITHDRAW", "outcome": app.OutcomeInsufficientFunds}
+	canceled := map[string]string{"operation": "WITHDRAW", "outcome": app.OutcomeCanceled}
+	errored := map[string]string{"operation": "WITHDRAW", "outcome": app.OutcomeError}
+	moved := map[string]string{"operation": "WITHDRAW", "currency": "GBP"}
+	failedBefore := counterValue(t, "wallet_operations_total", failed)
+	canceledBefore := counterValue(t, "wallet_operations_total", canceled)
+	erroredBefore := counterValue(t, "wallet_operations_total", errored)
+	movedBefore := counterValue(t, "wallet_money_moved_total", moved)
+
+	repo.On("ExpectOwner", id, "user-1").Return()
+	repo.On("Withdraw", id, models.MoneyFromInt(500), "GBP", map[string]string(nil)).
+		Return(models.Money{}, models.Money{}, nil, app.ErrInsufficientFunds)
+	repo.On("Withdraw", id, models.MustParseMoney("2.5"), "GBP", map[string]string(nil)).
+		Return(models.MoneyFromInt(10), models.MustParseMoney("7.5"), &models.WalletModel{ID: id}, nil)
+	repo.On("Withdraw", id, models.MoneyFromInt(3), "GBP", map[string]string(nil)).
+		Return(models.Money{}, models.Money{}, nil, fmt.Errorf("select: %w", context.Canceled))
+
+	_, _, _, err := service.ChangeBalance(context.Background(), owner, id, app.WithdrawOperation, models.MoneyFromInt(500), "GBP", nil, nil, nil)
+	assert.ErrorIs(t, err, app.ErrInsufficientFunds)
+	_, _, _, err = service.ChangeBalance(context.Background(), owner, id, app.WithdrawOperation, models.MustParseMoney("2.5"), "GBP", nil, nil, nil)
+	assert.NoError(t, err)
+	// A client that went away is not a failure of the service.
+	_, _, _, err = service.ChangeBalance(context.Background(), owner, id, app.WithdrawOperation, models.MoneyFromInt(3), "GBP", nil, nil, nil)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	assert.Equal(t, failedBefore+1, counterValue(t, "wallet_operations_total", failed))
+	assert.Equal(t, canceledBefore+1, counterValue(t, "wallet_operations_total", canceled))
+	assert.Equal(t, erroredBefore, counterValue(t, "wallet_operations_total", errored))
+	assert.Equal(t, movedBefore+2.5, counterValue(t, "wallet_money_moved_total", moved))
+}
+
 func TestChangeBalance_UnknownOperation(t *testing.T) {
 	repo := new(MockWalletRepository)
-	service := app.NewWalletService(repo)
+	service := app.NewWalletService(repo, app.WalletServiceOptions{DefaultCurrency: "USD", HoldTTL: time.Hour})
 	id := uuid.New()
 
-	_, _, _, err := service.ChangeBalance(id, "INVALID_OP", 100)
+	_, _, _, err := service.ChangeBalance(context.Background(), owner, id, "INVALID_OP", models.MoneyFromInt(100), "USD", nil, nil, nil)
 
 	assert.ErrorIs(t, err, app.ErrUnknownOperation)
 }
+
+func TestChangeBalance_AmountScale(t *testing.T) {
+	repo := new(MockWalletRepository)
+	service := app.NewWalletService(repo, app.WalletServiceOptions{DefaultCurrency: "USD", HoldTTL: time.Hour})
+	id := uuid.New()
+
+	_, _, _, err := service.ChangeBalance(context.Background(), owner, id, app.DepositOperation, models.MustParseMoney("0.001"), "USD", nil, nil, nil)
+	assert.ErrorIs(t, err, app.ErrAmountScale)
+
+	_, _, _, err = service.ChangeBalance(context.Background(), owner, id, app.DepositOperation, models.MustParseMoney("0.5"), "JPY", nil, nil, nil)
+	assert.ErrorIs(t, err, app.ErrAmountScale)
+
+	repo.AssertNotCalled(t, "Deposit", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestChangeBalance_AmountTooLarge(t *testing.T) {
+	repo := new(MockWalletRepository)
+	service := app.NewWalletService(repo, app.WalletServiceOptions{DefaultCurrency: "USD", HoldTTL: time.Hour})
+	id := uuid.New()
+	tooLarge := models.MustParseMoney("1000000000000000000.01")
+
+	_, _, _, err := service.ChangeBalance(context.Background(), owner, id, app.DepositOperation, tooLarge, "USD", nil, nil, nil)
+	assert.ErrorIs(t, err, app.ErrAmountTooLarge)
+	_, err = service.Transfer(context.Background(), owner, id, uuid.New(), tooLarge, "USD", nil, nil, nil)
+	assert.ErrorIs(t, err, app.ErrAmountTooLarge)
+	_, err = service.CreateWallet(context.Background(), owner, tooLarge, "USD", nil)
+	assert.ErrorIs(t, err, app.ErrAmountTooLarge)
+
+	repo.AssertNotCalled(t, "Deposit", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	repo.AssertNotCalled(t, "Transfer", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	repo.AssertNotCalled(t, "Create", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestChangeBalance_UnknownCurrency(t *testing.T) {
+	repo := new(MockWalletRepository)
+	service := app.NewWalletService(repo, app.WalletServiceOptions{DefaultCurrency: "USD", HoldTTL: time.Hour})
+	id := uuid.New()
+
+	_, _, _, err := service.ChangeBalance(context.Background(), owner, id, app.DepositOperation, models.MoneyFromInt(1), "XXX", nil, nil, nil)
+
+	assert.ErrorIs(t, err, app.ErrUnknownCurrency)
+	repo.AssertNotCalled(t, "Deposit", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestCreateWallet_UnknownCurrency(t *testing.T) {
+	repo := new(MockWalletRepository)
+	service := app.NewWalletService(repo, app.WalletServiceOptions{DefaultCurrency: "USD", HoldTTL: time.Hour})
+
+	_, err := service.CreateWallet(context.Background(), owner, models.MoneyFromInt(1), "ABC", nil)
+
+	assert.ErrorIs(t, err, app.ErrUnknownCurrency)
+	repo.AssertNotCalled(t, "Create", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestChangeBalance_IdempotentReplay(t *testing.T) {
+	repo := new(MockWalletRepository)
+	service := app.NewWalletService(repo, app.WalletServiceOptions{DefaultCurrency: "USD", HoldTTL: time.Hour})
+	id := uuid.New()
+
+	stored := `{"OldBalance":"10","NewBalance":"15.5","Wallet":{"ID":"` + id.String() + `","Balance":"15.5","Currency":"USD"}}`
+	repo.On("ExpectOwner", id, "user-1").Return()
+	repo.On("Idempotent", "key-1", "fp-1", mock.Anything).Return([]byte(stored), nil)
+
+	oldBalance, newBalance, model, err := service.ChangeBalance(context.Background(), owner, id, app.DepositOperation, models.MustParseMoney("5.5"), "USD", nil, nil,
+		&app.Idempotency{Key: "key-1", Fingerprint: "fp-1"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, models.MoneyFromInt(10), oldBalance)
+	assert.Equal(t, models.MustParseMoney("15.5"), newBalance)
+	assert.Equal(t, id, model.ID)
+	repo.AssertNotCalled(t, "Deposit", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestChangeBalance_IdempotentFirstCall(t *testing.T) {
+	repo := new(MockWalletRepository)
+	service := app.NewWalletService(repo, app.WalletServiceOptions{DefaultCurrency: "USD", HoldTTL: time.Hour})
+	id := uuid.New()
+	wallet := &models.WalletModel{ID: id, Balance: models.MoneyFromInt(15)}
+
+	repo.On("Deposit", id, models.MoneyFromInt(5), "USD", map[string]string(nil)).
+		Return(models.MoneyFromInt(10), models.MoneyFromInt(15), wallet, nil)
+	repo.On("ExpectOwner", id, "user-1").Return()
+	repo.On("Idempotent", "key-1", "fp-1", mock.Anything).Return(nil, nil)
+
+	oldBalance, newBalance, model, err := service.ChangeBalance(context.Background(), owner, id, app.DepositOperation, models.MoneyFromInt(5), "USD", nil, nil,
+		&app.Idempotency{Key: "key-1", Fingerprint: "fp-1"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, models.MoneyFromInt(10), oldBalance)
+	assert.Equal(t, models.MoneyFromInt(15), newBalance)
+	assert.Equal(t, id, model.ID)
+	repo.AssertExpectations(t)
+}
+
+func TestChangeBalance_InvalidIdempotencyKey(t *testing.T) {
+	repo := new(MockWalletRepository)
+	service := app.NewWalletService(repo, app.WalletServiceOptions{DefaultCurrency: "USD", HoldTTL: time.Hour})
+	id := uuid.New()
+
+	repo.On("ExpectOwner", id, "user-1").Return()
+
+	_, _, _, err := service.ChangeBalance(context.Background(), owner, id, app.DepositOperation, models.MoneyFromInt(5), "USD", nil, nil,
+		&app.Idempotency{Key: "", Fingerprint: "fp-1"})
+
+	assert.ErrorIs(t, err, app.ErrInvalidIdempotencyKey)
+}
+
+func TestTransfer(t *testing.T) {
+	repo := new(MockWalletRepository)
+	service := app.NewWalletService(repo, app.WalletServiceOptions{DefaultCurrency: "USD", HoldTTL: time.Hour})
+	from, to := uuid.New(), uuid.New()
+
+	expected := &app.TransferResult{ID: uuid.New()}
+	repo.On("GetByID", from).Return(&models.WalletModel{ID: from, OwnerID: "user-1"}, nil)
+	repo.On("Transfer", from, to, models.MoneyFromInt(10), "USD", map[string]string(nil)).Return(expected, nil)
+
+	res, err := service.Transfer(context.Background(), owner, from, to, models.MoneyFromInt(10), "usd", nil, nil, nil)
+
+	assert.NoError(t, err)
+	assert.Equal(t, expected, res)
+	repo.AssertExpectations(t)
+}
+
+func TestTransfer_NotOwner(t *testing.T) {
+	repo := new(MockWalletRepository)
+	service := app.NewWalletService(repo, app.WalletServiceOptions{DefaultCurrency: "USD", HoldTTL: time.Hour})
+	from, to := uuid.New(), uuid.New()
+
+	repo.On("GetByID", from).Return(&models.WalletModel{ID: from, OwnerID: "user-1"}, nil)
+
+	_, err := service.Transfer(context.Background(), stranger, from, to, models.MoneyFromInt(10), "USD", nil, nil, nil)
+
+	assert.ErrorIs(t, err, app.ErrWalletNotFound)
+	repo.AssertNotCalled(t, "Transfer", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestTransfer_AmountScale(t *testing.T) {
+	repo := new(MockWalletRepository)
+	service := app.NewWalletService(repo, app.WalletServiceOptions{DefaultCurrency: "USD", HoldTTL: time.Hour})
+
+	_, err := service.Transfer(context.Background(), owner, uuid.New(), uuid.New(), models.MustParseMoney("1.005"), "USD", nil, nil, nil)
+
+	assert.ErrorIs(t, err, app.ErrAmountScale)
+	repo.AssertNotCalled(t, "Transfer", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestListTransactions_NextCursor(t *testing.T) {
+	repo := new(MockWalletRepository)
+	service := app.NewWalletService(repo, app.WalletServiceOptions{DefaultCurrency: "USD", HoldTTL: time.Hour})
+	id := uuid.New()
+
+	entries := []models.TransactionModel{
+		{ID: uuid.New(), CreatedAt: time.Unix(3, 0)},
+		{ID: uuid.New(), CreatedAt: time.Unix(2, 0)},
+		{ID: uuid.New(), CreatedAt: time.Unix(1, 0)},
+	}
+	repo.On("GetByID", id).Return(&models.WalletModel{ID: id, OwnerID: "user-1"}, nil)
+	repo.On("ListTransactions", id, app.TransactionFilter{Limit: 3}).Return(entries, nil)
+
+	page, next, err := service.ListTransactions(context.Background(), owner, id, app.TransactionFilter{Limit: 2})
+
+	assert.NoError(t, err)
+	assert.Equal(t, entries[:2], page)
+	if assert.NotNil(t, next) {
+		assert.Equal(t, entries[1].ID, next.ID)
+		decoded, err := app.DecodeTransactionCursor(next.Encode())
+		assert.NoError(t, err)
+		assert.True(t, decoded.CreatedAt.Equal(entries[1].CreatedAt))
+	}
+	repo.AssertExpectations(t)
+}
+
+func TestListTransactions_LastPage(t *testing.T) {
+	repo := new(MockWalletRepository)
+	service := app.NewWalletService(repo, app.WalletServiceOptions{DefaultCurrency: "USD", HoldTTL: time.Hour})
+	id := uuid.New()
+
+	entries := []models.TransactionModel{{ID: uuid.New()}}
+	repo.On("GetByID", id).Return(&models.WalletModel{ID: id, OwnerID: "user-1"}, nil)
+	repo.On("ListTransactions", id, app.TransactionFilter{Limit: app.DefaultTransactionsLimit + 1}).Return(entries, nil)
+
+	page, next, err := service.ListTransactions(context.Background(), owner, id, app.TransactionFilter{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, entries, page)
+	assert.Nil(t, next)
+}
+
+func TestListTransactions_WalletNotFound(t *testing.T) {
+	repo := new(MockWalletRepository)
+	service := app.NewWalletService(repo, app.WalletServiceOptions{DefaultCurrency: "USD", HoldTTL: time.Hour})
+	id := uuid.New()
+
+	repo.On("GetByID", id).Return(nil, app.ErrWalletNotFound)
+
+	_, _, err := service.ListTransactions(context.Background(), owner, id, app.TransactionFilter{})
+
+	assert.ErrorIs(t, err, app.ErrWalletNotFound)
+	repo.AssertNotCalled(t, "ListTransactions", mock.Anything, mock.Anything)
+}
+
+func TestDecodeTransactionCursor_Invalid(t *testing.T) {
+	for _, s := range []string{"", "!!!", "bm90LWEtY3Vyc29y"} {
+		_, err := app.DecodeTransactionCursor(s)
+		assert.ErrorIs(t, err, app.ErrInvalidCursor, s)
+	}
+}
+
+func TestAuthorizeHold_DefaultExpiry(t *testing.T) {
+	repo := new(MockWalletRepository)
+	service := app.NewWalletService(repo, app.WalletServiceOptions{DefaultCurrency: "USD", HoldTTL: time.Hour})
+	id := uuid.New()
+
+	expected := &models.HoldModel{ID: uuid.New(), WalletID: id}
+	inTTL := mock.MatchedBy(func(exp time.Time) bool {
+		d := time.Until(exp)
+		return d > 59*time.Minute && d <= time.Hour
+	})
+	repo.On("GetByID", id).Return(&models.WalletModel{ID: id, OwnerID: "user-1"}, nil)
+	repo.On("Authorize", id, models.MoneyFromInt(10), "USD", inTTL, map[string]string(nil)).Return(expected, nil)
+
+	hold, err := service.AuthorizeHold(context.Background(), owner, id, models.MoneyFromInt(10), "usd", nil, nil, nil)
+
+	assert.NoError(t, err)
+	assert.Equal(t, expected, hold)
+	repo.AssertExpectations(t)
+}
+
+func TestAuthorizeHold_PastExpiry(t *testing.T) {
+	repo := new(MockWalletRepository)
+	service := app.NewWalletService(repo, app.WalletServiceOptions{DefaultCurrency: "USD", HoldTTL: time.Hour})
+	past := time.Now().Add(-time.Minute)
+
+	_, err := service.AuthorizeHold(context.Background(), owner, uuid.New(), models.MoneyFromInt(10), "USD", &past, nil, nil)
+
+	assert.ErrorIs(t, err, app.ErrInvalidHoldExpiry)
+	repo.AssertNotCalled(t, "Authorize", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestCaptureHold_AmountScale(t *testing.T) {
+	repo := new(MockWalletRepository)
+	service := app.NewWalletService(repo, app.WalletServiceOptions{DefaultCurrency: "USD", HoldTTL: time.Hour})
+	id := uuid.New()
+	walletID := uuid.New()
+	amount := models.MustParseMoney("1.5")
+
+	repo.On("GetHold", id).Return(&models.HoldModel{ID: id, WalletID: walletID, Currency: "JPY"}, nil)
+	repo.On("GetByID", walletID).Return(&models.WalletModel{ID: walletID, OwnerID: "user-1"}, nil)
+
+	_, _, _, err := service.CaptureHold(context.Background(), owner, id, &amount, nil)
+
+	assert.ErrorIs(t, err, app.ErrAmountScale)
+	repo.AssertNotCalled(t, "Capture", mock.Anything, mock.Anything)
+}
+
+func TestGetHold_NotOwner(t *testing.T) {
+	repo := new(MockWalletRepository)
+	service := app.NewWalletService(repo, app.WalletServiceOptions{DefaultCurrency: "USD", HoldTTL: time.Hour})
+	id, walletID := uuid.New(), uuid.New()
+
+	repo.On("GetHold", id).Return(&models.HoldModel{ID: id, WalletID: walletID}, nil)
+	repo.On("GetByID", walletID).Return(&models.WalletModel{ID: walletID, OwnerID: "user-1"}, nil)
+
+	_, err := service.GetHold(context.Background(), stranger, id)
+	assert.ErrorIs(t, err, app.ErrHoldNotFound)
+
+	_, err = service.VoidHold(context.Background(), stranger, id, nil)
+	assert.ErrorIs(t, err, app.ErrHoldNotFound)
+	repo.AssertNotCalled(t, "Void", mock.Anything)
+}
+
+func TestPrincipal_Allows(t *testing.T) {
+	depositOnly := &app.Principal{Subject: "psp", Scopes: []string{app.DepositScope}}
+
+	assert.True(t, depositOnly.Allows(app.DepositScope))
+	assert.False(t, depositOnly.Allows(app.WithdrawScope))
+	assert.True(t, admin.Allows(app.WithdrawScope))
+	assert.True(t, (*app.Principal)(nil).Allows(app.AdminScope))
+}
+
+func TestWalletService_ScopesRepositoryToTenant(t *testing.T) {
+	repo := new(MockWalletRepository)
+	service := app.NewWalletService(repo, app.WalletServiceOptions{DefaultCurrency: "USD", HoldTTL: time.Hour})
+	id := uuid.New()
+	acme := &app.Principal{Subject: "user-1", TenantID: "acme"}
+
+	repo.On("GetByID", id).Return(&models.WalletModel{ID: id, TenantID: "acme", OwnerID: "user-1"}, nil)
+
+	_, err := service.GetWallet(context.Background(), acme, id)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "acme", repo.tenantID)
+}
+
+func TestWalletService_TenantPolicy(t *testing.T) {
+	repo := new(MockWalletRepository)
+	limit := models.MustParseMoney("100")
+	service := app.NewWalletService(repo, app.WalletServiceOptions{
+		DefaultCurrency: "USD",
+		HoldTTL:         time.Hour,
+		Tenants: map[string]app.TenantPolicy{
+			"acme": {Currencies: []string{"EUR"}, MaxOperationAmount: &limit},
+		},
+	})
+	id := uuid.New()
+	acme := &app.Principal{Subject: "user-1", TenantID: "acme"}
+	unknown := &app.Principal{Subject: "user-1", TenantID: "initech"}
+
+	_, err := service.CreateWallet(context.Background(), unknown, models.MoneyFromInt(0), "EUR", nil)
+	assert.ErrorIs(t, err, app.ErrUnknownTenant)
+
+	_, err = service.CreateWallet(context.Background(), acme, models.MoneyFromInt(0), "USD", nil)
+	assert.ErrorIs(t, err, app.ErrCurrencyNotAllowed)
+
+	_, _, _, err = service.ChangeBalance(context.Background(), acme, id, app.DepositOperation, models.MustParseMoney("100.01"), "EUR", nil, nil, nil)
+	assert.ErrorIs(t, err, app.ErrOperationLimitExceeded)
+
+	_, err = service.Transfer(context.Background(), acme, id, uuid.New(), models.MoneyFromInt(101), "EUR", nil, nil, nil)
+	assert.ErrorIs(t, err, app.ErrOperationLimitExceeded)
+
+	repo.AssertNotCalled(t, "Create", mock.Anything, mock.Anything, mock.Anything)
+	repo.AssertNotCalled(t, "GetByID", mock.Anything)
+}
+
+func TestWalletService_Timeouts(t *testing.T) {
+	repo := new(MockWalletRepository)
+	service := app.NewWalletService(repo, app.WalletServiceOptions{
+		DefaultCurrency: "USD",
+		HoldTTL:         time.Hour,
+		ReadTimeout:     time.Second,
+		WriteTimeout:    time.Minute,
+	})
+	id := uuid.New()
+
+	repo.On("GetByID", id).Return(&models.WalletModel{ID: id, OwnerID: "user-1"}, nil)
+	repo.On("Delete", id).Return(nil)
+
+	_, err := service.GetWallet(context.Background(), owner, id)
+	assert.NoError(t, err)
+	deadline, ok := repo.ctx.Deadline()
+	assert.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(time.Second), deadline, time.Second)
+
+	assert.NoError(t, service.DeleteWallet(context.Background(), owner, id, nil))
+	deadline, ok = repo.ctx.Deadline()
+	assert.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), deadline, time.Second)
+}
@@ -0,0 +1,62 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"slices"
+
+	"github.com/ichigo7diabol/go-test-wallet/internal/models"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	ErrUnknownTenant          = errors.New("unknown tenant")
+	ErrCurrencyNotAllowed     = errors.New("currency is not allowed for tenant")
+	ErrOperationLimitExceeded = errors.New("amount exceeds tenant operation limit")
+)
+
+// TenantPolicy restricts what a tenant's wallets may do. Zero values impose
+// no restriction.
+type TenantPolicy struct {
+	// Currencies lists the ISO 4217 codes the tenant may open wallets in.
+	Currencies []string
+	// MaxOperationAmount caps a single deposit, withdrawal, transfer or hold.
+	MaxOperationAmount *models.Money
+}
+
+func (t TenantPolicy) checkCurrency(code string) error {
+	if len(t.Currencies) > 0 && !slices.Contains(t.Currencies, code) {
+		return ErrCurrencyNotAllowed
+	}
+	return nil
+}
+
+func (t TenantPolicy) checkOperation(amount models.Money, code string) error {
+	if err := t.checkCurrency(code); err != nil {
+		return err
+	}
+	if t.MaxOperationAmount != nil && amount.Cmp(*t.MaxOperationAmount) > 0 {
+		return ErrOperationLimitExceeded
+	}
+	return nil
+}
+
+// tenant returns the repository scoped to p's tenant and to ctx, and that
+// tenant's policy. When tenants are configured, any other tenant is rejected.
+func (s *WalletService) tenant(ctx context.Context, p *Principal) (WalletRepositoryService, TenantPolicy, error) {
+	id := tenantID(p)
+	trace.SpanFromContext(ctx).SetAttributes(TenantIDKey.String(id))
+	policy, ok := s.opts.Tenants[id]
+	if !ok && len(s.opts.Tenants) > 0 {
+		return nil, TenantPolicy{}, ErrUnknownTenant
+	}
+	return s.repository.ForTenant(id).WithContext(ctx), policy, nil
+}
+
+// tenantID returns p's tenant; a nil principal is in the default tenant.
+func tenantID(p *Principal) string {
+	if p == nil {
+		return ""
+	}
+	return p.TenantID
+}
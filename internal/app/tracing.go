@@ -0,0 +1,43 @@
+package app
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Span attributes shared by the service and repository spans.
+const (
+	WalletIDKey     = attribute.Key("wallet.id")
+	ToWalletIDKey   = attribute.Key("wallet.to.id")
+	OperationKey    = attribute.Key("wallet.operation")
+	HoldIDKey       = attribute.Key("wallet.hold.id")
+	TenantIDKey     = attribute.Key("wallet.tenant.id")
+	LockWaitTimeKey = attribute.Key("wallet.lock.wait_ms")
+)
+
+var tracer = otel.Tracer("github.com/ichigo7diabol/go-test-wallet/internal/app")
+
+func walletIDAttr(id uuid.UUID) attribute.KeyValue {
+	return WalletIDKey.String(id.String())
+}
+
+// startSpan starts a child span of ctx for a WalletService method. The span
+// uses the global tracer provider, so it is a no-op until one is installed.
+func startSpan(ctx context.Context, method string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "WalletService."+method, trace.WithAttributes(attrs...))
+}
+
+// endSpan records *err on span, if any, and ends it. Defer it with a pointer
+// to the method's named error result.
+func endSpan(span trace.Span, err *error) {
+	if *err != nil {
+		span.RecordError(*err)
+		span.SetStatus(codes.Error, (*err).Error())
+	}
+	span.End()
+}
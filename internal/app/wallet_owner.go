@@ -0,0 +1,40 @@
+package app
+
+import (
+	"github.com/google/uuid"
+	"github.com/ichigo7diabol/go-test-wallet/internal/models"
+)
+
+// Owner expectations let a change check that the caller owns the wallet as
+// part of the statement or lock that changes it, instead of reading the
+// wallet first. A wallet owned by someone else is reported as missing, the
+// same as accessibleWallet does.
+
+// expectedOwner is the owner a change expects wallet id to have.
+type expectedOwner struct {
+	id      uuid.UUID
+	ownerID string
+}
+
+// ExpectOwner returns a repository whose changes to wallet id fail with
+// ErrWalletNotFound unless ownerID owns it. Changes to other wallets are not
+// affected.
+func (r *RepositoryService) ExpectOwner(id uuid.UUID, ownerID string) WalletRepositoryService {
+	c := *r
+	c.owner = &expectedOwner{id: id, ownerID: ownerID}
+	return &c
+}
+
+// owns reports whether w is owned as r expects, if r expects anything of it.
+func (o *expectedOwner) owns(w *models.WalletModel) bool {
+	return o == nil || o.id != w.ID || o.ownerID == w.OwnerID
+}
+
+// expectOwner scopes repo to changes of wallet id that p may make: unless p
+// may act on any wallet, the change itself checks that p owns it.
+func expectOwner(repo WalletRepositoryService, p *Principal, id uuid.UUID) WalletRepositoryService {
+	if !p.ownsOnly() {
+		return repo
+	}
+	return repo.ExpectOwner(id, p.Subject)
+}
@@ -1,7 +1,9 @@
 package app
 
 import (
+	"context"
 	"errors"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/ichigo7diabol/go-test-wallet/internal/models"
@@ -12,48 +14,359 @@ type WalletOperation string
 const (
 	WithdrawOperation WalletOperation = "WITHDRAW"
 	DepositOperation  WalletOperation = "DEPOSIT"
+
+	// Not accepted by ChangeBalance: transfers go through Transfer and the
+	// rest only appear in the ledger.
+	TransferOperation WalletOperation = "TRANSFER"
+	CaptureOperation  WalletOperation = "CAPTURE"
+	OpenOperation     WalletOperation = "OPEN"
+	AdjustOperation   WalletOperation = "ADJUST"
 )
 
 var (
 	ErrUnknownOperation = errors.New("unknown operation")
+	ErrAmountScale      = errors.New("amount exceeds currency minor units")
+	ErrUnknownCurrency  = errors.New("unknown currency")
+	ErrAmountTooLarge   = errors.New("amount exceeds the maximum")
+)
+
+// MaxAmount bounds a single amount, so that balances built from such amounts
+// stay far inside what Money holds exactly.
+var MaxAmount = models.MustParseMoney("1000000000000000000")
+
+const (
+	DefaultTransactionsLimit = 50
+	MaxTransactionsLimit     = 200
 )
 
+type WalletServiceOptions struct {
+	// DefaultCurrency is used for wallets created without a currency.
+	DefaultCurrency string
+	// HoldTTL is the lifetime of holds authorized without an explicit expiry.
+	HoldTTL time.Duration
+	// ReadTimeout and WriteTimeout bound the database work of reads and of
+	// changes respectively. Zero means no bound beyond the caller's context.
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	// Tenants maps tenant ids to their policies. When empty, any tenant is
+	// accepted without restrictions.
+	Tenants map[string]TenantPolicy
+	// BatchWindow, when positive, groups deposits and withdrawals to the same
+	// wallet that arrive within it into one transaction, up to BatchSize of
+	// them; zero BatchSize leaves batches bounded by the window alone.
+	// Requests with an idempotency key or an expected version are never
+	// batched.
+	BatchWindow time.Duration
+	BatchSize   int
+}
+
 type WalletService struct {
 	repository WalletRepositoryService
+	opts       WalletServiceOptions
+	batcher    *balanceBatcher
 }
 
-func NewWalletService(repo WalletRepositoryService) *WalletService {
-	return &WalletService{repository: repo}
+func NewWalletService(repo WalletRepositoryService, opts WalletServiceOptions) *WalletService {
+	s := &WalletService{repository: repo, opts: opts}
+	if opts.BatchWindow > 0 {
+		s.batcher = newBalanceBatcher(repo, opts.BatchWindow, opts.BatchSize, opts.WriteTimeout)
+	}
+	return s
 }
 
-func (s *WalletService) CreateWallet(initialBalance float32) (*models.WalletModel, error) {
-	return s.repository.Create(initialBalance)
+// CreateWallet opens a wallet owned by p. idem may be nil when the client did
+// not send an idempotency key; the same applies to ChangeBalance and Transfer.
+// Those and the other changes to a wallet also take the version of it the
+// client last read, failing with ErrVersionConflict if it has changed since;
+// a nil version makes the change unconditional.
+func (s *WalletService) CreateWallet(ctx context.Context, p *Principal, initialBalance models.Money, currency string, idem *Idempotency) (
+	_ *models.WalletModel,
+	err error,
+) {
+	ctx, span := startSpan(ctx, "CreateWallet")
+	defer endSpan(span, &err)
+	ctx, cancel := withTimeout(ctx, s.opts.WriteTimeout)
+	defer cancel()
+
+	if currency == "" {
+		currency = s.opts.DefaultCurrency
+	}
+	c, err := checkAmount(initialBalance, currency)
+	if err != nil {
+		return nil, err
+	}
+	repo, policy, err := s.tenant(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	if err := policy.checkCurrency(c.Code); err != nil {
+		return nil, err
+	}
+	w, err := idempotent(repo, idem, func(repo WalletRepositoryService) (*models.WalletModel, error) {
+		return repo.Create(initialBalance, c.Code, p.ownerID())
+	})
+	if err != nil {
+		return nil, err
+	}
+	span.SetAttributes(walletIDAttr(w.ID))
+	return w, nil
 }
 
-func (s *WalletService) GetWallet(id uuid.UUID) (*models.WalletModel, error) {
-	return s.repository.GetByID(id)
+func (s *WalletService) GetWallet(ctx context.Context, p *Principal, id uuid.UUID) (_ *models.WalletModel, err error) {
+	ctx, span := startSpan(ctx, "GetWallet", walletIDAttr(id))
+	defer endSpan(span, &err)
+	ctx, cancel := withTimeout(ctx, s.opts.ReadTimeout)
+	defer cancel()
+
+	repo, _, err := s.tenant(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	return accessibleWallet(repo, p, id)
 }
 
-func (s *WalletService) DeleteWallet(id uuid.UUID) error {
-	return s.repository.Delete(id)
+func (s *WalletService) DeleteWallet(ctx context.Context, p *Principal, id uuid.UUID, version *int64) (err error) {
+	ctx, span := startSpan(ctx, "DeleteWallet", walletIDAttr(id))
+	defer endSpan(span, &err)
+	ctx, cancel := withTimeout(ctx, s.opts.WriteTimeout)
+	defer cancel()
+
+	repo, _, err := s.tenant(ctx, p)
+	if err != nil {
+		return err
+	}
+	if _, err := accessibleWallet(repo, p, id); err != nil {
+		return err
+	}
+	return expectVersion(repo, id, version).Delete(id)
 }
 
-func (s *WalletService) ListWallets() ([]models.WalletModel, error) {
-	return s.repository.List()
+// ListWallets returns the wallets p owns, or every wallet of the tenant for
+// an admin.
+func (s *WalletService) ListWallets(ctx context.Context, p *Principal) (_ []models.WalletModel, err error) {
+	ctx, span := startSpan(ctx, "ListWallets")
+	defer endSpan(span, &err)
+	ctx, cancel := withTimeout(ctx, s.opts.ReadTimeout)
+	defer cancel()
+
+	repo, _, err := s.tenant(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	if p == nil || p.HasScope(AdminScope) {
+		return repo.List()
+	}
+	return repo.ListByOwner(p.Subject)
 }
 
-func (s *WalletService) ChangeBalance(id uuid.UUID, op WalletOperation, amount float32) (
-	oldBalance float32,
-	newBalance float32,
+func (s *WalletService) ChangeBalance(
+	ctx context.Context,
+	p *Principal,
+	id uuid.UUID,
+	op WalletOperation,
+	amount models.Money,
+	currency string,
+	metadata map[string]string,
+	version *int64,
+	idem *Idempotency,
+) (
+	oldBalance models.Money,
+	newBalance models.Money,
 	model *models.WalletModel,
 	err error,
 ) {
+	ctx, span := startSpan(ctx, "ChangeBalance", walletIDAttr(id), OperationKey.String(string(op)))
+	defer endSpan(span, &err)
+	ctx, cancel := withTimeout(ctx, s.opts.WriteTimeout)
+	defer cancel()
+
+	c, err := checkAmount(amount, currency)
+	if err != nil {
+		return models.Money{}, models.Money{}, nil, err
+	}
 	switch op {
-	case DepositOperation:
-		return s.repository.Deposit(id, amount)
-	case WithdrawOperation:
-		return s.repository.Withdraw(id, amount)
+	case DepositOperation, WithdrawOperation:
 	default:
-		return 0, 0, nil, ErrUnknownOperation
+		return models.Money{}, models.Money{}, nil, ErrUnknownOperation
+	}
+	defer func() {
+		operationsTotal.WithLabelValues(string(op), outcome(err)).Inc()
+		if err == nil {
+			observeMoneyMoved(op, c.Code, amount)
+		}
+	}()
+	repo, policy, err := s.tenant(ctx, p)
+	if err != nil {
+		return models.Money{}, models.Money{}, nil, err
+	}
+	if err := policy.checkOperation(amount, c.Code); err != nil {
+		return models.Money{}, models.Money{}, nil, err
+	}
+
+	// The change checks the owner itself rather than reading the wallet
+	// first, which would cost the single-statement update a round trip.
+	start := time.Now()
+	var res balanceChange
+	if s.batcher != nil && idem == nil && version == nil {
+		res, err = s.batcher.do(tenantID(p), id, &batchedChange{
+			ctx:      ctx,
+			p:        p,
+			op:       op,
+			amount:   amount,
+			currency: c.Code,
+			metadata: metadata,
+		})
+	} else {
+		repo = expectVersion(expectOwner(repo, p, id), id, version)
+		res, err = idempotent(repo, idem, func(repo WalletRepositoryService) (balanceChange, error) {
+			apply := repo.Deposit
+			if op == WithdrawOperation {
+				apply = repo.Withdraw
+			}
+			oldBalance, newBalance, model, err := apply(id, amount, c.Code, metadata)
+			return balanceChange{OldBalance: oldBalance, NewBalance: newBalance, Wallet: model}, err
+		})
+	}
+	observeTransaction(op, start)
+	if err != nil {
+		return models.Money{}, models.Money{}, nil, err
+	}
+	return res.OldBalance, res.NewBalance, res.Wallet, nil
+}
+
+// Transfer moves amount from one wallet to another atomically. p must be able
+// to access the source wallet; any wallet of the same tenant may receive
+// funds. version is that of the source wallet.
+func (s *WalletService) Transfer(
+	ctx context.Context,
+	p *Principal,
+	fromID uuid.UUID,
+	toID uuid.UUID,
+	amount models.Money,
+	currency string,
+	metadata map[string]string,
+	version *int64,
+	idem *Idempotency,
+) (_ *TransferResult, err error) {
+	ctx, span := startSpan(ctx, "Transfer",
+		walletIDAttr(fromID),
+		ToWalletIDKey.String(toID.String()),
+		OperationKey.String(string(TransferOperation)),
+	)
+	defer endSpan(span, &err)
+	ctx, cancel := withTimeout(ctx, s.opts.WriteTimeout)
+	defer cancel()
+
+	c, err := checkAmount(amount, currency)
+	if err != nil {
+		return nil, err
+	}
+	repo, policy, err := s.tenant(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	if err := policy.checkOperation(amount, c.Code); err != nil {
+		return nil, err
+	}
+	if _, err := accessibleWallet(repo, p, fromID); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	res, err := idempotent(expectVersion(repo, fromID, version), idem, func(repo WalletRepositoryService) (*TransferResult, error) {
+		return repo.Transfer(fromID, toID, amount, c.Code, metadata)
+	})
+	observeTransaction(TransferOperation, start)
+	if err != nil {
+		return nil, err
+	}
+	observeMoneyMoved(TransferOperation, c.Code, amount)
+	return res, nil
+}
+
+// ListTransactions returns one page of a wallet's ledger, newest first. next
+// is nil when there are no more entries.
+func (s *WalletService) ListTransactions(ctx context.Context, p *Principal, walletID uuid.UUID, filter TransactionFilter) (
+	entries []models.TransactionModel,
+	next *TransactionCursor,
+	err error,
+) {
+	ctx, span := startSpan(ctx, "ListTransactions", walletIDAttr(walletID))
+	defer endSpan(span, &err)
+	ctx, cancel := withTimeout(ctx, s.opts.ReadTimeout)
+	defer cancel()
+
+	if filter.Limit <= 0 {
+		filter.Limit = DefaultTransactionsLimit
+	}
+	if filter.Limit > MaxTransactionsLimit {
+		filter.Limit = MaxTransactionsLimit
+	}
+	repo, _, err := s.tenant(ctx, p)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := accessibleWallet(repo, p, walletID); err != nil {
+		return nil, nil, err
+	}
+
+	// Fetch one extra entry to learn whether another page exists.
+	limit := filter.Limit
+	filter.Limit++
+	entries, err = repo.ListTransactions(walletID, filter)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(entries) > limit {
+		entries = entries[:limit]
+		cursor := NewTransactionCursor(&entries[limit-1])
+		next = &cursor
+	}
+	return entries, next, nil
+}
+
+// accessibleWallet loads a wallet p may act on from the tenant-scoped repo.
+// Wallets owned by someone else are reported as not found so their existence
+// is not disclosed.
+func accessibleWallet(repo WalletRepositoryService, p *Principal, id uuid.UUID) (*models.WalletModel, error) {
+	w, err := repo.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if !p.CanAccess(w) {
+		return nil, ErrWalletNotFound
+	}
+	return w, nil
+}
+
+// expectVersion scopes repo to changes that expect wallet id at version,
+// unless version is nil.
+func expectVersion(repo WalletRepositoryService, id uuid.UUID, version *int64) WalletRepositoryService {
+	if version == nil {
+		return repo
+	}
+	return repo.ExpectVersion(id, *version)
+}
+
+// withTimeout bounds ctx by timeout unless it is zero.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// checkAmount resolves the currency and rejects amounts more precise than
+// its minor unit or larger than MaxAmount.
+func checkAmount(amount models.Money, currency string) (models.Currency, error) {
+	c, ok := models.LookupCurrency(currency)
+	if !ok {
+		return models.Currency{}, ErrUnknownCurrency
+	}
+	if amount.Scale() > c.MinorUnits {
+		return models.Currency{}, ErrAmountScale
+	}
+	if amount.Cmp(MaxAmount) > 0 {
+		return models.Currency{}, ErrAmountTooLarge
 	}
+	return c, nil
 }
@@ -0,0 +1,78 @@
+package app
+
+import (
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/ichigo7diabol/go-test-wallet/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Wallet versions let clients that read a wallet and then change it detect
+// that someone else changed it in between. Every committed change increments
+// the version; a client passes the version it read to ExpectVersion, and the
+// change is rejected with ErrVersionConflict instead of overwriting a state
+// the client has not seen.
+
+var (
+	ErrVersionConflict = errors.New("wallet version does not match")
+)
+
+// expectedVersion is the version a change expects wallet id to be at.
+type expectedVersion struct {
+	id      uuid.UUID
+	version int64
+}
+
+// ExpectVersion returns a repository whose changes to wallet id fail with
+// ErrVersionConflict unless the wallet is at version when they lock it.
+// Changes to other wallets are not affected.
+func (r *RepositoryService) ExpectVersion(id uuid.UUID, version int64) WalletRepositoryService {
+	c := *r
+	c.expected = &expectedVersion{id: id, version: version}
+	return &c
+}
+
+// expects reports whether r has an expected version for wallet id.
+func (r *RepositoryService) expects(id uuid.UUID) bool {
+	return r.expected != nil && r.expected.id == id
+}
+
+// lock locks the tenant's wallet id into w like lockWallet and checks the
+// owner and version expected of it, if any. The slots of a sharded wallet
+// are locked too, so no deposit into them commits between the version check
+// and the change.
+func (r *RepositoryService) lock(tx *gorm.DB, id uuid.UUID, w *models.WalletModel) error {
+	if err := lockWallet(tx, r.tenantID, id, w); err != nil {
+		return err
+	}
+	if !r.owner.owns(w) {
+		return ErrWalletNotFound
+	}
+	if !r.expects(id) {
+		return nil
+	}
+	version := w.Version
+	if w.BalanceSlots > 0 {
+		var slots []models.BalanceSlotModel
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("wallet_id = ?", id).Order("slot").Find(&slots).Error; err != nil {
+			return err
+		}
+		for _, s := range slots {
+			version += s.Version
+		}
+	}
+	if version != r.expected.version {
+		return ErrVersionConflict
+	}
+	return nil
+}
+
+// saveWallet writes the locked wallet w back, counting the change in its
+// version.
+func saveWallet(tx *gorm.DB, w *models.WalletModel) error {
+	w.Version++
+	return tx.Save(w).Error
+}
@@ -1,17 +1,101 @@
 package config
 
 import (
+	"time"
+
 	"github.com/spf13/viper"
 )
 
 const (
-	EnvPrefix   = "WALLET_APP"
-	DefaultPort = "8080"
+	EnvPrefix       = "WALLET_APP"
+	DefaultPort     = "8080"
+	DefaultCurrency = "USD"
+
+	DefaultHoldTTL           = 7 * 24 * time.Hour
+	DefaultHoldSweepInterval = time.Minute
+
+	// A wallet is shared by every client of its tenant, so it gets less
+	// than one client may send in total; otherwise a single client could
+	// keep its row lock busy for everyone else.
+	DefaultRateLimitWalletRPS   = 50
+	DefaultRateLimitWalletBurst = 100
+	DefaultRateLimitClientRPS   = 100
+	DefaultRateLimitClientBurst = 200
+
+	DefaultShutdownTimeout = 30 * time.Second
+
+	DefaultReadTimeout  = 5 * time.Second
+	DefaultWriteTimeout = 10 * time.Second
+
+	DefaultBatchSize = 100
+
+	TracingExporterNone   = "none"
+	TracingExporterStdout = "stdout"
+	TracingExporterOTLP   = "otlp"
 )
 
 type Config struct {
-	Port string
-	Dsn  string
+	// Release is set by the release image.
+	Release bool
+
+	Port            string
+	Dsn             string
+	DefaultCurrency string
+
+	HoldTTL time.Duration
+	// HoldSweepInterval is how often expired holds are released; zero
+	// disables the sweeper.
+	HoldSweepInterval time.Duration
+
+	// ReadTimeout and WriteTimeout bound the database work of one read or
+	// one change.
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	// AuthDisabled serves the API without authentication; a Release build
+	// refuses to start with it.
+	AuthDisabled bool
+	AuthJWKSURL  string
+	AuthJWKSFile string
+	AuthIssuer   string
+	AuthAudience string
+
+	TenantHeader string
+	TenantsFile  string
+
+	RateLimitClientRPS   float64
+	RateLimitClientBurst int
+	RateLimitWalletRPS   float64
+	RateLimitWalletBurst int
+
+	// TracingExporter is one of "none", "stdout" or "otlp".
+	TracingExporter string
+	// TracingEndpoint is the OTLP/HTTP traces URL; empty uses the standard
+	// OTEL_EXPORTER_OTLP_* variables.
+	TracingEndpoint string
+
+	// ShutdownDelay keeps serving with failing readiness before the server
+	// stops accepting connections.
+	ShutdownDelay time.Duration
+	// ShutdownTimeout bounds the wait for in-flight requests on shutdown.
+	ShutdownTimeout time.Duration
+
+	// MigrateOnStart applies pending migrations before serving.
+	MigrateOnStart bool
+
+	// BalanceSlots spreads the balance of new wallets over that many rows
+	// so that deposits to one wallet do not queue on its row lock; below 2
+	// wallets are created plain.
+	BalanceSlots int
+	// AtomicUpdates lets deposits and withdrawals on PostgreSQL change the
+	// balance with one conditional UPDATE instead of locking the row first.
+	AtomicUpdates bool
+
+	// BatchWindow groups deposits and withdrawals to one wallet arriving
+	// within it into a single transaction of at most BatchSize changes;
+	// zero disables batching.
+	BatchWindow time.Duration
+	BatchSize   int
 }
 
 func Load() *Config {
@@ -19,15 +103,118 @@ func Load() *Config {
 	viper.AutomaticEnv()
 
 	viper.SetDefault("port", DefaultPort)
+	viper.SetDefault("default_currency", DefaultCurrency)
+	viper.SetDefault("hold_ttl", DefaultHoldTTL)
+	viper.SetDefault("hold_sweep_interval", DefaultHoldSweepInterval)
+	viper.SetDefault("rate_limit_client_rps", DefaultRateLimitClientRPS)
+	viper.SetDefault("rate_limit_client_burst", DefaultRateLimitClientBurst)
+	viper.SetDefault("rate_limit_wallet_rps", DefaultRateLimitWalletRPS)
+	viper.SetDefault("rate_limit_wallet_burst", DefaultRateLimitWalletBurst)
+	viper.SetDefault("tracing_exporter", TracingExporterNone)
+	viper.SetDefault("shutdown_timeout", DefaultShutdownTimeout)
+	viper.SetDefault("read_timeout", DefaultReadTimeout)
+	viper.SetDefault("write_timeout", DefaultWriteTimeout)
+	viper.SetDefault("migrate_on_start", true)
+	viper.SetDefault("atomic_updates", true)
+	viper.SetDefault("batch_size", DefaultBatchSize)
 
+	viper.BindEnv("release", "RELEASE")
 	viper.BindEnv("port", "PORT")
 	viper.BindEnv("dsn", "DSN")
+	viper.BindEnv("default_currency", "DEFAULT_CURRENCY")
+	viper.BindEnv("hold_ttl", "HOLD_TTL")
+	viper.BindEnv("hold_sweep_interval", "HOLD_SWEEP_INTERVAL")
+	viper.BindEnv("read_timeout", "READ_TIMEOUT")
+	viper.BindEnv("write_timeout", "WRITE_TIMEOUT")
+	viper.BindEnv("auth_disabled", "AUTH_DISABLED")
+	viper.BindEnv("auth_jwks_url", "AUTH_JWKS_URL")
+	viper.BindEnv("auth_jwks_file", "AUTH_JWKS_FILE")
+	viper.BindEnv("auth_issuer", "AUTH_ISSUER")
+	viper.BindEnv("auth_audience", "AUTH_AUDIENCE")
+	viper.BindEnv("tenant_header", "TENANT_HEADER")
+	viper.BindEnv("tenants_file", "TENANTS_FILE")
+	viper.BindEnv("rate_limit_client_rps", "RATE_LIMIT_CLIENT_RPS")
+	viper.BindEnv("rate_limit_client_burst", "RATE_LIMIT_CLIENT_BURST")
+	viper.BindEnv("rate_limit_wallet_rps", "RATE_LIMIT_WALLET_RPS")
+	viper.BindEnv("rate_limit_wallet_burst", "RATE_LIMIT_WALLET_BURST")
+	viper.BindEnv("tracing_exporter", "TRACING_EXPORTER")
+	viper.BindEnv("tracing_endpoint", "TRACING_ENDPOINT")
+	viper.BindEnv("shutdown_delay", "SHUTDOWN_DELAY")
+	viper.BindEnv("shutdown_timeout", "SHUTDOWN_TIMEOUT")
+	viper.BindEnv("migrate_on_start", "MIGRATE_ON_START")
+	viper.BindEnv("balance_slots", "BALANCE_SLOTS")
+	viper.BindEnv("atomic_updates", "ATOMIC_UPDATES")
+	viper.BindEnv("batch_window", "BATCH_WINDOW")
+	viper.BindEnv("batch_size", "BATCH_SIZE")
 
+	release := viper.GetBool("release")
 	port := viper.GetString("port")
 	dsn := viper.GetString("dsn")
+	defaultCurrency := viper.GetString("default_currency")
+	holdTTL := viper.GetDuration("hold_ttl")
+	holdSweepInterval := max(viper.GetDuration("hold_sweep_interval"), 0)
+	readTimeout := viper.GetDuration("read_timeout")
+	writeTimeout := viper.GetDuration("write_timeout")
+	authDisabled := viper.GetBool("auth_disabled")
+	authJWKSURL := viper.GetString("auth_jwks_url")
+	authJWKSFile := viper.GetString("auth_jwks_file")
+	authIssuer := viper.GetString("auth_issuer")
+	authAudience := viper.GetString("auth_audience")
+	tenantHeader := viper.GetString("tenant_header")
+	tenantsFile := viper.GetString("tenants_file")
+	rateLimitClientRPS := viper.GetFloat64("rate_limit_client_rps")
+	rateLimitClientBurst := viper.GetInt("rate_limit_client_burst")
+	rateLimitWalletRPS := viper.GetFloat64("rate_limit_wallet_rps")
+	rateLimitWalletBurst := viper.GetInt("rate_limit_wallet_burst")
+	tracingExporter := viper.GetString("tracing_exporter")
+	tracingEndpoint := viper.GetString("tracing_endpoint")
+	shutdownDelay := viper.GetDuration("shutdown_delay")
+	shutdownTimeout := viper.GetDuration("shutdown_timeout")
+	migrateOnStart := viper.GetBool("migrate_on_start")
+	balanceSlots := viper.GetInt("balance_slots")
+	atomicUpdates := viper.GetBool("atomic_updates")
+	batchWindow := viper.GetDuration("batch_window")
+	batchSize := viper.GetInt("batch_size")
 
 	return &Config{
-		Port: port,
-		Dsn:  dsn,
+		Release: release,
+
+		Port:            port,
+		Dsn:             dsn,
+		DefaultCurrency: defaultCurrency,
+
+		HoldTTL:           holdTTL,
+		HoldSweepInterval: holdSweepInterval,
+
+		ReadTimeout:  readTimeout,
+		WriteTimeout: writeTimeout,
+
+		AuthDisabled: authDisabled,
+		AuthJWKSURL:  authJWKSURL,
+		AuthJWKSFile: authJWKSFile,
+		AuthIssuer:   authIssuer,
+		AuthAudience: authAudience,
+
+		TenantHeader: tenantHeader,
+		TenantsFile:  tenantsFile,
+
+		RateLimitClientRPS:   rateLimitClientRPS,
+		RateLimitClientBurst: rateLimitClientBurst,
+		RateLimitWalletRPS:   rateLimitWalletRPS,
+		RateLimitWalletBurst: rateLimitWalletBurst,
+
+		TracingExporter: tracingExporter,
+		TracingEndpoint: tracingEndpoint,
+
+		ShutdownDelay:   shutdownDelay,
+		ShutdownTimeout: shutdownTimeout,
+
+		MigrateOnStart: migrateOnStart,
+
+		BalanceSlots:  balanceSlots,
+		AtomicUpdates: atomicUpdates,
+
+		BatchWindow: batchWindow,
+		BatchSize:   batchSize,
 	}
 }
@@ -0,0 +1,32 @@
+//go:build unit
+
+package config_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ichigo7diabol/go-test-wallet/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoad_HoldSweepInterval(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  time.Duration
+	}{
+		{"default", "", config.DefaultHoldSweepInterval},
+		{"set", "30s", 30 * time.Second},
+		{"zero disables", "0", 0},
+		{"negative disables", "-1m", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.value != "" {
+				t.Setenv("WALLET_APP_HOLD_SWEEP_INTERVAL", tt.value)
+			}
+			assert.Equal(t, tt.want, config.Load().HoldSweepInterval)
+		})
+	}
+}
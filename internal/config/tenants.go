@@ -0,0 +1,27 @@
+package config
+
+import (
+	"github.com/spf13/viper"
+)
+
+// Tenant is one entry of the tenants file.
+type Tenant struct {
+	ID         string   `mapstructure:"id"`
+	Currencies []string `mapstructure:"currencies"`
+	// MaxOperationAmount is a decimal string; empty means no limit.
+	MaxOperationAmount string `mapstructure:"maxOperationAmount"`
+}
+
+// LoadTenants reads the tenant list from a YAML, JSON or TOML file.
+func LoadTenants(path string) ([]Tenant, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, err
+	}
+	var tenants []Tenant
+	if err := v.UnmarshalKey("tenants", &tenants); err != nil {
+		return nil, err
+	}
+	return tenants, nil
+}
@@ -0,0 +1,135 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+var ErrUnsupportedDSN = errors.New("unsupported database DSN")
+
+const (
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+
+	// BusyTimeout is how long a SQLite transaction waits for another
+	// connection's write lock before failing.
+	BusyTimeout = 5 * time.Second
+)
+
+// Driver picks the database driver from the scheme of dsn and returns the
+// data source name to hand it:
+//
+//	postgres://... or postgresql://...   PostgreSQL URL
+//	host=... user=...                    PostgreSQL keyword/value DSN
+//	sqlite://wallet.db or sqlite:wallet.db
+//	file:wallet.db?mode=rwc              SQLite URI filename
+//	:memory:                             SQLite in-memory database
+//	wallet.db or /var/lib/wallet.db      SQLite file path
+//	wallet.db?_journal_mode=WAL          SQLite file path with parameters
+//
+// Any other URL scheme is rejected.
+func Driver(dsn string) (driver, source string, err error) {
+	switch {
+	case strings.HasPrefix(dsn, "postgres://"), strings.HasPrefix(dsn, "postgresql://"):
+		return DriverPostgres, dsn, nil
+	case strings.HasPrefix(dsn, "sqlite://"):
+		return DriverSQLite, strings.TrimPrefix(dsn, "sqlite://"), nil
+	case strings.HasPrefix(dsn, "sqlite:"):
+		return DriverSQLite, strings.TrimPrefix(dsn, "sqlite:"), nil
+	case strings.HasPrefix(dsn, "file:"), strings.HasPrefix(dsn, ":memory:"):
+		return DriverSQLite, dsn, nil
+	case strings.Contains(dsn, "://"), dsn == "":
+		return "", "", fmt.Errorf("%w: %q, want a postgres:// URL, a PostgreSQL key=value DSN, "+
+			"sqlite://<path>, file:<path> or a SQLite file path", ErrUnsupportedDSN, dsn)
+	case isKeywordValue(dsn):
+		return DriverPostgres, dsn, nil
+	default:
+		return DriverSQLite, dsn, nil
+	}
+}
+
+// Open connects to the database named by dsn; see Driver for the accepted
+// forms.
+//
+// SQLite has no SELECT ... FOR UPDATE, so wallet row locks are replaced by
+// serializing writers on the database: every transaction starts with BEGIN
+// IMMEDIATE, taking the write lock before its first read, and waits up to
+// BusyTimeout for it. File databases use WAL so reads proceed alongside the
+// writer. An in-memory database lives on a single connection, which
+// serializes everything.
+func Open(dsn string) (*gorm.DB, error) {
+	driver, source, err := Driver(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if driver == DriverPostgres {
+		return gorm.Open(postgres.Open(source), &gorm.Config{})
+	}
+
+	memory := isMemory(source)
+	db, err := gorm.Open(sqlite.Open(sqliteSource(source, memory)), &gorm.Config{})
+	if err != nil {
+		return nil, err
+	}
+	if memory {
+		sqlDB, err := db.DB()
+		if err != nil {
+			return nil, err
+		}
+		sqlDB.SetMaxOpenConns(1)
+	}
+	return db, nil
+}
+
+// isKeywordValue reports whether dsn starts like a PostgreSQL keyword/value
+// DSN, keyword=value. The query of a SQLite path has an = too, but the path
+// before it always holds a character a keyword cannot, such as ., / or ?.
+func isKeywordValue(dsn string) bool {
+	keyword, _, ok := strings.Cut(strings.TrimLeft(dsn, " \t"), "=")
+	keyword = strings.TrimRight(keyword, " \t")
+	if !ok || keyword == "" {
+		return false
+	}
+	for _, r := range keyword {
+		if r != '_' && (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+func isMemory(source string) bool {
+	return strings.HasPrefix(source, ":memory:") ||
+		strings.HasPrefix(source, "file::memory:") ||
+		strings.Contains(source, "mode=memory")
+}
+
+// sqliteSource adds the go-sqlite3 parameters Open relies on to source,
+// keeping any the caller set.
+func sqliteSource(source string, memory bool) string {
+	name, rawQuery, _ := strings.Cut(source, "?")
+	params, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		params = url.Values{}
+	}
+	defaults := map[string]string{
+		"_txlock":       "immediate",
+		"_busy_timeout": fmt.Sprint(BusyTimeout.Milliseconds()),
+	}
+	if !memory {
+		defaults["_journal_mode"] = "WAL"
+	}
+	for k, v := range defaults {
+		if !params.Has(k) {
+			params.Set(k, v)
+		}
+	}
+	return name + "?" + params.Encode()
+}
@@ -0,0 +1,48 @@
+//go:build unit
+
+package database_test
+
+import (
+	"testing"
+
+	"github.com/ichigo7diabol/go-test-wallet/internal/database"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDriver(t *testing.T) {
+	cases := []struct {
+		dsn, driver, source string
+	}{
+		{"postgres://u:p@db:5432/wallet", database.DriverPostgres, "postgres://u:p@db:5432/wallet"},
+		{"postgresql://db/wallet", database.DriverPostgres, "postgresql://db/wallet"},
+		{"host=db user=u dbname=wallet", database.DriverPostgres, "host=db user=u dbname=wallet"},
+		{"  host = db port=5432", database.DriverPostgres, "  host = db port=5432"},
+		{"dbname=wallet sslmode=disable", database.DriverPostgres, "dbname=wallet sslmode=disable"},
+		{"sqlite:///var/lib/wallet.db", database.DriverSQLite, "/var/lib/wallet.db"},
+		{"sqlite://wallet.db", database.DriverSQLite, "wallet.db"},
+		{"sqlite:wallet.db", database.DriverSQLite, "wallet.db"},
+		{"file:wallet.db?mode=rwc", database.DriverSQLite, "file:wallet.db?mode=rwc"},
+		{":memory:", database.DriverSQLite, ":memory:"},
+		{"wallet.db", database.DriverSQLite, "wallet.db"},
+		{"/var/lib/wallet.db", database.DriverSQLite, "/var/lib/wallet.db"},
+		// Parameters of a SQLite path do not make it a keyword/value DSN.
+		{"wallet.db?_txlock=immediate&_journal_mode=WAL", database.DriverSQLite, "wallet.db?_txlock=immediate&_journal_mode=WAL"},
+		{"/var/lib/wallet.db?mode=rwc", database.DriverSQLite, "/var/lib/wallet.db?mode=rwc"},
+		{"wallet?_busy_timeout=1000", database.DriverSQLite, "wallet?_busy_timeout=1000"},
+		{"data/key=value.db", database.DriverSQLite, "data/key=value.db"},
+		{"file:wallet.db?_txlock=immediate&_journal_mode=WAL", database.DriverSQLite, "file:wallet.db?_txlock=immediate&_journal_mode=WAL"},
+	}
+	for _, c := range cases {
+		driver, source, err := database.Driver(c.dsn)
+		assert.NoError(t, err, c.dsn)
+		assert.Equal(t, c.driver, driver, c.dsn)
+		assert.Equal(t, c.source, source, c.dsn)
+	}
+}
+
+func TestDriver_Unsupported(t *testing.T) {
+	for _, dsn := range []string{"", "mysql://db/wallet"} {
+		_, _, err := database.Driver(dsn)
+		assert.ErrorIs(t, err, database.ErrUnsupportedDSN, dsn)
+	}
+}
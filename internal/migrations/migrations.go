@@ -0,0 +1,266 @@
+package migrations
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrUnsupportedDialect = errors.New("no migrations for database dialect")
+	ErrInvalidMigration   = errors.New("invalid migration file")
+	ErrUnknownVersion     = errors.New("database has a migration this binary does not know")
+)
+
+// AdvisoryLockID is the PostgreSQL advisory lock key held while migrating,
+// so replicas starting together apply each migration once.
+const AdvisoryLockID int64 = 0x77616c6c6574 // "wallet"
+
+//go:embed postgres/*.sql sqlite/*.sql
+var files embed.FS
+
+// fileName matches "<version>_<name>.<up|down>.sql".
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status is a migration together with when it was applied, if at all.
+type Status struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// SchemaMigration is a row of schema_migrations.
+type SchemaMigration struct {
+	Version   int64  `gorm:"primaryKey;autoIncrement:false"`
+	Name      string `gorm:"type:varchar(255);not null"`
+	AppliedAt time.Time
+}
+
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+type Migrator struct {
+	db         *gorm.DB
+	migrations []Migration
+}
+
+// New returns a migrator for the embedded migrations of db's dialect.
+func New(db *gorm.DB) (*Migrator, error) {
+	dir := db.Dialector.Name()
+	sub, err := fs.Sub(files, dir)
+	if err != nil {
+		return nil, err
+	}
+	if matches, _ := fs.Glob(sub, "*.sql"); len(matches) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedDialect, dir)
+	}
+	return NewFromFS(db, sub)
+}
+
+// NewFromFS returns a migrator for the migration files at the root of fsys.
+func NewFromFS(db *gorm.DB, fsys fs.FS) (*Migrator, error) {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// Load reads and pairs the up and down files in fsys, ordered by version.
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int64]*Migration{}
+	for _, e := range entries {
+		m := fileName.FindStringSubmatch(e.Name())
+		if e.IsDir() || m == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidMigration, e.Name())
+		}
+		raw, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return nil, err
+		}
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		}
+		if mig.Name != m[2] {
+			return nil, fmt.Errorf("%w: version %d has two names", ErrInvalidMigration, version)
+		}
+		if m[3] == "up" {
+			mig.Up = string(raw)
+		} else {
+			mig.Down = string(raw)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.Up == "" || mig.Down == "" {
+			return nil, fmt.Errorf("%w: version %d needs both up and down", ErrInvalidMigration, mig.Version)
+		}
+		migrations = append(migrations, *mig)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Up applies every pending migration in order and returns those applied.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var applied []Migration
+	for {
+		var next *Migration
+		err := m.step(ctx, func(tx *gorm.DB, done map[int64]SchemaMigration) error {
+			for i := range m.migrations {
+				if _, ok := done[m.migrations[i].Version]; !ok {
+					next = &m.migrations[i]
+					break
+				}
+			}
+			if next == nil {
+				return nil
+			}
+			if err := tx.Exec(next.Up).Error; err != nil {
+				return fmt.Errorf("applying %d_%s: %w", next.Version, next.Name, err)
+			}
+			return tx.Create(&SchemaMigration{Version: next.Version, Name: next.Name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil || next == nil {
+			return applied, err
+		}
+		applied = append(applied, *next)
+	}
+}
+
+// Down rolls back the latest steps applied migrations and returns those
+// rolled back.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	var rolledBack []Migration
+	for range steps {
+		var last *Migration
+		err := m.step(ctx, func(tx *gorm.DB, done map[int64]SchemaMigration) error {
+			for i := len(m.migrations) - 1; i >= 0; i-- {
+				if _, ok := done[m.migrations[i].Version]; ok {
+					last = &m.migrations[i]
+					break
+				}
+			}
+			if last == nil {
+				return nil
+			}
+			if err := tx.Exec(last.Down).Error; err != nil {
+				return fmt.Errorf("rolling back %d_%s: %w", last.Version, last.Name, err)
+			}
+			return tx.Delete(&SchemaMigration{Version: last.Version}).Error
+		})
+		if err != nil || last == nil {
+			return rolledBack, err
+		}
+		rolledBack = append(rolledBack, *last)
+	}
+	return rolledBack, nil
+}
+
+// Status lists every known migration and when it was applied.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	done, err := m.applied(m.db.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]Status, 0, len(m.migrations))
+	for _, mig := range m.migrations {
+		s := Status{Migration: mig}
+		if row, ok := done[mig.Version]; ok {
+			s.AppliedAt = &row.AppliedAt
+		}
+		statuses = append(statuses, s)
+	}
+	return statuses, nil
+}
+
+// Pending returns the migrations not applied yet.
+func (m *Migrator) Pending(ctx context.Context) ([]Migration, error) {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var pending []Migration
+	for _, s := range statuses {
+		if s.AppliedAt == nil {
+			pending = append(pending, s.Migration)
+		}
+	}
+	return pending, nil
+}
+
+// step runs fn in a transaction holding the migration lock, with the set of
+// applied versions read under that lock. schema_migrations is created under
+// the lock too, so replicas starting on an empty database do not race to
+// create it.
+func (m *Migrator) step(ctx context.Context, fn func(tx *gorm.DB, done map[int64]SchemaMigration) error) error {
+	return m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if tx.Dialector.Name() == "postgres" {
+			if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", AdvisoryLockID).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+			version BIGINT PRIMARY KEY,
+			name VARCHAR(255) NOT NULL,
+			applied_at TIMESTAMP NOT NULL
+		)`).Error; err != nil {
+			return err
+		}
+		done, err := m.applied(tx)
+		if err != nil {
+			return err
+		}
+		return fn(tx, done)
+	})
+}
+
+// applied reads schema_migrations, rejecting versions this binary does not
+// ship, which means the database is ahead of the code.
+func (m *Migrator) applied(db *gorm.DB) (map[int64]SchemaMigration, error) {
+	done := map[int64]SchemaMigration{}
+	if !db.Migrator().HasTable(&SchemaMigration{}) {
+		return done, nil
+	}
+	var rows []SchemaMigration
+	if err := db.Order("version").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	known := map[int64]bool{}
+	for _, mig := range m.migrations {
+		known[mig.Version] = true
+	}
+	for _, row := range rows {
+		if !known[row.Version] {
+			return nil, fmt.Errorf("%w: %d_%s", ErrUnknownVersion, row.Version, row.Name)
+		}
+		done[row.Version] = row
+	}
+	return done, nil
+}
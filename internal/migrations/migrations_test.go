@@ -0,0 +1,44 @@
+//go:build unit
+
+package migrations_test
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/ichigo7diabol/go-test-wallet/internal/migrations"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad_PairsAndOrders(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0010_second.up.sql":   {Data: []byte("up 10")},
+		"0010_second.down.sql": {Data: []byte("down 10")},
+		"0002_first.up.sql":    {Data: []byte("up 2")},
+		"0002_first.down.sql":  {Data: []byte("down 2")},
+		"README.md":            {Data: []byte("ignored")},
+	}
+
+	got, err := migrations.Load(fsys)
+	require.NoError(t, err)
+	require.Len(t, got, 2)
+	assert.Equal(t, migrations.Migration{Version: 2, Name: "first", Up: "up 2", Down: "down 2"}, got[0])
+	assert.Equal(t, migrations.Migration{Version: 10, Name: "second", Up: "up 10", Down: "down 10"}, got[1])
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	cases := map[string]fstest.MapFS{
+		"missing down": {
+			"0001_init.up.sql": {Data: []byte("up")},
+		},
+		"two names": {
+			"0001_init.up.sql":    {Data: []byte("up")},
+			"0001_other.down.sql": {Data: []byte("down")},
+		},
+	}
+	for name, fsys := range cases {
+		_, err := migrations.Load(fsys)
+		assert.ErrorIs(t, err, migrations.ErrInvalidMigration, name)
+	}
+}
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS hold_models;
+DROP TABLE IF EXISTS idempotency_key_models;
+DROP TABLE IF EXISTS transaction_models;
+DROP TABLE IF EXISTS wallet_models;
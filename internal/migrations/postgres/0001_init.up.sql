@@ -0,0 +1,72 @@
+-- Baseline schema. A database created by GORM AutoMigrate before migrations
+-- already has wallet_models, at its oldest (id, balance real, created_at,
+-- updated_at), which CREATE TABLE IF NOT EXISTS leaves alone: the columns
+-- added since are added to it and a float balance becomes numeric.
+CREATE TABLE IF NOT EXISTS wallet_models (
+    id         uuid PRIMARY KEY,
+    balance    numeric NOT NULL,
+    held       numeric NOT NULL DEFAULT 0,
+    currency   char(3) NOT NULL DEFAULT 'USD',
+    tenant_id  varchar(64) NOT NULL DEFAULT '',
+    owner_id   varchar(255) NOT NULL DEFAULT '',
+    created_at timestamptz,
+    updated_at timestamptz
+);
+ALTER TABLE wallet_models
+    ADD COLUMN IF NOT EXISTS held numeric NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS currency char(3) NOT NULL DEFAULT 'USD',
+    ADD COLUMN IF NOT EXISTS tenant_id varchar(64) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS owner_id varchar(255) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS created_at timestamptz,
+    ADD COLUMN IF NOT EXISTS updated_at timestamptz;
+DO $$
+BEGIN
+    IF (SELECT data_type FROM information_schema.columns
+        WHERE table_schema = current_schema()
+          AND table_name = 'wallet_models'
+          AND column_name = 'balance') <> 'numeric' THEN
+        ALTER TABLE wallet_models ALTER COLUMN balance TYPE numeric USING balance::numeric;
+    END IF;
+END
+$$;
+CREATE INDEX IF NOT EXISTS idx_wallet_models_tenant_id ON wallet_models (tenant_id);
+CREATE INDEX IF NOT EXISTS idx_wallet_models_owner_id ON wallet_models (owner_id);
+
+CREATE TABLE IF NOT EXISTS transaction_models (
+    id             uuid PRIMARY KEY,
+    tenant_id      varchar(64) NOT NULL DEFAULT '',
+    wallet_id      uuid NOT NULL,
+    operation_type text NOT NULL,
+    amount         numeric NOT NULL,
+    balance_before numeric NOT NULL,
+    balance_after  numeric NOT NULL,
+    currency       char(3) NOT NULL,
+    metadata       text,
+    created_at     timestamptz NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_transaction_wallet_created ON transaction_models (wallet_id, created_at);
+
+CREATE TABLE IF NOT EXISTS idempotency_key_models (
+    tenant_id   varchar(64),
+    key         varchar(255),
+    fingerprint char(64) NOT NULL,
+    response    bytea,
+    created_at  timestamptz,
+    PRIMARY KEY (tenant_id, key)
+);
+
+CREATE TABLE IF NOT EXISTS hold_models (
+    id              uuid PRIMARY KEY,
+    tenant_id       varchar(64) NOT NULL DEFAULT '',
+    wallet_id       uuid NOT NULL,
+    amount          numeric NOT NULL,
+    captured_amount numeric NOT NULL DEFAULT 0,
+    currency        char(3) NOT NULL,
+    status          varchar(16) NOT NULL,
+    expires_at      timestamptz NOT NULL,
+    metadata        text,
+    created_at      timestamptz,
+    updated_at      timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_hold_models_wallet_id ON hold_models (wallet_id);
+CREATE INDEX IF NOT EXISTS idx_hold_status_expires ON hold_models (status, expires_at);
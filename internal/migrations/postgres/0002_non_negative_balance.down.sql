@@ -0,0 +1,3 @@
+ALTER TABLE wallet_models
+    DROP CONSTRAINT IF EXISTS chk_wallet_models_held_non_negative,
+    DROP CONSTRAINT IF EXISTS chk_wallet_models_balance_non_negative;
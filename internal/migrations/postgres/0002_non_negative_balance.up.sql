@@ -0,0 +1,4 @@
+-- Last line of defence behind the service's insufficient-funds checks.
+ALTER TABLE wallet_models
+    ADD CONSTRAINT chk_wallet_models_balance_non_negative CHECK (balance >= 0),
+    ADD CONSTRAINT chk_wallet_models_held_non_negative CHECK (held >= 0);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS balance_slot_models;
+ALTER TABLE wallet_models DROP COLUMN IF EXISTS balance_slots;
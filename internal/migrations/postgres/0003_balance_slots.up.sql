@@ -0,0 +1,10 @@
+-- Sharded balances: a wallet with balance_slots > 0 keeps part of its
+-- balance in that many balance_slot_models rows.
+ALTER TABLE wallet_models ADD COLUMN IF NOT EXISTS balance_slots integer NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS balance_slot_models (
+    wallet_id uuid NOT NULL,
+    slot      integer NOT NULL,
+    balance   numeric NOT NULL CONSTRAINT chk_balance_slot_models_balance_non_negative CHECK (balance >= 0),
+    PRIMARY KEY (wallet_id, slot)
+);
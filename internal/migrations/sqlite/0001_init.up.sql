@@ -0,0 +1,54 @@
+-- SQLite has no exact decimal type, so amounts are stored as their decimal
+-- text. SQLite cannot add constraints to an existing table either, so the
+-- non-negative balance checks PostgreSQL gets in 0002 are created here.
+CREATE TABLE IF NOT EXISTS wallet_models (
+    id         text PRIMARY KEY,
+    balance    text NOT NULL CHECK (CAST(balance AS NUMERIC) >= 0),
+    held       text NOT NULL DEFAULT '0' CHECK (CAST(held AS NUMERIC) >= 0),
+    currency   char(3) NOT NULL DEFAULT 'USD',
+    tenant_id  varchar(64) NOT NULL DEFAULT '',
+    owner_id   varchar(255) NOT NULL DEFAULT '',
+    created_at datetime,
+    updated_at datetime
+);
+CREATE INDEX IF NOT EXISTS idx_wallet_models_tenant_id ON wallet_models (tenant_id);
+CREATE INDEX IF NOT EXISTS idx_wallet_models_owner_id ON wallet_models (owner_id);
+
+CREATE TABLE IF NOT EXISTS transaction_models (
+    id             text PRIMARY KEY,
+    tenant_id      varchar(64) NOT NULL DEFAULT '',
+    wallet_id      text NOT NULL,
+    operation_type text NOT NULL,
+    amount         text NOT NULL,
+    balance_before text NOT NULL,
+    balance_after  text NOT NULL,
+    currency       char(3) NOT NULL,
+    metadata       text,
+    created_at     datetime NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_transaction_wallet_created ON transaction_models (wallet_id, created_at);
+
+CREATE TABLE IF NOT EXISTS idempotency_key_models (
+    tenant_id   varchar(64),
+    key         varchar(255),
+    fingerprint char(64) NOT NULL,
+    response    blob,
+    created_at  datetime,
+    PRIMARY KEY (tenant_id, key)
+);
+
+CREATE TABLE IF NOT EXISTS hold_models (
+    id              text PRIMARY KEY,
+    tenant_id       varchar(64) NOT NULL DEFAULT '',
+    wallet_id       text NOT NULL,
+    amount          text NOT NULL,
+    captured_amount text NOT NULL DEFAULT '0',
+    currency        char(3) NOT NULL,
+    status          varchar(16) NOT NULL,
+    expires_at      datetime NOT NULL,
+    metadata        text,
+    created_at      datetime,
+    updated_at      datetime
+);
+CREATE INDEX IF NOT EXISTS idx_hold_models_wallet_id ON hold_models (wallet_id);
+CREATE INDEX IF NOT EXISTS idx_hold_status_expires ON hold_models (status, expires_at);
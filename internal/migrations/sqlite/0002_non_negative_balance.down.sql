@@ -0,0 +1,3 @@
+-- The checks are part of wallet_models since 0001; this keeps the version
+-- numbers aligned with PostgreSQL.
+SELECT 1;
@@ -0,0 +1,2 @@
+ALTER TABLE balance_slot_models DROP COLUMN version;
+ALTER TABLE wallet_models DROP COLUMN version;
@@ -0,0 +1,5 @@
+-- Optimistic concurrency: every change to a wallet increments its version,
+-- and a deposit into a balance slot increments the slot's. A sharded
+-- wallet's version is the sum of both.
+ALTER TABLE wallet_models ADD COLUMN version integer NOT NULL DEFAULT 1;
+ALTER TABLE balance_slot_models ADD COLUMN version integer NOT NULL DEFAULT 0;
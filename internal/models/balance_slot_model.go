@@ -0,0 +1,15 @@
+package models
+
+import "github.com/google/uuid"
+
+// BalanceSlotModel holds part of the balance of a wallet with BalanceSlots
+// set. Deposits credit one slot so that concurrent deposits do not all wait
+// for the wallet row; the wallet's balance is its own Balance plus the sum of
+// its slots.
+type BalanceSlotModel struct {
+	WalletID uuid.UUID `gorm:"type:uuid;primaryKey"`
+	Slot     int       `gorm:"primaryKey;autoIncrement:false"`
+	Balance  Money     `gorm:"not null"`
+	// Version counts the deposits into the slot.
+	Version int64 `gorm:"not null;default:0"`
+}
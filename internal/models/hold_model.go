@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type HoldStatus string
+
+const (
+	HoldActive   HoldStatus = "ACTIVE"
+	HoldCaptured HoldStatus = "CAPTURED"
+	HoldVoided   HoldStatus = "VOIDED"
+	HoldExpired  HoldStatus = "EXPIRED"
+)
+
+// HoldModel reserves part of a wallet's balance. While ACTIVE its Amount is
+// counted in WalletModel.Held; capturing, voiding or expiring releases it.
+type HoldModel struct {
+	ID             uuid.UUID         `gorm:"type:uuid;primaryKey"`
+	TenantID       string            `gorm:"type:varchar(64);not null;default:''"`
+	WalletID       uuid.UUID         `gorm:"type:uuid;not null;index"`
+	Amount         Money             `gorm:"not null"`
+	CapturedAmount Money             `gorm:"not null;default:0"`
+	Currency       string            `gorm:"type:char(3);not null"`
+	Status         HoldStatus        `gorm:"type:varchar(16);not null;index:idx_hold_status_expires,priority:1"`
+	ExpiresAt      time.Time         `gorm:"not null;index:idx_hold_status_expires,priority:2"`
+	Metadata       map[string]string `gorm:"serializer:json"`
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	// WalletVersion is the version of the wallet after the change that
+	// returned the hold. It is not stored and is zero on holds that are only
+	// read.
+	WalletVersion int64 `gorm:"-"`
+}
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// IdempotencyKeyModel stores the outcome of a request made with an
+// Idempotency-Key header so that retries can be answered without repeating
+// the balance change. Keys are unique per tenant.
+type IdempotencyKeyModel struct {
+	TenantID    string `gorm:"type:varchar(64);primaryKey"`
+	Key         string `gorm:"type:varchar(255);primaryKey"`
+	Fingerprint string `gorm:"type:char(64);not null"`
+	Response    []byte
+	CreatedAt   time.Time
+}
@@ -0,0 +1,174 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+
+	"github.com/woodsbury/decimal128"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+var (
+	ErrInvalidMoney    = errors.New("invalid money value")
+	ErrMoneyOutOfRange = errors.New("money value cannot be represented exactly")
+)
+
+// Money is an exact decimal amount. It is stored as NUMERIC in PostgreSQL
+// (TEXT in SQLite, which has no exact decimal type) and encoded as a JSON
+// string so clients never round-trip it through a float.
+type Money struct {
+	value decimal128.Decimal
+}
+
+func newMoney(d decimal128.Decimal) Money {
+	return Money{value: d.Canonical()}
+}
+
+func MoneyFromInt(i int64) Money {
+	return newMoney(decimal128.FromInt64(i))
+}
+
+// ParseMoney parses a decimal amount. Amounts that a decimal128 cannot hold
+// exactly, such as ones with more than 34 significant digits, are rejected
+// rather than rounded.
+func ParseMoney(s string) (Money, error) {
+	d, err := decimal128.Parse(s)
+	if err != nil || d.IsNaN() || d.IsInf(0) {
+		return Money{}, fmt.Errorf("%w: %q", ErrInvalidMoney, s)
+	}
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return Money{}, fmt.Errorf("%w: %q", ErrInvalidMoney, s)
+	}
+	if !exact(d, r) {
+		return Money{}, fmt.Errorf("%w: %w: %q", ErrInvalidMoney, ErrMoneyOutOfRange, s)
+	}
+	return newMoney(d), nil
+}
+
+func MustParseMoney(s string) Money {
+	m, err := ParseMoney(s)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// Add returns m + o, or ErrMoneyOutOfRange if the sum would have to be
+// rounded or overflows.
+func (m Money) Add(o Money) (Money, error) {
+	sum := new(big.Rat).Add(m.value.Rat(nil), o.value.Rat(nil))
+	return checked(m.value.Add(o.value), sum)
+}
+
+// Sub returns m - o, or ErrMoneyOutOfRange if the difference would have to be
+// rounded or overflows.
+func (m Money) Sub(o Money) (Money, error) {
+	diff := new(big.Rat).Sub(m.value.Rat(nil), o.value.Rat(nil))
+	return checked(m.value.Sub(o.value), diff)
+}
+
+// checked is d as Money if it holds the exact result r.
+func checked(d decimal128.Decimal, r *big.Rat) (Money, error) {
+	if !exact(d, r) {
+		return Money{}, ErrMoneyOutOfRange
+	}
+	return newMoney(d), nil
+}
+
+// exact reports whether d is finite and equal to r.
+func exact(d decimal128.Decimal, r *big.Rat) bool {
+	return !d.IsNaN() && !d.IsInf(0) && d.Rat(nil).Cmp(r) == 0
+}
+
+// Cmp returns -1, 0 or +1 depending on whether m is less than, equal to or
+// greater than o.
+func (m Money) Cmp(o Money) int {
+	return decimal128.Compare(m.value, o.value)
+}
+
+func (m Money) IsZero() bool {
+	return m.value.IsZero()
+}
+
+func (m Money) IsNegative() bool {
+	return m.value.Sign() < 0
+}
+
+// Float64 approximates m for reporting, e.g. in metrics. Never use it for
+// arithmetic on balances.
+func (m Money) Float64() float64 {
+	return m.value.Float64()
+}
+
+// Scale returns the number of significant digits after the decimal point.
+func (m Money) Scale() int {
+	_, _, _, exp := m.value.Decompose(nil)
+	if exp >= 0 {
+		return 0
+	}
+	return int(-exp)
+}
+
+func (m Money) String() string {
+	return decimal128.Format(m.value, 'f', -1)
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.String())
+}
+
+// UnmarshalJSON accepts both string-encoded and bare numeric amounts.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		s = string(data)
+	}
+	v, err := ParseMoney(s)
+	if err != nil {
+		return err
+	}
+	*m = v
+	return nil
+}
+
+func (m Money) Value() (driver.Value, error) {
+	return m.String(), nil
+}
+
+func (m *Money) Scan(src any) error {
+	var s string
+	switch v := src.(type) {
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	case int64:
+		s = strconv.FormatInt(v, 10)
+	case nil:
+		*m = Money{}
+		return nil
+	default:
+		return fmt.Errorf("%w: unsupported type %T", ErrInvalidMoney, src)
+	}
+	v, err := ParseMoney(s)
+	if err != nil {
+		return err
+	}
+	*m = v
+	return nil
+}
+
+func (Money) GormDBDataType(db *gorm.DB, _ *schema.Field) string {
+	switch db.Dialector.Name() {
+	case "sqlite":
+		return "text"
+	default:
+		return "numeric"
+	}
+}
@@ -0,0 +1,120 @@
+//go:build unit
+
+package models_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/ichigo7diabol/go-test-wallet/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMoney_AddIsExact(t *testing.T) {
+	sum := models.MoneyFromInt(0)
+	for i := 0; i < 10; i++ {
+		var err error
+		sum, err = sum.Add(models.MustParseMoney("0.1"))
+		require.NoError(t, err)
+	}
+	assert.Equal(t, models.MoneyFromInt(1), sum)
+	assert.Equal(t, "1", sum.String())
+}
+
+func TestMoney_AddRejectsInexactSum(t *testing.T) {
+	largest := models.MustParseMoney("9999999999999999999999999999999999")
+	_, err := largest.Add(models.MustParseMoney("0.01"))
+	assert.ErrorIs(t, err, models.ErrMoneyOutOfRange)
+	_, err = models.MustParseMoney("-9999999999999999999999999999999999").Sub(models.MustParseMoney("0.01"))
+	assert.ErrorIs(t, err, models.ErrMoneyOutOfRange)
+
+	huge := models.MustParseMoney("9e6144")
+	_, err = huge.Add(huge)
+	assert.ErrorIs(t, err, models.ErrMoneyOutOfRange)
+	_, err = models.MustParseMoney("-9e6144").Sub(huge)
+	assert.ErrorIs(t, err, models.ErrMoneyOutOfRange)
+
+	sum, err := largest.Add(models.MustParseMoney("-1"))
+	require.NoError(t, err)
+	assert.Equal(t, "9999999999999999999999999999999998", sum.String())
+}
+
+func TestMoney_Scale(t *testing.T) {
+	assert.Equal(t, 0, models.MustParseMoney("100").Scale())
+	assert.Equal(t, 0, models.MustParseMoney("100.00").Scale())
+	assert.Equal(t, 1, models.MustParseMoney("100.50").Scale())
+	assert.Equal(t, 3, models.MustParseMoney("0.001").Scale())
+}
+
+func TestMoney_Parse_Invalid(t *testing.T) {
+	for _, s := range []string{
+		"", "abc", "NaN", "Inf", "1/2",
+		// Would be rounded to fit 34 significant digits or underflow to 0.
+		"1234567890123456789012345678901234.56",
+		"1e-7000",
+	} {
+		_, err := models.ParseMoney(s)
+		assert.ErrorIs(t, err, models.ErrInvalidMoney, s)
+	}
+}
+
+func TestMoney_Parse_OutOfRange(t *testing.T) {
+	_, err := models.ParseMoney("1234567890123456789012345678901234.56")
+	assert.ErrorIs(t, err, models.ErrMoneyOutOfRange)
+
+	var m models.Money
+	assert.ErrorIs(t, m.Scan("99999999999999999999999999999999999"), models.ErrMoneyOutOfRange)
+}
+
+func TestMoney_Parse_Exact(t *testing.T) {
+	for s, want := range map[string]string{
+		"1234567890123456789012345678901234": "1234567890123456789012345678901234",
+		"00012.3400":                         "12.34",
+		"1.5e3":                              "1500",
+		".5":                                 "0.5",
+	} {
+		m, err := models.ParseMoney(s)
+		require.NoError(t, err, s)
+		assert.Equal(t, want, m.String(), s)
+	}
+}
+
+func TestMoney_JSON(t *testing.T) {
+	data, err := json.Marshal(models.MustParseMoney("12345678901234567890.12"))
+	require.NoError(t, err)
+	assert.Equal(t, `"12345678901234567890.12"`, string(data))
+
+	var m models.Money
+	require.NoError(t, json.Unmarshal([]byte(`"2500.50"`), &m))
+	assert.Equal(t, models.MustParseMoney("2500.5"), m)
+
+	require.NoError(t, json.Unmarshal([]byte(`10.25`), &m))
+	assert.Equal(t, models.MustParseMoney("10.25"), m)
+}
+
+func TestMoney_Scan(t *testing.T) {
+	var m models.Money
+	require.NoError(t, m.Scan([]byte("42.10")))
+	assert.Equal(t, models.MustParseMoney("42.1"), m)
+
+	require.NoError(t, m.Scan(int64(7)))
+	assert.Equal(t, models.MoneyFromInt(7), m)
+
+	assert.ErrorIs(t, m.Scan(true), models.ErrInvalidMoney)
+	// A float has already lost the exact amount.
+	assert.ErrorIs(t, m.Scan(0.1), models.ErrInvalidMoney)
+}
+
+func TestLookupCurrency(t *testing.T) {
+	c, ok := models.LookupCurrency("usd")
+	require.True(t, ok)
+	assert.Equal(t, models.Currency{Code: "USD", MinorUnits: 2}, c)
+
+	c, ok = models.LookupCurrency("KWD")
+	require.True(t, ok)
+	assert.Equal(t, 3, c.MinorUnits)
+
+	_, ok = models.LookupCurrency("XAU")
+	assert.False(t, ok)
+}
@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TransactionModel is an append-only ledger entry written in the same
+// database transaction as the balance change it describes.
+type TransactionModel struct {
+	ID            uuid.UUID         `gorm:"type:uuid;primaryKey"`
+	TenantID      string            `gorm:"type:varchar(64);not null;default:''"`
+	WalletID      uuid.UUID         `gorm:"type:uuid;not null;index:idx_transaction_wallet_created,priority:1"`
+	OperationType string            `gorm:"not null"`
+	Amount        Money             `gorm:"not null"`
+	BalanceBefore Money             `gorm:"not null"`
+	BalanceAfter  Money             `gorm:"not null"`
+	Currency      string            `gorm:"type:char(3);not null"`
+	Metadata      map[string]string `gorm:"serializer:json"`
+	CreatedAt     time.Time         `gorm:"not null;index:idx_transaction_wallet_created,priority:2"`
+}
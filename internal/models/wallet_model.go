@@ -1,14 +1,39 @@
 package models
 
 import (
+	"math/big"
 	"time"
 
 	"github.com/google/uuid"
 )
 
 type WalletModel struct {
-	ID        uuid.UUID `gorm:"type:uuid;primaryKey"`
-	Balance   float32   `gorm:"not null"`
+	ID       uuid.UUID `gorm:"type:uuid;primaryKey"`
+	Balance  Money     `gorm:"not null"`
+	Held     Money     `gorm:"not null;default:0"`
+	Currency string    `gorm:"type:char(3);not null;default:'USD'"`
+	// TenantID scopes the wallet; no query crosses tenants.
+	TenantID string `gorm:"type:varchar(64);not null;default:'';index"`
+	// OwnerID is the subject of the principal that created the wallet.
+	OwnerID string `gorm:"type:varchar(255);not null;default:'';index"`
+	// BalanceSlots is the number of BalanceSlotModel rows that hold part of
+	// the balance; zero for a wallet whose balance is all in Balance.
+	BalanceSlots int `gorm:"not null;default:0"`
+	// Version counts the changes to the wallet, starting at 1. For a
+	// sharded wallet it includes the versions of its slots.
+	Version   int64 `gorm:"not null;default:1"`
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
+
+// Available is the part of the balance not reserved by active holds.
+func (w *WalletModel) Available() (Money, error) {
+	return w.Balance.Sub(w.Held)
+}
+
+// Covers reports whether the available balance is at least amount. Unlike
+// Available it cannot fail, so it suits checks before a debit.
+func (w *WalletModel) Covers(amount Money) bool {
+	available := new(big.Rat).Sub(w.Balance.value.Rat(nil), w.Held.value.Rat(nil))
+	return available.Cmp(amount.value.Rat(nil)) >= 0
+}
@@ -0,0 +1,58 @@
+//go:build integration
+
+package integration_test
+
+import (
+	"testing"
+
+	"github.com/ichigo7diabol/go-test-wallet/internal/app"
+	"github.com/ichigo7diabol/go-test-wallet/internal/models"
+	"github.com/stretchr/testify/require"
+)
+
+// BenchmarkPostgres_BalanceUpdates compares deposits and withdrawals that lock
+// the wallet row, change it in Go and save it back with ones that change it in
+// a single conditional UPDATE. Both append a ledger entry in the same
+// transaction.
+func BenchmarkPostgres_BalanceUpdates(b *testing.B) {
+	dsn := postgresDSN(b)
+	amount := models.MustParseMoney("0.01")
+	for _, mode := range []struct {
+		name   string
+		atomic bool
+	}{
+		{"locking", false},
+		{"atomic", true},
+	} {
+		b.Run(mode.name, func(b *testing.B) {
+			repo := app.NewRepository(setupPostgresDB(b, dsn)).WithAtomicUpdates(mode.atomic)
+			w, err := repo.Create(models.MoneyFromInt(1_000_000_000), "USD", "user-1")
+			require.NoError(b, err)
+
+			b.Run("deposit", func(b *testing.B) {
+				for b.Loop() {
+					if _, _, _, err := repo.Deposit(w.ID, amount, "USD", nil); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+			b.Run("withdraw", func(b *testing.B) {
+				for b.Loop() {
+					if _, _, _, err := repo.Withdraw(w.ID, amount, "USD", nil); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+			b.Run("parallel", func(b *testing.B) {
+				b.RunParallel(func(pb *testing.PB) {
+					for pb.Next() {
+						if _, _, _, err := repo.Deposit(w.ID, amount, "USD", nil); err != nil {
+							b.Error(err)
+							return
+						}
+					}
+				})
+			})
+		})
+	}
+}
@@ -0,0 +1,148 @@
+//go:build integration
+
+package integration_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ichigo7diabol/go-test-wallet/internal/app"
+	"github.com/ichigo7diabol/go-test-wallet/internal/models"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// slotsOf returns the wallet row's own balance and the sum of its slots.
+func slotsOf(t *testing.T, db *gorm.DB, w *models.WalletModel) (own models.Money, slots models.Money) {
+	var row models.WalletModel
+	require.NoError(t, db.First(&row, "id = ?", w.ID).Error)
+	var rows []models.BalanceSlotModel
+	require.NoError(t, db.Find(&rows, "wallet_id = ?", w.ID).Error)
+	for _, s := range rows {
+		var err error
+		slots, err = slots.Add(s.Balance)
+		require.NoError(t, err)
+	}
+	return row.Balance, slots
+}
+
+func TestBalanceSlots_DepositsGoToSlots(t *testing.T) {
+	db := setupSQLiteFileDB(t)
+	repo := app.NewRepository(db).WithBalanceSlots(4)
+	w, err := repo.Create(models.MoneyFromInt(10), "USD", "user-1")
+	require.NoError(t, err)
+	require.Equal(t, 4, w.BalanceSlots)
+
+	for range 20 {
+		_, _, _, err := repo.Deposit(w.ID, models.MoneyFromInt(1), "USD", nil)
+		require.NoError(t, err)
+	}
+
+	own, slots := slotsOf(t, db, w)
+	require.Equal(t, models.MoneyFromInt(10), own)
+	require.Equal(t, models.MoneyFromInt(20), slots)
+
+	got, err := repo.GetByID(w.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.MoneyFromInt(30), got.Balance)
+	list, err := repo.ListByOwner("user-1")
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	require.Equal(t, models.MoneyFromInt(30), list[0].Balance)
+}
+
+func TestBalanceSlots_WithdrawSweepsSlots(t *testing.T) {
+	db := setupSQLiteFileDB(t)
+	repo := app.NewRepository(db).WithBalanceSlots(4)
+	w, err := repo.Create(models.MoneyFromInt(10), "USD", "user-1")
+	require.NoError(t, err)
+	for range 8 {
+		_, _, _, err := repo.Deposit(w.ID, models.MoneyFromInt(1), "USD", nil)
+		require.NoError(t, err)
+	}
+
+	// The wallet row covers this one, so the slots are left alone.
+	oldBalance, newBalance, _, err := repo.Withdraw(w.ID, models.MoneyFromInt(4), "USD", nil)
+	require.NoError(t, err)
+	require.Equal(t, models.MoneyFromInt(18), oldBalance)
+	require.Equal(t, models.MoneyFromInt(14), newBalance)
+	own, slots := slotsOf(t, db, w)
+	require.Equal(t, models.MoneyFromInt(6), own)
+	require.Equal(t, models.MoneyFromInt(8), slots)
+
+	oldBalance, newBalance, _, err = repo.Withdraw(w.ID, models.MoneyFromInt(9), "USD", nil)
+	require.NoError(t, err)
+	require.Equal(t, models.MoneyFromInt(14), oldBalance)
+	require.Equal(t, models.MoneyFromInt(5), newBalance)
+	own, slots = slotsOf(t, db, w)
+	require.Equal(t, models.MoneyFromInt(5), own)
+	require.True(t, slots.IsZero(), "slots hold %s", slots)
+
+	_, _, _, err = repo.Withdraw(w.ID, models.MoneyFromInt(6), "USD", nil)
+	require.ErrorIs(t, err, app.ErrInsufficientFunds)
+}
+
+func TestBalanceSlots_DeleteRemovesSlots(t *testing.T) {
+	db := setupSQLiteFileDB(t)
+	repo := app.NewRepository(db).WithBalanceSlots(4)
+	w, err := repo.Create(models.MoneyFromInt(10), "USD", "user-1")
+	require.NoError(t, err)
+
+	require.NoError(t, repo.Delete(w.ID))
+	var count int64
+	require.NoError(t, db.Model(&models.BalanceSlotModel{}).Where("wallet_id = ?", w.ID).Count(&count).Error)
+	require.Zero(t, count)
+	_, _, _, err = repo.Deposit(w.ID, models.MoneyFromInt(1), "USD", nil)
+	require.ErrorIs(t, err, app.ErrWalletNotFound)
+}
+
+// TestBalanceSlots_PlainRepositoryKeepsSlots checks that a sharded wallet
+// stays whole after sharding is turned off.
+func TestBalanceSlots_PlainRepositoryKeepsSlots(t *testing.T) {
+	db := setupSQLiteFileDB(t)
+	sharded := app.NewRepository(db).WithBalanceSlots(4)
+	w, err := sharded.Create(models.MoneyFromInt(0), "USD", "user-1")
+	require.NoError(t, err)
+	_, _, _, err = sharded.Deposit(w.ID, models.MoneyFromInt(5), "USD", nil)
+	require.NoError(t, err)
+
+	plain := app.NewRepository(db)
+	oldBalance, newBalance, _, err := plain.Deposit(w.ID, models.MoneyFromInt(1), "USD", nil)
+	require.NoError(t, err)
+	require.Equal(t, models.MoneyFromInt(5), oldBalance)
+	require.Equal(t, models.MoneyFromInt(6), newBalance)
+	_, newBalance, _, err = plain.Withdraw(w.ID, models.MoneyFromInt(6), "USD", nil)
+	require.NoError(t, err)
+	require.True(t, newBalance.IsZero(), "balance is %s", newBalance)
+}
+
+// BenchmarkPostgres_HotWalletDeposits deposits into a single wallet from many
+// goroutines. A plain wallet commits one deposit at a time behind its row
+// lock; with slots, that many deposits can commit at once. SQLite serializes
+// every write transaction, so slots cannot help there.
+func BenchmarkPostgres_HotWalletDeposits(b *testing.B) {
+	dsn := postgresDSN(b)
+	for _, slots := range []int{0, 4, 16} {
+		b.Run(fmt.Sprintf("slots=%d", slots), func(b *testing.B) {
+			db := setupPostgresDB(b, dsn)
+			sqlDB, err := db.DB()
+			require.NoError(b, err)
+			sqlDB.SetMaxOpenConns(64)
+			repo := app.NewRepository(db).WithBalanceSlots(slots)
+			w, err := repo.Create(models.MoneyFromInt(0), "USD", "user-1")
+			require.NoError(b, err)
+			amount := models.MustParseMoney("0.01")
+
+			b.SetParallelism(32)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, _, _, err := repo.Deposit(w.ID, amount, "USD", nil); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,117 @@
+//go:build integration
+
+package integration_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/ichigo7diabol/go-test-wallet/internal/app"
+	"github.com/ichigo7diabol/go-test-wallet/internal/app/apptest"
+	"github.com/ichigo7diabol/go-test-wallet/internal/database"
+	"github.com/ichigo7diabol/go-test-wallet/internal/migrations"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+const (
+	// PostgresDSNEnv names the database the PostgreSQL suites run against,
+	// e.g. the db service of the test compose profile. They are skipped when
+	// unset.
+	PostgresDSNEnv = "WALLET_APP_TEST_POSTGRES_DSN"
+	// PostgresRequiredEnv set to true fails the PostgreSQL suites instead of
+	// skipping them without a database, so CI cannot pass without running
+	// them.
+	PostgresRequiredEnv = "WALLET_APP_TEST_POSTGRES_REQUIRED"
+)
+
+func TestSQLiteFile_Concurrency(t *testing.T) {
+	apptest.RunConcurrencyConformance(t, func(t *testing.T) app.WalletRepositoryService {
+		return app.NewRepository(setupSQLiteFileDB(t))
+	}, apptest.ConcurrencyOptions{})
+}
+
+func TestSQLiteFile_ShardedConcurrency(t *testing.T) {
+	apptest.RunConcurrencyConformance(t, func(t *testing.T) app.WalletRepositoryService {
+		return app.NewRepository(setupSQLiteFileDB(t)).WithBalanceSlots(4)
+	}, apptest.ConcurrencyOptions{
+		// SQLite's busy handler does not queue writers fairly, so in a long
+		// run under the race detector one can wait out the busy timeout.
+		Operations:        1000,
+		UnorderedDeposits: true,
+	})
+}
+
+// postgresDSN returns the database named by PostgresDSNEnv or skips t.
+func postgresDSN(t testing.TB) string {
+	dsn := os.Getenv(PostgresDSNEnv)
+	switch {
+	case dsn != "":
+		return dsn
+	case os.Getenv(PostgresRequiredEnv) == "true":
+		t.Fatalf("%s is not set but %s is", PostgresDSNEnv, PostgresRequiredEnv)
+	default:
+		t.Skipf("%s is not set", PostgresDSNEnv)
+	}
+	return ""
+}
+
+// setupPostgresDB migrates the database at dsn and empties it, so every test
+// starts from a clean schema.
+func setupPostgresDB(t testing.TB, dsn string) *gorm.DB {
+	db, err := database.Open(dsn)
+	require.NoError(t, err)
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	m, err := migrations.New(db)
+	require.NoError(t, err)
+	_, err = m.Up(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, db.Exec("TRUNCATE wallet_models, balance_slot_models, transaction_models, idempotency_key_models, hold_models").Error)
+	return db
+}
+
+func TestPostgres_Conformance(t *testing.T) {
+	dsn := postgresDSN(t)
+	apptest.RunRepositoryConformance(t, func(t *testing.T) app.WalletRepositoryService {
+		return app.NewRepository(setupPostgresDB(t, dsn))
+	})
+}
+
+func TestPostgres_Concurrency(t *testing.T) {
+	dsn := postgresDSN(t)
+	apptest.RunConcurrencyConformance(t, func(t *testing.T) app.WalletRepositoryService {
+		return app.NewRepository(setupPostgresDB(t, dsn))
+	}, apptest.ConcurrencyOptions{})
+}
+
+func TestPostgres_ShardedConformance(t *testing.T) {
+	dsn := postgresDSN(t)
+	apptest.RunRepositoryConformance(t, func(t *testing.T) app.WalletRepositoryService {
+		return app.NewRepository(setupPostgresDB(t, dsn)).WithBalanceSlots(4)
+	})
+}
+
+func TestPostgres_ShardedConcurrency(t *testing.T) {
+	dsn := postgresDSN(t)
+	apptest.RunConcurrencyConformance(t, func(t *testing.T) app.WalletRepositoryService {
+		return app.NewRepository(setupPostgresDB(t, dsn)).WithBalanceSlots(4)
+	}, apptest.ConcurrencyOptions{UnorderedDeposits: true})
+}
+
+func TestPostgres_AtomicConformance(t *testing.T) {
+	dsn := postgresDSN(t)
+	apptest.RunRepositoryConformance(t, func(t *testing.T) app.WalletRepositoryService {
+		return app.NewRepository(setupPostgresDB(t, dsn)).WithAtomicUpdates(true)
+	})
+}
+
+func TestPostgres_AtomicConcurrency(t *testing.T) {
+	dsn := postgresDSN(t)
+	apptest.RunConcurrencyConformance(t, func(t *testing.T) app.WalletRepositoryService {
+		return app.NewRepository(setupPostgresDB(t, dsn)).WithAtomicUpdates(true)
+	}, apptest.ConcurrencyOptions{})
+}
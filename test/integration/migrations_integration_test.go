@@ -0,0 +1,231 @@
+//go:build integration
+
+package integration_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ichigo7diabol/go-test-wallet/internal/app"
+	"github.com/ichigo7diabol/go-test-wallet/internal/database"
+	"github.com/ichigo7diabol/go-test-wallet/internal/migrations"
+	"github.com/ichigo7diabol/go-test-wallet/internal/models"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+var testMigrations = fstest.MapFS{
+	"0001_widgets.up.sql":       {Data: []byte("CREATE TABLE widgets (id INTEGER PRIMARY KEY)")},
+	"0001_widgets.down.sql":     {Data: []byte("DROP TABLE widgets")},
+	"0002_widget_name.up.sql":   {Data: []byte("ALTER TABLE widgets ADD COLUMN name TEXT")},
+	"0002_widget_name.down.sql": {Data: []byte("ALTER TABLE widgets DROP COLUMN name")},
+}
+
+func setupMigrationsDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open("file:migrations?mode=memory&cache=shared"), &gorm.Config{})
+	require.NoError(t, err)
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	t.Cleanup(func() { sqlDB.Close() })
+	return db
+}
+
+func TestMigrator_UpDownStatus(t *testing.T) {
+	db := setupMigrationsDB(t)
+	ctx := context.Background()
+
+	m, err := migrations.NewFromFS(db, testMigrations)
+	require.NoError(t, err)
+
+	pending, err := m.Pending(ctx)
+	require.NoError(t, err)
+	require.Len(t, pending, 2)
+
+	applied, err := m.Up(ctx)
+	require.NoError(t, err)
+	require.Len(t, applied, 2)
+	require.True(t, db.Migrator().HasColumn("widgets", "name"))
+
+	// A second run is a no-op.
+	applied, err = m.Up(ctx)
+	require.NoError(t, err)
+	require.Empty(t, applied)
+
+	statuses, err := m.Status(ctx)
+	require.NoError(t, err)
+	require.Len(t, statuses, 2)
+	for _, s := range statuses {
+		require.NotNil(t, s.AppliedAt, s.Name)
+	}
+
+	rolledBack, err := m.Down(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, rolledBack, 1)
+	require.Equal(t, int64(2), rolledBack[0].Version)
+	require.False(t, db.Migrator().HasColumn("widgets", "name"))
+	require.True(t, db.Migrator().HasTable("widgets"))
+
+	pending, err = m.Pending(ctx)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	require.Equal(t, int64(2), pending[0].Version)
+
+	// Rolling back more than was applied stops at the first migration.
+	rolledBack, err = m.Down(ctx, 5)
+	require.NoError(t, err)
+	require.Len(t, rolledBack, 1)
+	require.False(t, db.Migrator().HasTable("widgets"))
+}
+
+func TestMigrator_FailedMigrationIsNotRecorded(t *testing.T) {
+	db := setupMigrationsDB(t)
+	ctx := context.Background()
+
+	m, err := migrations.NewFromFS(db, fstest.MapFS{
+		"0001_broken.up.sql":   {Data: []byte("CREATE TABLE")},
+		"0001_broken.down.sql": {Data: []byte("SELECT 1")},
+	})
+	require.NoError(t, err)
+
+	_, err = m.Up(ctx)
+	require.Error(t, err)
+
+	pending, err := m.Pending(ctx)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+}
+
+func TestMigrator_UnknownVersion(t *testing.T) {
+	db := setupMigrationsDB(t)
+	ctx := context.Background()
+
+	m, err := migrations.NewFromFS(db, testMigrations)
+	require.NoError(t, err)
+	_, err = m.Up(ctx)
+	require.NoError(t, err)
+
+	// An older binary only knows the first migration.
+	older, err := migrations.NewFromFS(db, fstest.MapFS{
+		"0001_widgets.up.sql":   testMigrations["0001_widgets.up.sql"],
+		"0001_widgets.down.sql": testMigrations["0001_widgets.down.sql"],
+	})
+	require.NoError(t, err)
+	_, err = older.Pending(ctx)
+	require.ErrorIs(t, err, migrations.ErrUnknownVersion)
+	_, err = older.Up(ctx)
+	require.ErrorIs(t, err, migrations.ErrUnknownVersion)
+}
+
+func TestMigrations_SQLiteRoundTrip(t *testing.T) {
+	db := setupMigrationsDB(t)
+	ctx := context.Background()
+
+	m, err := migrations.New(db)
+	require.NoError(t, err)
+	_, err = m.Up(ctx)
+	require.NoError(t, err)
+	require.True(t, db.Migrator().HasTable("wallet_models"))
+
+	err = db.Exec("INSERT INTO wallet_models (id, balance) VALUES ('w', '-1')").Error
+	require.Error(t, err, "balance must not go negative")
+
+	statuses, err := m.Status(ctx)
+	require.NoError(t, err)
+	_, err = m.Down(ctx, len(statuses))
+	require.NoError(t, err)
+	require.False(t, db.Migrator().HasTable("wallet_models"))
+}
+
+// baselineWallet is the wallet model of the release before migrations,
+// whose schema AutoMigrate created. Depending on the driver version its
+// float32 balance became numeric or real; real is the harder case.
+type baselineWallet struct {
+	ID        uuid.UUID `gorm:"type:uuid;primaryKey"`
+	Balance   float32   `gorm:"type:real;not null"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (baselineWallet) TableName() string {
+	return "wallet_models"
+}
+
+// setupEmptyPostgresDB opens the database at dsn with every table of the
+// service dropped.
+func setupEmptyPostgresDB(t *testing.T, dsn string) *gorm.DB {
+	db, err := database.Open(dsn)
+	require.NoError(t, err)
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	t.Cleanup(func() { sqlDB.Close() })
+	require.NoError(t, db.Exec(`DROP TABLE IF EXISTS wallet_models, balance_slot_models, transaction_models,
+		idempotency_key_models, hold_models, schema_migrations`).Error)
+	return db
+}
+
+func TestMigrations_PostgresAdoptsBaselineSchema(t *testing.T) {
+	db := setupEmptyPostgresDB(t, postgresDSN(t))
+	ctx := context.Background()
+	require.NoError(t, db.AutoMigrate(&baselineWallet{}))
+	rich := baselineWallet{ID: uuid.New(), Balance: 10.5, CreatedAt: time.Now(), UpdatedAt: time.Now()}
+	poor := baselineWallet{ID: uuid.New(), Balance: 0.1, CreatedAt: time.Now(), UpdatedAt: time.Now()}
+	require.NoError(t, db.Create([]baselineWallet{rich, poor}).Error)
+
+	m, err := migrations.New(db)
+	require.NoError(t, err)
+	_, err = m.Up(ctx)
+	require.NoError(t, err)
+
+	var balanceType string
+	require.NoError(t, db.Raw(`SELECT data_type FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = 'wallet_models' AND column_name = 'balance'`).
+		Scan(&balanceType).Error)
+	require.Equal(t, "numeric", balanceType)
+
+	repo := app.NewRepository(db)
+	w, err := repo.GetByID(rich.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.MustParseMoney("10.5"), w.Balance)
+	require.Equal(t, "USD", w.Currency)
+	require.Equal(t, int64(1), w.Version)
+	w, err = repo.GetByID(poor.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.MustParseMoney("0.1"), w.Balance)
+
+	_, newBalance, _, err := repo.Deposit(poor.ID, models.MustParseMoney("0.2"), "USD", nil)
+	require.NoError(t, err)
+	require.Equal(t, models.MustParseMoney("0.3"), newBalance)
+}
+
+func TestMigrations_PostgresConcurrentUp(t *testing.T) {
+	dsn := postgresDSN(t)
+	setupEmptyPostgresDB(t, dsn)
+	ctx := context.Background()
+
+	// Replicas starting together each open their own pool.
+	var wg sync.WaitGroup
+	errs := make([]error, 4)
+	for i := range errs {
+		db, err := database.Open(dsn)
+		require.NoError(t, err)
+		sqlDB, err := db.DB()
+		require.NoError(t, err)
+		t.Cleanup(func() { sqlDB.Close() })
+		m, err := migrations.New(db)
+		require.NoError(t, err)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = m.Up(ctx)
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+}
@@ -0,0 +1,107 @@
+//go:build integration
+
+package integration_test
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ichigo7diabol/go-test-wallet/internal/app"
+	"github.com/ichigo7diabol/go-test-wallet/internal/database"
+	"github.com/ichigo7diabol/go-test-wallet/internal/migrations"
+	"github.com/ichigo7diabol/go-test-wallet/internal/models"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// setupSQLiteFileDB opens a migrated SQLite file in a temporary directory.
+// Writers wait longer than database.BusyTimeout for the lock, since the race
+// detector slows the concurrency suites down enough to exceed it.
+func setupSQLiteFileDB(t testing.TB) *gorm.DB {
+	db, err := database.Open("sqlite://" + filepath.Join(t.TempDir(), "wallet.db") + "?_busy_timeout=60000")
+	require.NoError(t, err)
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	m, err := migrations.New(db)
+	require.NoError(t, err)
+	_, err = m.Up(context.Background())
+	require.NoError(t, err)
+	return db
+}
+
+// TestSQLiteFile_WriterWaitsForOpenTransaction checks the stand-in for
+// SELECT ... FOR UPDATE: a deposit must not read the balance while another
+// transaction that has read it is still open.
+func TestSQLiteFile_WriterWaitsForOpenTransaction(t *testing.T) {
+	db := setupSQLiteFileDB(t)
+	repo := app.NewRepository(db)
+	w, err := repo.Create(models.MoneyFromInt(100), "USD", "user-1")
+	require.NoError(t, err)
+
+	tx := db.Begin()
+	require.NoError(t, tx.Error)
+	var locked models.WalletModel
+	require.NoError(t, tx.First(&locked, "id = ?", w.ID).Error)
+
+	done := make(chan error, 1)
+	go func() {
+		_, _, _, err := repo.Deposit(w.ID, models.MoneyFromInt(3), "USD", nil)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("deposit finished while another transaction was open: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	balance, err := locked.Balance.Add(models.MoneyFromInt(10))
+	require.NoError(t, err)
+	require.NoError(t, tx.Model(&locked).Update("balance", balance).Error)
+	require.NoError(t, tx.Commit().Error)
+	require.NoError(t, <-done)
+
+	got, err := repo.GetByID(w.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.MoneyFromInt(113), got.Balance)
+}
+
+// TestSQLiteFile_ConcurrentChanges runs deposits and withdrawals over several
+// connections to a file database, where no row lock protects the
+// read-modify-write and only the serialized transactions prevent lost updates.
+func TestSQLiteFile_ConcurrentChanges(t *testing.T) {
+	db := setupSQLiteFileDB(t)
+	repo := app.NewRepository(db)
+	w, err := repo.Create(models.MoneyFromInt(100), "USD", "user-1")
+	require.NoError(t, err)
+
+	const n = 50
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*n)
+	for range n {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_, _, _, err := repo.Deposit(w.ID, models.MoneyFromInt(3), "USD", nil)
+			errs <- err
+		}()
+		go func() {
+			defer wg.Done()
+			_, _, _, err := repo.Withdraw(w.ID, models.MoneyFromInt(1), "USD", nil)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	got, err := repo.GetByID(w.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.MoneyFromInt(100+2*n), got.Balance)
+}
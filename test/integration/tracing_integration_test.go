@@ -0,0 +1,158 @@
+//go:build integration
+
+package integration_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/ichigo7diabol/go-test-wallet/api/handlers"
+	"github.com/ichigo7diabol/go-test-wallet/api/middleware"
+	"github.com/ichigo7diabol/go-test-wallet/api/openapi"
+	"github.com/ichigo7diabol/go-test-wallet/internal/app"
+	"github.com/ichigo7diabol/go-test-wallet/internal/models"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/plugin/opentelemetry/tracing"
+)
+
+const (
+	callerTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	callerSpanID  = "00f067aa0ba902b7"
+)
+
+var (
+	recorderOnce sync.Once
+	recorder     *tracetest.SpanRecorder
+)
+
+// setupTracedServer serves the API over a traced SQLite database, recording
+// every span in the returned recorder. The recorder's provider is installed
+// globally once: tracers obtained from the global provider keep delegating
+// to the first one set.
+func setupTracedServer(t *testing.T) (*echo.Echo, *app.RepositoryService, *tracetest.SpanRecorder) {
+	recorderOnce.Do(func() {
+		recorder = tracetest.NewSpanRecorder()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+		otel.SetTextMapPropagator(propagation.TraceContext{})
+	})
+
+	db := setupSQLiteFileDB(t)
+	require.NoError(t, db.Use(tracing.NewPlugin(tracing.WithoutMetrics())))
+	repo := app.NewRepository(db)
+
+	e := echo.New()
+	e.Use(middleware.Tracing("wallet-test"))
+	h := handlers.NewWalletHandler(app.NewWalletService(repo, app.WalletServiceOptions{}))
+	openapi.RegisterHandlersWithBaseURL(e.Group("/api/v1"), h, "")
+	recorder.Reset()
+	return e, repo, recorder
+}
+
+// sendTraced serves a request that continues the caller's trace.
+func sendTraced(e *echo.Echo, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set("traceparent", "00-"+callerTraceID+"-"+callerSpanID+"-01")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+// spansNamed returns the ended spans called name.
+func spansNamed(recorder *tracetest.SpanRecorder, name string) []sdktrace.ReadOnlySpan {
+	var spans []sdktrace.ReadOnlySpan
+	for _, s := range recorder.Ended() {
+		if s.Name() == name {
+			spans = append(spans, s)
+		}
+	}
+	return spans
+}
+
+func spanNamed(t *testing.T, recorder *tracetest.SpanRecorder, name string) sdktrace.ReadOnlySpan {
+	spans := spansNamed(recorder, name)
+	require.Len(t, spans, 1, "spans named %q", name)
+	return spans[0]
+}
+
+func attrs(s sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	m := make(map[attribute.Key]attribute.Value)
+	for _, kv := range s.Attributes() {
+		m[kv.Key] = kv.Value
+	}
+	return m
+}
+
+func TestTracing_RequestToDatabase(t *testing.T) {
+	e, repo, recorder := setupTracedServer(t)
+	w, err := repo.Create(models.MoneyFromInt(10), "USD", "user-1")
+	require.NoError(t, err)
+	recorder.Reset()
+
+	rec := sendTraced(e, http.MethodPost, "/api/v1/wallet",
+		`{"walletId":"`+w.ID.String()+`","operationType":"DEPOSIT","amount":"1","currency":"USD"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	for _, s := range recorder.Ended() {
+		assert.Equal(t, callerTraceID, s.SpanContext().TraceID().String(), "trace of %s", s.Name())
+	}
+
+	server := spanNamed(t, recorder, "POST /api/v1/wallet")
+	assert.Equal(t, trace.SpanKindServer, server.SpanKind())
+	assert.Equal(t, callerSpanID, server.Parent().SpanID().String())
+	assert.Equal(t, "/api/v1/wallet", attrs(server)["http.route"].AsString())
+	assert.Equal(t, int64(http.StatusOK), attrs(server)["http.response.status_code"].AsInt64())
+
+	service := spanNamed(t, recorder, "WalletService.ChangeBalance")
+	assert.Equal(t, server.SpanContext().SpanID(), service.Parent().SpanID())
+	assert.Equal(t, w.ID.String(), attrs(service)[app.WalletIDKey].AsString())
+	assert.Equal(t, string(app.DepositOperation), attrs(service)[app.OperationKey].AsString())
+
+	lock := spanNamed(t, recorder, "RepositoryService.lockWallet")
+	assert.Equal(t, service.SpanContext().SpanID(), lock.Parent().SpanID())
+	assert.Equal(t, w.ID.String(), attrs(lock)[app.WalletIDKey].AsString())
+	assert.Contains(t, attrs(lock), app.LockWaitTimeKey)
+
+	// The locking read runs under the lock span, the writes under the
+	// service span.
+	var lockedRead bool
+	for _, s := range spansNamed(recorder, "select wallet_models") {
+		lockedRead = lockedRead || s.Parent().SpanID() == lock.SpanContext().SpanID()
+	}
+	assert.True(t, lockedRead, "no select under the lock span")
+	for _, name := range []string{"update wallet_models", "insert transaction_models"} {
+		db := spanNamed(t, recorder, name)
+		assert.Equal(t, trace.SpanKindClient, db.SpanKind(), name)
+		assert.Equal(t, service.SpanContext().SpanID(), db.Parent().SpanID(), name)
+		assert.Equal(t, "sqlite", attrs(db)["db.system.name"].AsString(), name)
+	}
+}
+
+func TestTracing_ErrorStatus(t *testing.T) {
+	e, _, recorder := setupTracedServer(t)
+	id := uuid.New()
+
+	rec := sendTraced(e, http.MethodGet, "/api/v1/wallet/"+id.String(), "")
+	require.Equal(t, http.StatusNotFound, rec.Code)
+
+	server := spanNamed(t, recorder, "GET /api/v1/wallet/:walletId")
+	assert.Equal(t, int64(http.StatusNotFound), attrs(server)["http.response.status_code"].AsInt64())
+	service := spanNamed(t, recorder, "WalletService.GetWallet")
+	assert.Equal(t, server.SpanContext().SpanID(), service.Parent().SpanID())
+	assert.Equal(t, id.String(), attrs(service)[app.WalletIDKey].AsString())
+	assert.Equal(t, codes.Error, service.Status().Code)
+	assert.Equal(t, app.ErrWalletNotFound.Error(), service.Status().Description)
+}